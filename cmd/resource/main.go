@@ -5,7 +5,6 @@ package main
 
 import (
 	"context"
-	"fmt"
 	"log"
 	"os"
 	"path/filepath"
@@ -32,64 +31,76 @@ func main() {
 
 type concourseResource struct{}
 
-func (concourseResource) Check(ocSource ofcourse.Source, version ofcourse.Version, env ofcourse.Environment, logger *ofcourse.Logger) ([]ofcourse.Version, error) {
+func (concourseResource) Check(ocSource ofcourse.Source, version ofcourse.Version, env ofcourse.Environment, _ *ofcourse.Logger) ([]ofcourse.Version, error) {
 	ctx := context.Background()
 
-	k8sSource, err := k8s.NewSource(ocSource)
+	var k8sSource k8s.Source
+	source, err := resource.NewSource(ocSource, &k8sSource)
 	if err != nil {
 		return nil, err
 	}
 
-	clientSet, _, err := k8s.Authenticate(k8sSource)
+	logger, err := resource.NewLogger(os.Stderr, source, env)
 	if err != nil {
 		return nil, err
 	}
 
-	source, err := resource.NewSource(ocSource)
+	clientSet, k8sClient, err := k8s.Authenticate(k8sSource)
 	if err != nil {
 		return nil, err
 	}
 
+	if len(version) == 0 && !source.SkipPreflight {
+		err = resource.Preflight(ctx, k8sClient, source, logger)
+		if err != nil {
+			return nil, err
+		}
+	}
+
 	return resource.Check(ctx, clientSet, source, version, env, logger)
 }
 
-func (concourseResource) In(outDir string, ocSource ofcourse.Source, params ofcourse.Params, version ofcourse.Version, env ofcourse.Environment, logger *ofcourse.Logger) (ofcourse.Version, ofcourse.Metadata, error) {
+func (concourseResource) In(outDir string, ocSource ofcourse.Source, params ofcourse.Params, version ofcourse.Version, env ofcourse.Environment, _ *ofcourse.Logger) (ofcourse.Version, ofcourse.Metadata, error) {
 	ctx := context.Background()
 
-	k8sSource, err := k8s.NewSource(ocSource)
+	var k8sSource k8s.Source
+	source, err := resource.NewSource(ocSource, &k8sSource)
 	if err != nil {
 		return nil, nil, err
 	}
 
-	clientSet, _, err := k8s.Authenticate(k8sSource)
+	logger, err := resource.NewLogger(os.Stderr, source, env)
 	if err != nil {
 		return nil, nil, err
 	}
 
-	source, err := resource.NewSource(ocSource)
+	clientSet, k8sClient, err := k8s.Authenticate(k8sSource)
 	if err != nil {
 		return nil, nil, err
 	}
 
 	return (&resource.In{
-		Clientset: clientSet,
+		Clientset:  clientSet,
+		KubeClient: k8sClient,
 	}).In(ctx, outDir, source, params, version, env, logger)
 }
 
-func (concourseResource) Out(inDir string, ofcourseSource ofcourse.Source, params ofcourse.Params, env ofcourse.Environment, logger *ofcourse.Logger) (ofcourse.Version, ofcourse.Metadata, error) {
+func (concourseResource) Out(inDir string, ofcourseSource ofcourse.Source, params ofcourse.Params, env ofcourse.Environment, _ *ofcourse.Logger) (ofcourse.Version, ofcourse.Metadata, error) {
 	ctx := context.Background()
 
-	k8sSource, err := k8s.NewSource(ofcourseSource)
+	var k8sSource k8s.Source
+	source, err := resource.NewSource(ofcourseSource, &k8sSource)
 	if err != nil {
 		return nil, nil, err
 	}
 
-	clientSet, k8sClient, err := k8s.Authenticate(k8sSource)
+	logger, err := resource.NewLogger(os.Stderr, source, env)
 	if err != nil {
 		return nil, nil, err
 	}
+	defer logger.Flush()
 
-	source, err := resource.NewSource(ofcourseSource)
+	clientSet, k8sClient, err := k8s.Authenticate(k8sSource)
 	if err != nil {
 		return nil, nil, err
 	}
@@ -99,19 +110,15 @@ func (concourseResource) Out(inDir string, ofcourseSource ofcourse.Source, param
 		return nil, nil, err
 	}
 
+	kpackClient, err := resource.NewKpackClient(ctx, clientSet)
+	if err != nil {
+		return nil, nil, err
+	}
+
 	return (&resource.Out{
 		Clientset:   clientSet,
-		ImageWaiter: logs.NewImageWaiter(clientSet, logs.NewBuildLogsClient(k8sClient)),
-	}).Out(ctx, inDir, source, outParams, env, Logger{})
-}
-
-type Logger struct {
-}
-
-func (Logger) Infof(message string, args ...interface{}) {
-	fmt.Fprintf(os.Stderr, message, args...)
-}
-
-func (Logger) Debugf(message string, args ...interface{}) {
-	fmt.Fprintf(os.Stderr, message, args...)
+		KubeClient:  k8sClient,
+		ImageWaiter: resource.NewImageWaiter(kpackClient, logs.NewBuildLogsClient(k8sClient)),
+		LogWriter:   logger,
+	}).Out(ctx, inDir, source, outParams, env, logger)
 }
@@ -0,0 +1,205 @@
+// Copyright 2020-Present VMware, Inc.
+// SPDX-License-Identifier: Apache-2.0
+
+// Package config strictly decodes resource configuration, reporting every
+// unknown key and invalid value at once.
+package config
+
+import (
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+)
+
+// Validator is implemented by configuration that checks its own values. It
+// returns a problem for every invalid or missing key.
+type Validator interface {
+	Validate() []string
+}
+
+// Error lists every problem with a configuration object.
+type Error struct {
+	Name     string
+	Problems []string
+}
+
+func (e *Error) Error() string {
+	return fmt.Sprintf("invalid %s:\n  - %s", e.Name, strings.Join(e.Problems, "\n  - "))
+}
+
+// Decode decodes raw into each of targets, which share its keys. A key is
+// unknown when none of the targets has a field for it. Targets that are
+// Validators are validated once decoded.
+func Decode(name string, raw interface{}, targets ...interface{}) error {
+	contents, err := json.Marshal(raw)
+	if err != nil {
+		return err
+	}
+
+	var problems []string
+
+	var object interface{}
+	if err := json.Unmarshal(contents, &object); err != nil {
+		return err
+	}
+	if object == nil {
+		contents = []byte("{}")
+	} else if values, ok := object.(map[string]interface{}); ok {
+		types := make([]reflect.Type, 0, len(targets))
+		for _, target := range targets {
+			types = append(types, reflect.TypeOf(target).Elem())
+		}
+		problems = append(problems, unknownKeys("", values, types)...)
+	}
+
+	decoded := true
+	for _, target := range targets {
+		if err := json.Unmarshal(contents, target); err != nil {
+			problems = append(problems, describe(err))
+			decoded = false
+		}
+	}
+
+	// Values that failed to decode would also be reported as missing.
+	if decoded {
+		for _, target := range targets {
+			if validator, ok := target.(Validator); ok {
+				problems = append(problems, validator.Validate()...)
+			}
+		}
+	}
+
+	if len(problems) > 0 {
+		return &Error{Name: name, Problems: problems}
+	}
+	return nil
+}
+
+func describe(err error) string {
+	switch err := err.(type) {
+	case *json.UnmarshalTypeError:
+		return fmt.Sprintf("key '%s' must be %s, got %s", err.Field, kindName(err.Type), err.Value)
+	default:
+		return err.Error()
+	}
+}
+
+func kindName(t reflect.Type) string {
+	switch t.Kind() {
+	case reflect.Bool:
+		return "a boolean"
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return "an integer"
+	case reflect.Float32, reflect.Float64:
+		return "a number"
+	case reflect.Slice, reflect.Array:
+		return "a list"
+	case reflect.Map, reflect.Struct:
+		return "an object"
+	default:
+		return "a " + t.Kind().String()
+	}
+}
+
+// unknownKeys reports keys of values that are not a field of any of types,
+// recursing into the objects and lists of known keys.
+func unknownKeys(path string, values map[string]interface{}, types []reflect.Type) []string {
+	keys := make([]string, 0, len(values))
+	for key := range values {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	var problems []string
+	for _, key := range keys {
+		var fieldTypes []reflect.Type
+		for _, t := range types {
+			if fieldType, ok := fieldByKey(t, key); ok {
+				fieldTypes = append(fieldTypes, fieldType)
+			}
+		}
+
+		if len(fieldTypes) == 0 {
+			problems = append(problems, fmt.Sprintf("unknown key '%s'", path+key))
+			continue
+		}
+		problems = append(problems, unknownNestedKeys(path+key, values[key], fieldTypes)...)
+	}
+	return problems
+}
+
+func unknownNestedKeys(path string, value interface{}, types []reflect.Type) []string {
+	switch value := value.(type) {
+	case map[string]interface{}:
+		var structs []reflect.Type
+		for _, t := range types {
+			if t = indirect(t); t.Kind() == reflect.Struct {
+				structs = append(structs, t)
+			}
+		}
+		if len(structs) == 0 {
+			return nil
+		}
+		return unknownKeys(path+".", value, structs)
+	case []interface{}:
+		var elems []reflect.Type
+		for _, t := range types {
+			if t = indirect(t); t.Kind() == reflect.Slice || t.Kind() == reflect.Array {
+				elems = append(elems, t.Elem())
+			}
+		}
+		if len(elems) == 0 {
+			return nil
+		}
+
+		var problems []string
+		for i, item := range value {
+			problems = append(problems, unknownNestedKeys(fmt.Sprintf("%s[%d]", path, i), item, elems)...)
+		}
+		return problems
+	default:
+		return nil
+	}
+}
+
+// fieldByKey finds the type of the field of t that decodes key, following
+// the case-insensitive matching and embedded structs of encoding/json.
+func fieldByKey(t reflect.Type, key string) (reflect.Type, bool) {
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+
+		tag := field.Tag.Get("json")
+		if tag == "-" {
+			continue
+		}
+		name := strings.Split(tag, ",")[0]
+
+		if field.Anonymous && name == "" && indirect(field.Type).Kind() == reflect.Struct {
+			if fieldType, ok := fieldByKey(indirect(field.Type), key); ok {
+				return fieldType, true
+			}
+			continue
+		}
+
+		if field.PkgPath != "" {
+			continue
+		}
+		if name == "" {
+			name = field.Name
+		}
+		if strings.EqualFold(name, key) {
+			return field.Type, true
+		}
+	}
+	return nil, false
+}
+
+func indirect(t reflect.Type) reflect.Type {
+	for t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	return t
+}
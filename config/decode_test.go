@@ -0,0 +1,105 @@
+// Copyright 2020-Present VMware, Inc.
+// SPDX-License-Identifier: Apache-2.0
+
+package config_test
+
+import (
+	"testing"
+
+	"github.com/sclevine/spec"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/pivotal/concourse-kpack-resource/config"
+)
+
+func TestDecode(t *testing.T) {
+	spec.Run(t, "TestDecode", testDecode)
+}
+
+type credentials struct {
+	Username string `json:"username,omitempty"`
+}
+
+type item struct {
+	Name string `json:"name"`
+}
+
+type first struct {
+	Name  string  `json:"name"`
+	Items []item  `json:"items,omitempty"`
+	Ptr   *nested `json:"ptr,omitempty"`
+}
+
+type nested struct {
+	Value int `json:"value"`
+	credentials
+}
+
+type second struct {
+	Other string `json:"other"`
+}
+
+func (s second) Validate() []string {
+	if s.Other == "" {
+		return []string{"missing required key 'other'"}
+	}
+	return nil
+}
+
+func testDecode(t *testing.T, when spec.G, it spec.S) {
+	it("decodes the keys shared by every target", func() {
+		var a first
+		var b second
+		err := config.Decode("source", map[string]interface{}{
+			"name":  "a",
+			"other": "b",
+			"ptr":   map[string]interface{}{"value": 1, "username": "user"},
+		}, &a, &b)
+		require.NoError(t, err)
+
+		assert.Equal(t, first{Name: "a", Ptr: &nested{Value: 1, credentials: credentials{Username: "user"}}}, a)
+		assert.Equal(t, second{Other: "b"}, b)
+	})
+
+	it("reports unknown keys in nested objects and lists", func() {
+		var a first
+		var b second
+		err := config.Decode("source", map[string]interface{}{
+			"nme":   "a",
+			"other": "b",
+			"items": []interface{}{
+				map[string]interface{}{"name": "one"},
+				map[string]interface{}{"nam": "two"},
+			},
+			"ptr": map[string]interface{}{"valu": 1},
+		}, &a, &b)
+
+		require.EqualError(t, err, "invalid source:\n"+
+			"  - unknown key 'items[1].nam'\n"+
+			"  - unknown key 'nme'\n"+
+			"  - unknown key 'ptr.valu'")
+	})
+
+	it("validates targets", func() {
+		var a first
+		var b second
+		err := config.Decode("source", map[string]interface{}{"name": "a"}, &a, &b)
+
+		require.EqualError(t, err, "invalid source:\n  - missing required key 'other'")
+	})
+
+	it("validates an empty configuration", func() {
+		var b second
+		err := config.Decode("source", nil, &b)
+
+		require.EqualError(t, err, "invalid source:\n  - missing required key 'other'")
+	})
+
+	it("reports values of the wrong type", func() {
+		var a first
+		err := config.Decode("params", map[string]interface{}{"ptr": map[string]interface{}{"value": "one"}}, &a)
+
+		require.EqualError(t, err, "invalid params:\n  - key 'ptr.value' must be an integer, got string")
+	})
+}
@@ -35,8 +35,14 @@ func restConfig(source Source) (*rest.Config, error) {
 		return pksSetup(source.TKGI)
 	case source.GKE != nil:
 		return gkeSetup(source.GKE)
+	case source.EKS != nil:
+		return eksSetup(source.EKS)
+	case source.OIDC != nil:
+		return oidcSetup(source.OIDC)
 	case source.Kubeconfig != "":
 		return kubeConfigSetup(source.Kubeconfig)
+	case source.InCluster:
+		return rest.InClusterConfig()
 	default:
 		return nil, errors.New("no valid cluster config provided")
 	}
@@ -0,0 +1,32 @@
+// Copyright 2020-Present VMware, Inc.
+// SPDX-License-Identifier: Apache-2.0
+
+package k8s
+
+import (
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/endpoints"
+	"github.com/aws/aws-sdk-go/aws/session"
+)
+
+// awsEndpoint overrides the endpoint of every aws service when set.
+var awsEndpoint = ""
+
+// awsSession resolves credentials with the aws sdk default chain: static
+// environment variables, shared config, a projected web identity token
+// (IRSA) and container or ec2 instance roles. Sts requests go to the
+// regional endpoint, as aws-iam-authenticator does.
+func awsSession(region string) (*session.Session, error) {
+	config := aws.Config{
+		Region:              aws.String(region),
+		STSRegionalEndpoint: endpoints.RegionalSTSEndpoint,
+	}
+	if awsEndpoint != "" {
+		config.Endpoint = aws.String(awsEndpoint)
+	}
+
+	return session.NewSessionWithOptions(session.Options{
+		Config:            config,
+		SharedConfigState: session.SharedConfigEnable,
+	})
+}
@@ -0,0 +1,151 @@
+// Copyright 2020-Present VMware, Inc.
+// SPDX-License-Identifier: Apache-2.0
+
+package k8s
+
+import (
+	"encoding/base64"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws/credentials"
+	"github.com/sclevine/spec"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	restclient "k8s.io/client-go/rest"
+)
+
+func TestAWS(t *testing.T) {
+	spec.Run(t, "TestAWS", testAWS)
+}
+
+func testAWS(t *testing.T, when spec.G, it spec.S) {
+	it("creates eks tokens from a presigned sts request", func() {
+		sess, err := awsSession("us-west-2")
+		require.NoError(t, err)
+		sess.Config.Credentials = credentials.NewStaticCredentials("AKIDEXAMPLE", "wJalrXUtnFEMI/K7MDENG+bPxRfiCYEXAMPLEKEY", "some-session-token")
+
+		token, err := eksToken(sess, "some-cluster")
+		require.NoError(t, err)
+
+		require.True(t, strings.HasPrefix(token.AccessToken, "k8s-aws-v1."))
+		presigned, err := base64.RawURLEncoding.DecodeString(strings.TrimPrefix(token.AccessToken, "k8s-aws-v1."))
+		require.NoError(t, err)
+
+		presignedUrl, err := url.Parse(string(presigned))
+		require.NoError(t, err)
+		assert.Equal(t, "sts.us-west-2.amazonaws.com", presignedUrl.Host)
+
+		query := presignedUrl.Query()
+		assert.Equal(t, "GetCallerIdentity", query.Get("Action"))
+		assert.True(t, strings.HasPrefix(query.Get("X-Amz-Credential"), "AKIDEXAMPLE/"), query.Get("X-Amz-Credential"))
+		assert.True(t, strings.HasSuffix(query.Get("X-Amz-Credential"), "/us-west-2/sts/aws4_request"), query.Get("X-Amz-Credential"))
+		assert.Equal(t, "host;x-k8s-aws-id", query.Get("X-Amz-SignedHeaders"))
+		assert.Equal(t, "some-session-token", query.Get("X-Amz-Security-Token"))
+		assert.Equal(t, "60", query.Get("X-Amz-Expires"))
+		assert.Len(t, query.Get("X-Amz-Signature"), 64)
+		assert.WithinDuration(t, time.Now().Add(14*time.Minute), token.Expiry, time.Minute)
+	})
+
+	when("using ambient credentials", func() {
+		var (
+			server *httptest.Server
+			dir    string
+			env    = map[string]string{}
+			setenv func(key, value string)
+		)
+
+		setenv = func(key, value string) {
+			if _, ok := env[key]; !ok {
+				env[key] = os.Getenv(key)
+			}
+			require.NoError(t, os.Setenv(key, value))
+		}
+
+		it.Before(func() {
+			server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				switch {
+				case r.Method == http.MethodPost && r.URL.Path == "/":
+					require.NoError(t, r.ParseForm())
+					assert.Equal(t, "AssumeRoleWithWebIdentity", r.PostForm.Get("Action"))
+					assert.Equal(t, "arn:aws:iam::123456789012:role/some-role", r.PostForm.Get("RoleArn"))
+					assert.Equal(t, "some-web-identity-token", r.PostForm.Get("WebIdentityToken"))
+					_, _ = w.Write([]byte(`<AssumeRoleWithWebIdentityResponse><AssumeRoleWithWebIdentityResult><Credentials>
+<AccessKeyId>ASIAEXAMPLE</AccessKeyId><SecretAccessKey>some-secret</SecretAccessKey><SessionToken>some-session</SessionToken>
+<Expiration>2100-01-01T00:00:00Z</Expiration></Credentials></AssumeRoleWithWebIdentityResult></AssumeRoleWithWebIdentityResponse>`))
+				case r.URL.Path == "/clusters/some-cluster":
+					assert.Contains(t, r.Header.Get("Authorization"), "Credential=ASIAEXAMPLE/")
+					assert.Contains(t, r.Header.Get("Authorization"), "/us-west-2/eks/aws4_request")
+					assert.Equal(t, "some-session", r.Header.Get("X-Amz-Security-Token"))
+					_, _ = w.Write([]byte(`{"cluster":{"endpoint":"https://eks.example.com","certificateAuthority":{"data":"` + base64.StdEncoding.EncodeToString([]byte("some-ca")) + `"}}}`))
+				default:
+					w.WriteHeader(http.StatusNotFound)
+				}
+			}))
+			awsEndpoint = server.URL
+
+			var err error
+			dir, err = ioutil.TempDir("", "aws_test")
+			require.NoError(t, err)
+			tokenFile := filepath.Join(dir, "token")
+			require.NoError(t, ioutil.WriteFile(tokenFile, []byte("some-web-identity-token"), 0600))
+
+			setenv("AWS_WEB_IDENTITY_TOKEN_FILE", tokenFile)
+			setenv("AWS_ROLE_ARN", "arn:aws:iam::123456789012:role/some-role")
+			setenv("AWS_CONFIG_FILE", filepath.Join(dir, "config"))
+			setenv("AWS_SHARED_CREDENTIALS_FILE", filepath.Join(dir, "credentials"))
+			setenv("AWS_EC2_METADATA_DISABLED", "true")
+			setenv("AWS_ACCESS_KEY_ID", "")
+			setenv("AWS_SECRET_ACCESS_KEY", "")
+			setenv("AWS_PROFILE", "")
+		})
+
+		it.After(func() {
+			awsEndpoint = ""
+			server.Close()
+			os.RemoveAll(dir)
+			for key, value := range env {
+				os.Setenv(key, value)
+			}
+		})
+
+		it("describes the eks cluster and authenticates with an eks token", func() {
+			config, err := eksSetup(&EKSSource{
+				Cluster: "some-cluster",
+				Region:  "us-west-2",
+			})
+			require.NoError(t, err)
+
+			assert.Equal(t, "https://eks.example.com", config.Host)
+			assert.Equal(t, []byte("some-ca"), config.CAData)
+
+			var authorization string
+			api := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				authorization = r.Header.Get("Authorization")
+			}))
+			defer api.Close()
+
+			config.Host = api.URL
+			config.CAData = nil
+			transport, err := restclient.TransportFor(config)
+			require.NoError(t, err)
+
+			resp, err := (&http.Client{Transport: transport}).Get(api.URL + "/api")
+			require.NoError(t, err)
+			resp.Body.Close()
+
+			assert.True(t, strings.HasPrefix(authorization, "Bearer k8s-aws-v1."), authorization)
+
+			presigned, err := base64.RawURLEncoding.DecodeString(strings.TrimPrefix(authorization, "Bearer k8s-aws-v1."))
+			require.NoError(t, err)
+			assert.Contains(t, string(presigned), "X-Amz-Credential=ASIAEXAMPLE")
+		})
+	})
+}
@@ -0,0 +1,152 @@
+// Copyright 2020-Present VMware, Inc.
+// SPDX-License-Identifier: Apache-2.0
+
+package k8s
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"golang.org/x/oauth2"
+	"golang.org/x/oauth2/clientcredentials"
+	clientcmdapi "k8s.io/client-go/tools/clientcmd/api"
+)
+
+const jwtBearerAssertionType = "urn:ietf:params:oauth:client-assertion-type:jwt-bearer"
+
+var (
+	azureAuthorityHost    = "https://login.microsoftonline.com"
+	azureMetadataEndpoint = "http://169.254.169.254"
+)
+
+// azureExecTokenSource implements the non-interactive login modes of kubelogin.
+func azureExecTokenSource(exec *clientcmdapi.ExecConfig) (oauth2.TokenSource, error) {
+	serverID := execArg(exec.Args, "--server-id")
+	if serverID == "" {
+		return nil, fmt.Errorf("%s requires --server-id", azureAuthPlugin)
+	}
+	scope := serverID + "/.default"
+
+	authorityHost := execArg(exec.Args, "--authority-host")
+	if authorityHost == "" {
+		authorityHost = execEnv(exec, "AZURE_AUTHORITY_HOST")
+	}
+	if authorityHost == "" {
+		authorityHost = azureAuthorityHost
+	}
+	tenantID := execArg(exec.Args, "-t", "--tenant-id")
+	if tenantID == "" {
+		tenantID = execEnv(exec, "AZURE_TENANT_ID")
+	}
+	tokenUrl := strings.TrimSuffix(authorityHost, "/") + "/" + tenantID + "/oauth2/v2.0/token"
+
+	clientID := execArg(exec.Args, "--client-id")
+
+	switch login := execArg(exec.Args, "-l", "--login"); login {
+	case "spn":
+		if clientID == "" {
+			clientID = execEnv(exec, "AAD_SERVICE_PRINCIPAL_CLIENT_ID", "AZURE_CLIENT_ID")
+		}
+		clientSecret := execArg(exec.Args, "--client-secret")
+		if clientSecret == "" {
+			clientSecret = execEnv(exec, "AAD_SERVICE_PRINCIPAL_CLIENT_SECRET", "AZURE_CLIENT_SECRET")
+		}
+
+		return (&clientcredentials.Config{
+			ClientID:     clientID,
+			ClientSecret: clientSecret,
+			TokenURL:     tokenUrl,
+			Scopes:       []string{scope},
+			AuthStyle:    oauth2.AuthStyleInParams,
+		}).TokenSource(context.Background()), nil
+	case "workloadidentity":
+		if clientID == "" {
+			clientID = execEnv(exec, "AZURE_CLIENT_ID")
+		}
+		tokenFile := execArg(exec.Args, "--federated-token-file")
+		if tokenFile == "" {
+			tokenFile = execEnv(exec, "AZURE_FEDERATED_TOKEN_FILE")
+		}
+
+		assertion, err := ioutil.ReadFile(tokenFile)
+		if err != nil {
+			return nil, fmt.Errorf("reading federated token: %w", err)
+		}
+
+		return (&clientcredentials.Config{
+			ClientID: clientID,
+			TokenURL: tokenUrl,
+			Scopes:   []string{scope},
+			EndpointParams: url.Values{
+				"client_assertion_type": []string{jwtBearerAssertionType},
+				"client_assertion":      []string{strings.TrimSpace(string(assertion))},
+			},
+			AuthStyle: oauth2.AuthStyleInParams,
+		}).TokenSource(context.Background()), nil
+	case "msi":
+		return azureManagedIdentityTokenSource{resource: serverID, clientID: clientID}, nil
+	default:
+		return nil, fmt.Errorf("%s login '%s' is not supported: use spn, workloadidentity or msi, or add the %s binary to %s", azureAuthPlugin, login, azureAuthPlugin, pluginDir)
+	}
+}
+
+type azureManagedIdentityTokenSource struct {
+	resource string
+	clientID string
+}
+
+func (s azureManagedIdentityTokenSource) Token() (*oauth2.Token, error) {
+	query := url.Values{
+		"api-version": []string{"2018-02-01"},
+		"resource":    []string{s.resource},
+	}
+	if s.clientID != "" {
+		query.Set("client_id", s.clientID)
+	}
+
+	req, err := http.NewRequest(http.MethodGet, azureMetadataEndpoint+"/metadata/identity/oauth2/token?"+query.Encode(), nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Metadata", "true")
+
+	resp, err := (&http.Client{Timeout: 5 * time.Second}).Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		body, _ := ioutil.ReadAll(resp.Body)
+		return nil, fmt.Errorf("failed to get managed identity token: %s: %s", resp.Status, body)
+	}
+
+	type tokenResponse struct {
+		AccessToken string `json:"access_token"`
+		ExpiresOn   string `json:"expires_on"`
+	}
+
+	var token tokenResponse
+	err = json.NewDecoder(resp.Body).Decode(&token)
+	if err != nil {
+		return nil, err
+	}
+
+	expiresOn, err := strconv.ParseInt(token.ExpiresOn, 10, 64)
+	if err != nil {
+		return nil, fmt.Errorf("parsing managed identity token expiry: %w", err)
+	}
+
+	return &oauth2.Token{
+		AccessToken: token.AccessToken,
+		TokenType:   "Bearer",
+		Expiry:      time.Unix(expiresOn, 0),
+	}, nil
+}
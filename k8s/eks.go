@@ -0,0 +1,114 @@
+// Copyright 2020-Present VMware, Inc.
+// SPDX-License-Identifier: Apache-2.0
+
+package k8s
+
+import (
+	"encoding/base64"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/eks"
+	"github.com/aws/aws-sdk-go/service/sts"
+	"golang.org/x/oauth2"
+	restclient "k8s.io/client-go/rest"
+)
+
+const (
+	eksTokenPrefix   = "k8s-aws-v1."
+	eksClusterHeader = "x-k8s-aws-id"
+	eksTokenLifetime = 14 * time.Minute
+	// eksPresignLifetime matches aws-iam-authenticator. EKS accepts tokens
+	// for 15 minutes from signing regardless.
+	eksPresignLifetime = time.Minute
+)
+
+func eksSetup(eks *EKSSource) (*restclient.Config, error) {
+	if eks.Cluster == "" || eks.Region == "" {
+		return nil, fmt.Errorf("eks requires a cluster and region")
+	}
+
+	sess, err := awsSession(eks.Region)
+	if err != nil {
+		return nil, err
+	}
+
+	config := &restclient.Config{
+		Host: eks.Endpoint,
+		TLSClientConfig: restclient.TLSClientConfig{
+			CAData: []byte(eks.CertificateAuthority),
+		},
+	}
+
+	if eks.Endpoint == "" {
+		config, err = eksClusterConfig(sess, eks.Cluster)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	tokenSource := oauth2.ReuseTokenSource(nil, eksTokenSource{cluster: eks.Cluster, region: eks.Region})
+	config.WrapTransport = func(rt http.RoundTripper) http.RoundTripper {
+		return &oauth2.Transport{Source: tokenSource, Base: rt}
+	}
+	return config, nil
+}
+
+func eksClusterConfig(sess *session.Session, cluster string) (*restclient.Config, error) {
+	described, err := eks.New(sess).DescribeCluster(&eks.DescribeClusterInput{Name: aws.String(cluster)})
+	if err != nil {
+		return nil, fmt.Errorf("failed to describe eks cluster '%s': %w", cluster, err)
+	}
+
+	var caData string
+	if described.Cluster.CertificateAuthority != nil {
+		caData = aws.StringValue(described.Cluster.CertificateAuthority.Data)
+	}
+
+	ca, err := base64.StdEncoding.DecodeString(caData)
+	if err != nil {
+		return nil, fmt.Errorf("decoding eks cluster ca certificate: %w", err)
+	}
+
+	return &restclient.Config{
+		Host: aws.StringValue(described.Cluster.Endpoint),
+		TLSClientConfig: restclient.TLSClientConfig{
+			CAData: ca,
+		},
+	}, nil
+}
+
+// eksTokenSource mints the presigned sts GetCallerIdentity tokens that
+// aws-iam-authenticator produces.
+type eksTokenSource struct {
+	cluster string
+	region  string
+}
+
+func (s eksTokenSource) Token() (*oauth2.Token, error) {
+	sess, err := awsSession(s.region)
+	if err != nil {
+		return nil, err
+	}
+
+	return eksToken(sess, s.cluster)
+}
+
+func eksToken(sess *session.Session, cluster string) (*oauth2.Token, error) {
+	request, _ := sts.New(sess).GetCallerIdentityRequest(&sts.GetCallerIdentityInput{})
+	request.HTTPRequest.Header.Add(eksClusterHeader, cluster)
+
+	presigned, err := request.Presign(eksPresignLifetime)
+	if err != nil {
+		return nil, fmt.Errorf("presigning eks token: %w", err)
+	}
+
+	return &oauth2.Token{
+		AccessToken: eksTokenPrefix + base64.RawURLEncoding.EncodeToString([]byte(presigned)),
+		TokenType:   "Bearer",
+		Expiry:      time.Now().Add(eksTokenLifetime),
+	}, nil
+}
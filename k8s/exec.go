@@ -0,0 +1,133 @@
+// Copyright 2020-Present VMware, Inc.
+// SPDX-License-Identifier: Apache-2.0
+
+package k8s
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"golang.org/x/oauth2"
+	"golang.org/x/oauth2/google"
+	restclient "k8s.io/client-go/rest"
+	clientcmdapi "k8s.io/client-go/tools/clientcmd/api"
+)
+
+// pluginDir is searched for kubeconfig exec credential plugins before the
+// native implementations and the PATH.
+var pluginDir = "/opt/resource/plugins"
+
+const (
+	gkeAuthPlugin   = "gke-gcloud-auth-plugin"
+	awsAuthPlugin   = "aws-iam-authenticator"
+	awsCli          = "aws"
+	azureAuthPlugin = "kubelogin"
+)
+
+// execSetup resolves the exec credential plugin of config, preferring a
+// binary in pluginDir over a native implementation of a common plugin.
+func execSetup(config *restclient.Config) (*restclient.Config, error) {
+	if config.ExecProvider == nil {
+		return config, nil
+	}
+
+	plugin := filepath.Join(pluginDir, filepath.Base(config.ExecProvider.Command))
+	if info, err := os.Stat(plugin); err == nil && !info.IsDir() {
+		config.ExecProvider.Command = plugin
+		return config, nil
+	}
+
+	tokenSource, err := nativeExecTokenSource(config.ExecProvider)
+	if err != nil {
+		return nil, err
+	} else if tokenSource == nil {
+		return config, nil
+	}
+
+	tokenSource = oauth2.ReuseTokenSource(nil, tokenSource)
+	config.ExecProvider = nil
+	config.WrapTransport = func(rt http.RoundTripper) http.RoundTripper {
+		return &oauth2.Transport{Source: tokenSource, Base: rt}
+	}
+	return config, nil
+}
+
+// nativeExecTokenSource returns nil if there is no native implementation
+// of the plugin or its arguments are not supported.
+func nativeExecTokenSource(exec *clientcmdapi.ExecConfig) (oauth2.TokenSource, error) {
+	switch filepath.Base(exec.Command) {
+	case gkeAuthPlugin:
+		creds, err := google.FindDefaultCredentials(context.Background(), cloudPlatformScope)
+		if err != nil {
+			return nil, fmt.Errorf("finding ambient credentials for %s: %w", gkeAuthPlugin, err)
+		}
+		return creds.TokenSource, nil
+	case awsAuthPlugin:
+		if len(exec.Args) == 0 || exec.Args[0] != "token" || execArg(exec.Args, "-r", "--role") != "" {
+			return nil, nil
+		}
+		return eksExecTokenSource(exec, execArg(exec.Args, "-i", "--cluster-id"))
+	case awsCli:
+		if len(exec.Args) < 2 || exec.Args[0] != "eks" || exec.Args[1] != "get-token" || execArg(exec.Args, "--role-arn") != "" {
+			return nil, nil
+		}
+		return eksExecTokenSource(exec, execArg(exec.Args, "--cluster-name"))
+	case azureAuthPlugin:
+		if len(exec.Args) == 0 || exec.Args[0] != "get-token" {
+			return nil, nil
+		}
+		return azureExecTokenSource(exec)
+	default:
+		return nil, nil
+	}
+}
+
+// eksExecTokenSource returns nil when the cluster or region is not in the
+// plugin's args or env, leaving the plugin to resolve them from its config.
+func eksExecTokenSource(exec *clientcmdapi.ExecConfig, cluster string) (oauth2.TokenSource, error) {
+	region := execArg(exec.Args, "--region")
+	if region == "" {
+		region = execEnv(exec, "AWS_REGION", "AWS_DEFAULT_REGION")
+	}
+
+	if cluster == "" || region == "" {
+		return nil, nil
+	}
+
+	return eksTokenSource{cluster: cluster, region: region}, nil
+}
+
+// execArg returns the value of the first flag found in args in either the
+// "--flag value" or "--flag=value" form.
+func execArg(args []string, flags ...string) string {
+	for i, arg := range args {
+		for _, flag := range flags {
+			if arg == flag && i+1 < len(args) {
+				return args[i+1]
+			}
+			if strings.HasPrefix(arg, flag+"=") {
+				return strings.TrimPrefix(arg, flag+"=")
+			}
+		}
+	}
+	return ""
+}
+
+// execEnv returns the first variable set in the plugin's env or the process env.
+func execEnv(exec *clientcmdapi.ExecConfig, names ...string) string {
+	for _, name := range names {
+		for _, env := range exec.Env {
+			if env.Name == name && env.Value != "" {
+				return env.Value
+			}
+		}
+		if value := os.Getenv(name); value != "" {
+			return value
+		}
+	}
+	return ""
+}
@@ -0,0 +1,168 @@
+// Copyright 2020-Present VMware, Inc.
+// SPDX-License-Identifier: Apache-2.0
+
+package k8s
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/sclevine/spec"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	restclient "k8s.io/client-go/rest"
+	clientcmdapi "k8s.io/client-go/tools/clientcmd/api"
+)
+
+func TestExec(t *testing.T) {
+	spec.Run(t, "TestExec", testExec)
+}
+
+func testExec(t *testing.T, when spec.G, it spec.S) {
+	var (
+		originalPluginDir = pluginDir
+		originalAuthority = azureAuthorityHost
+	)
+
+	it.Before(func() {
+		var err error
+		pluginDir, err = ioutil.TempDir("", "plugins")
+		require.NoError(t, err)
+	})
+
+	it.After(func() {
+		os.RemoveAll(pluginDir)
+		pluginDir = originalPluginDir
+		azureAuthorityHost = originalAuthority
+	})
+
+	it("prefers plugins in the plugin directory", func() {
+		plugin := filepath.Join(pluginDir, "aws-iam-authenticator")
+		require.NoError(t, ioutil.WriteFile(plugin, []byte("#!/bin/sh\n"), 0755))
+
+		config, err := execSetup(&restclient.Config{
+			ExecProvider: &clientcmdapi.ExecConfig{
+				Command: "aws-iam-authenticator",
+				Args:    []string{"token", "-i", "some-cluster"},
+			},
+		})
+		require.NoError(t, err)
+
+		assert.Equal(t, plugin, config.ExecProvider.Command)
+		assert.Nil(t, config.WrapTransport)
+	})
+
+	it("replaces aws-iam-authenticator with a native eks token source", func() {
+		config, err := execSetup(&restclient.Config{
+			ExecProvider: &clientcmdapi.ExecConfig{
+				Command: "aws-iam-authenticator",
+				Args:    []string{"token", "-i", "some-cluster", "--region=us-west-2"},
+			},
+		})
+		require.NoError(t, err)
+
+		assert.Nil(t, config.ExecProvider)
+		assert.NotNil(t, config.WrapTransport)
+	})
+
+	it("replaces aws eks get-token with a native eks token source", func() {
+		config, err := execSetup(&restclient.Config{
+			ExecProvider: &clientcmdapi.ExecConfig{
+				Command: "aws",
+				Args:    []string{"eks", "get-token", "--cluster-name", "some-cluster"},
+				Env:     []clientcmdapi.ExecEnvVar{{Name: "AWS_REGION", Value: "us-west-2"}},
+			},
+		})
+		require.NoError(t, err)
+
+		assert.Nil(t, config.ExecProvider)
+		assert.NotNil(t, config.WrapTransport)
+	})
+
+	it("leaves plugins without a native implementation", func() {
+		exec := &clientcmdapi.ExecConfig{
+			Command: "aws",
+			Args:    []string{"eks", "get-token", "--cluster-name", "some-cluster", "--role-arn", "some-role"},
+		}
+
+		config, err := execSetup(&restclient.Config{ExecProvider: exec})
+		require.NoError(t, err)
+
+		assert.Equal(t, exec, config.ExecProvider)
+		assert.Nil(t, config.WrapTransport)
+	})
+
+	it("leaves eks plugins without a region", func() {
+		for _, name := range []string{"AWS_REGION", "AWS_DEFAULT_REGION"} {
+			if value, ok := os.LookupEnv(name); ok {
+				require.NoError(t, os.Unsetenv(name))
+				defer os.Setenv(name, value)
+			}
+		}
+
+		exec := &clientcmdapi.ExecConfig{
+			Command: "aws-iam-authenticator",
+			Args:    []string{"token", "-i", "some-cluster"},
+		}
+
+		config, err := execSetup(&restclient.Config{ExecProvider: exec})
+		require.NoError(t, err)
+
+		assert.Equal(t, exec, config.ExecProvider)
+		assert.Nil(t, config.WrapTransport)
+	})
+
+	it("authenticates kubelogin service principals", func() {
+		var authorization string
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if r.URL.Path == "/some-tenant/oauth2/v2.0/token" {
+				require.NoError(t, r.ParseForm())
+				assert.Equal(t, "some-client", r.PostForm.Get("client_id"))
+				assert.Equal(t, "some-secret", r.PostForm.Get("client_secret"))
+				assert.Equal(t, "some-server-id/.default", r.PostForm.Get("scope"))
+				w.Header().Set("Content-Type", "application/json")
+				_, _ = w.Write([]byte(`{"access_token":"some-azure-token","token_type":"Bearer","expires_in":3600}`))
+				return
+			}
+			authorization = r.Header.Get("Authorization")
+		}))
+		defer server.Close()
+		azureAuthorityHost = server.URL
+
+		config, err := execSetup(&restclient.Config{
+			Host: server.URL,
+			ExecProvider: &clientcmdapi.ExecConfig{
+				Command: "kubelogin",
+				Args:    []string{"get-token", "--login", "spn", "--server-id", "some-server-id", "--tenant-id", "some-tenant"},
+				Env: []clientcmdapi.ExecEnvVar{
+					{Name: "AAD_SERVICE_PRINCIPAL_CLIENT_ID", Value: "some-client"},
+					{Name: "AAD_SERVICE_PRINCIPAL_CLIENT_SECRET", Value: "some-secret"},
+				},
+			},
+		})
+		require.NoError(t, err)
+
+		transport, err := restclient.TransportFor(config)
+		require.NoError(t, err)
+
+		resp, err := (&http.Client{Transport: transport}).Get(server.URL + "/api")
+		require.NoError(t, err)
+		resp.Body.Close()
+
+		assert.Equal(t, "Bearer some-azure-token", authorization)
+	})
+
+	it("returns an error for interactive kubelogin modes", func() {
+		_, err := execSetup(&restclient.Config{
+			ExecProvider: &clientcmdapi.ExecConfig{
+				Command: "kubelogin",
+				Args:    []string{"get-token", "--login", "devicecode", "--server-id", "some-server-id"},
+			},
+		})
+		require.EqualError(t, err, "kubelogin login 'devicecode' is not supported: use spn, workloadidentity or msi, or add the kubelogin binary to "+pluginDir)
+	})
+}
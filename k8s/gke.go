@@ -4,37 +4,126 @@
 package k8s
 
 import (
-	_ "k8s.io/client-go/plugin/pkg/client/auth/gcp"
-
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
 	"io/ioutil"
+	"net/http"
+	"path/filepath"
+
+	"golang.org/x/oauth2"
+	"golang.org/x/oauth2/google"
 	restclient "k8s.io/client-go/rest"
 	"k8s.io/client-go/tools/clientcmd"
-	"os"
 )
 
-const googleApplicationCredsEnv = "GOOGLE_APPLICATION_CREDENTIALS"
+const cloudPlatformScope = "https://www.googleapis.com/auth/cloud-platform"
+
+var gkeContainerApi = "https://container.googleapis.com"
 
 func gkeSetup(gke *GKESource) (*restclient.Config, error) {
-	file, err := ioutil.TempFile("", "")
+	creds, err := gkeCredentials(gke)
+	if err != nil {
+		return nil, err
+	}
+	tokenSource := oauth2.ReuseTokenSource(nil, creds.TokenSource)
+
+	var config *restclient.Config
+	if gke.Kubeconfig != "" {
+		config, err = gkeKubeconfig(gke.Kubeconfig)
+	} else {
+		config, err = gkeClusterConfig(gke, tokenSource)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	config.WrapTransport = func(rt http.RoundTripper) http.RoundTripper {
+		return &oauth2.Transport{Source: tokenSource, Base: rt}
+	}
+	return config, nil
+}
+
+// gkeCredentials uses the json_key when provided, falling back to ambient
+// credentials such as workload identity on the metadata server.
+func gkeCredentials(gke *GKESource) (*google.Credentials, error) {
+	if gke.JSONKey == "" {
+		creds, err := google.FindDefaultCredentials(context.Background(), cloudPlatformScope)
+		if err != nil {
+			return nil, fmt.Errorf("finding ambient gke credentials: %w", err)
+		}
+		return creds, nil
+	}
+
+	creds, err := google.CredentialsFromJSON(context.Background(), []byte(gke.JSONKey), cloudPlatformScope)
+	if err != nil {
+		return nil, fmt.Errorf("parsing gke json_key: %w", err)
+	}
+	return creds, nil
+}
+
+func gkeKubeconfig(kubeconfig string) (*restclient.Config, error) {
+	config, err := clientcmd.Load([]byte(kubeconfig))
 	if err != nil {
 		return nil, err
 	}
-	defer file.Close()
 
-	_, err = file.Write([]byte(gke.JSONKey))
+	restConfig, err := clientcmd.NewDefaultClientConfig(*config, &clientcmd.ConfigOverrides{}).ClientConfig()
 	if err != nil {
 		return nil, err
 	}
 
-	err = os.Setenv(googleApplicationCredsEnv, file.Name())
+	// Credentials are provided by the json_key token source rather than
+	// the deprecated gcp auth provider or the gke exec plugin.
+	if restConfig.AuthProvider != nil && restConfig.AuthProvider.Name == "gcp" {
+		restConfig.AuthProvider = nil
+	}
+	if restConfig.ExecProvider != nil && filepath.Base(restConfig.ExecProvider.Command) == gkeAuthPlugin {
+		restConfig.ExecProvider = nil
+	}
+	return restConfig, nil
+}
+
+func gkeClusterConfig(gke *GKESource, tokenSource oauth2.TokenSource) (*restclient.Config, error) {
+	if gke.Project == "" || gke.Location == "" || gke.Cluster == "" {
+		return nil, fmt.Errorf("gke requires either a kubeconfig or a project, location and cluster")
+	}
+
+	url := fmt.Sprintf("%s/v1/projects/%s/locations/%s/clusters/%s", gkeContainerApi, gke.Project, gke.Location, gke.Cluster)
+	resp, err := oauth2.NewClient(context.Background(), tokenSource).Get(url)
 	if err != nil {
 		return nil, err
 	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		body, _ := ioutil.ReadAll(resp.Body)
+		return nil, fmt.Errorf("failed to get gke cluster '%s': %s: %s", gke.Cluster, resp.Status, body)
+	}
+
+	type clusterResponse struct {
+		Endpoint   string `json:"endpoint"`
+		MasterAuth struct {
+			ClusterCaCertificate string `json:"clusterCaCertificate"`
+		} `json:"masterAuth"`
+	}
 
-	config, err := clientcmd.Load([]byte(gke.Kubeconfig))
+	var cluster clusterResponse
+	err = json.NewDecoder(resp.Body).Decode(&cluster)
 	if err != nil {
 		return nil, err
 	}
 
-	return clientcmd.NewDefaultClientConfig(*config, &clientcmd.ConfigOverrides{}).ClientConfig()
+	ca, err := base64.StdEncoding.DecodeString(cluster.MasterAuth.ClusterCaCertificate)
+	if err != nil {
+		return nil, fmt.Errorf("decoding gke cluster ca certificate: %w", err)
+	}
+
+	return &restclient.Config{
+		Host: "https://" + cluster.Endpoint,
+		TLSClientConfig: restclient.TLSClientConfig{
+			CAData: ca,
+		},
+	}, nil
 }
@@ -0,0 +1,136 @@
+// Copyright 2020-Present VMware, Inc.
+// SPDX-License-Identifier: Apache-2.0
+
+package k8s
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/sclevine/spec"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	restclient "k8s.io/client-go/rest"
+)
+
+func TestGKE(t *testing.T) {
+	spec.Run(t, "TestGKE", testGKE)
+}
+
+func testGKE(t *testing.T, when spec.G, it spec.S) {
+	var (
+		server          *httptest.Server
+		jsonKey         string
+		clusterRequests []string
+		originalApi     string
+	)
+
+	it.Before(func() {
+		clusterRequests = nil
+		server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			switch r.URL.Path {
+			case "/token":
+				w.Header().Set("Content-Type", "application/json")
+				_, _ = w.Write([]byte(`{"access_token":"some-access-token","token_type":"Bearer","expires_in":3600}`))
+			case "/v1/projects/some-project/locations/us-central1/clusters/some-cluster":
+				clusterRequests = append(clusterRequests, r.Header.Get("Authorization"))
+				_, _ = w.Write([]byte(`{"endpoint":"10.0.0.1","masterAuth":{"clusterCaCertificate":"` + base64.StdEncoding.EncodeToString([]byte("some-ca")) + `"}}`))
+			default:
+				w.WriteHeader(http.StatusNotFound)
+			}
+		}))
+
+		originalApi = gkeContainerApi
+		gkeContainerApi = server.URL
+
+		key, err := rsa.GenerateKey(rand.Reader, 2048)
+		require.NoError(t, err)
+
+		keyJson, err := json.Marshal(map[string]string{
+			"type":         "service_account",
+			"client_email": "some-sa@some-project.iam.gserviceaccount.com",
+			"private_key":  string(pem.EncodeToMemory(&pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(key)})),
+			"token_uri":    server.URL + "/token",
+		})
+		require.NoError(t, err)
+		jsonKey = string(keyJson)
+	})
+
+	it.After(func() {
+		gkeContainerApi = originalApi
+		server.Close()
+	})
+
+	it("fetches the cluster endpoint and ca", func() {
+		config, err := gkeSetup(&GKESource{
+			JSONKey:  jsonKey,
+			Project:  "some-project",
+			Location: "us-central1",
+			Cluster:  "some-cluster",
+		})
+		require.NoError(t, err)
+
+		assert.Equal(t, []string{"Bearer some-access-token"}, clusterRequests)
+		assert.Equal(t, "https://10.0.0.1", config.Host)
+		assert.Equal(t, []byte("some-ca"), config.CAData)
+	})
+
+	it("authenticates requests with the service account token", func() {
+		config, err := gkeSetup(&GKESource{
+			JSONKey: jsonKey,
+			Kubeconfig: `apiVersion: v1
+kind: Config
+clusters:
+- name: gke
+  cluster:
+    server: ` + server.URL + `
+contexts:
+- name: gke
+  context:
+    cluster: gke
+    user: gke
+current-context: gke
+users:
+- name: gke
+  user:
+    auth-provider:
+      name: gcp
+`,
+		})
+		require.NoError(t, err)
+		assert.Nil(t, config.AuthProvider)
+
+		var authorization string
+		server.Config.Handler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if r.URL.Path == "/token" {
+				_, _ = w.Write([]byte(`{"access_token":"some-access-token","token_type":"Bearer","expires_in":3600}`))
+				return
+			}
+			authorization = r.Header.Get("Authorization")
+		})
+
+		transport, err := restclient.TransportFor(config)
+		require.NoError(t, err)
+
+		resp, err := (&http.Client{Transport: transport}).Get(server.URL + "/api")
+		require.NoError(t, err)
+		resp.Body.Close()
+
+		assert.Equal(t, "Bearer some-access-token", authorization)
+	})
+
+	it("requires a kubeconfig or cluster", func() {
+		_, err := gkeSetup(&GKESource{
+			JSONKey: jsonKey,
+			Project: "some-project",
+		})
+		require.EqualError(t, err, "gke requires either a kubeconfig or a project, location and cluster")
+	})
+}
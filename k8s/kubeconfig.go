@@ -15,5 +15,10 @@ func kubeConfigSetup(kubeconfig string) (*restclient.Config, error) {
 		return nil, err
 	}
 
-	return clientcmd.NewDefaultClientConfig(*config, &clientcmd.ConfigOverrides{}).ClientConfig()
+	restConfig, err := clientcmd.NewDefaultClientConfig(*config, &clientcmd.ConfigOverrides{}).ClientConfig()
+	if err != nil {
+		return nil, err
+	}
+
+	return execSetup(restConfig)
 }
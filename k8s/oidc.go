@@ -0,0 +1,244 @@
+// Copyright 2020-Present VMware, Inc.
+// SPDX-License-Identifier: Apache-2.0
+
+package k8s
+
+import (
+	"bytes"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"k8s.io/client-go/rest"
+	"k8s.io/client-go/tools/clientcmd"
+	clientcmdapi "k8s.io/client-go/tools/clientcmd/api"
+)
+
+const (
+	defaultPinnipedAuthenticatorKind = "JWTAuthenticator"
+	defaultPinnipedApiGroupSuffix    = "pinniped.dev"
+)
+
+func oidcSetup(source *OIDCSource) (*rest.Config, error) {
+	if source.Server == "" || source.IssuerURL == "" || source.ClientID == "" {
+		return nil, fmt.Errorf("oidc requires a server, issuer_url and client_id")
+	}
+
+	client := tlsClient([]byte(source.IssuerCertificateAuthority), source.IssuerInsecure)
+
+	tokenEndpoint, err := oidcDiscovery(client, source.IssuerURL)
+	if err != nil {
+		return nil, err
+	}
+
+	token, err := oidcToken(client, tokenEndpoint, source)
+	if err != nil {
+		return nil, err
+	}
+
+	cluster := clientcmdapi.Cluster{
+		Server:                   source.Server,
+		CertificateAuthorityData: []byte(source.CertificateAuthority),
+		InsecureSkipTLSVerify:    source.Insecure,
+	}
+
+	if source.Pinniped != nil {
+		authInfo, err := pinnipedCredential(cluster, source.Pinniped, token.IdToken)
+		if err != nil {
+			return nil, err
+		}
+
+		return clientcmd.NewDefaultClientConfig(clientcmdapi.Config{}, &clientcmd.ConfigOverrides{
+			AuthInfo:    authInfo,
+			ClusterInfo: cluster,
+		}).ClientConfig()
+	}
+
+	authProviderConfig := map[string]string{
+		"client-id":      source.ClientID,
+		"client-secret":  source.ClientSecret,
+		"id-token":       token.IdToken,
+		"idp-issuer-url": source.IssuerURL,
+		"refresh-token":  token.RefreshToken,
+	}
+	if len(source.Scopes) > 0 {
+		authProviderConfig["extra-scopes"] = strings.Join(source.Scopes, ",")
+	}
+	if source.IssuerCertificateAuthority != "" {
+		authProviderConfig["idp-certificate-authority-data"] = base64.StdEncoding.EncodeToString([]byte(source.IssuerCertificateAuthority))
+	}
+
+	return clientcmd.NewDefaultClientConfig(clientcmdapi.Config{}, &clientcmd.ConfigOverrides{
+		AuthInfo: clientcmdapi.AuthInfo{
+			AuthProvider: &clientcmdapi.AuthProviderConfig{
+				Name:   "oidc",
+				Config: authProviderConfig,
+			},
+		},
+		ClusterInfo: cluster,
+	}).ClientConfig()
+}
+
+func oidcDiscovery(client *http.Client, issuerURL string) (string, error) {
+	resp, err := client.Get(strings.TrimSuffix(issuerURL, "/") + "/.well-known/openid-configuration")
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		body, _ := ioutil.ReadAll(resp.Body)
+		return "", fmt.Errorf("failed oidc discovery for %s: %s: %s", issuerURL, resp.Status, strings.TrimSpace(string(body)))
+	}
+
+	type discoveryResponse struct {
+		TokenEndpoint string `json:"token_endpoint"`
+	}
+
+	var discovery discoveryResponse
+	err = json.NewDecoder(resp.Body).Decode(&discovery)
+	if err != nil {
+		return "", err
+	}
+
+	if discovery.TokenEndpoint == "" {
+		return "", fmt.Errorf("oidc issuer %s does not advertise a token_endpoint", issuerURL)
+	}
+	return discovery.TokenEndpoint, nil
+}
+
+type oidcTokenResponse struct {
+	IdToken      string `json:"id_token"`
+	RefreshToken string `json:"refresh_token"`
+}
+
+func oidcToken(client *http.Client, tokenEndpoint string, source *OIDCSource) (oidcTokenResponse, error) {
+	values := url.Values{
+		"client_id":     []string{source.ClientID},
+		"client_secret": []string{source.ClientSecret},
+		"scope":         []string{strings.Join(append([]string{"openid"}, source.Scopes...), " ")},
+	}
+	switch {
+	case source.RefreshToken != "":
+		values.Set("grant_type", "refresh_token")
+		values.Set("refresh_token", source.RefreshToken)
+	case source.Username != "":
+		values.Set("grant_type", "password")
+		values.Set("username", source.Username)
+		values.Set("password", source.Password)
+	default:
+		return oidcTokenResponse{}, fmt.Errorf("oidc requires either a refresh_token or a username and password")
+	}
+
+	resp, err := client.PostForm(tokenEndpoint, values)
+	if err != nil {
+		return oidcTokenResponse{}, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		body, _ := ioutil.ReadAll(resp.Body)
+		return oidcTokenResponse{}, fmt.Errorf("failed to get oidc token from %s: %s: %s", tokenEndpoint, resp.Status, strings.TrimSpace(string(body)))
+	}
+
+	var token oidcTokenResponse
+	err = json.NewDecoder(resp.Body).Decode(&token)
+	if err != nil {
+		return oidcTokenResponse{}, err
+	}
+
+	if token.IdToken == "" {
+		return oidcTokenResponse{}, fmt.Errorf("oidc token response from %s did not include an id_token", tokenEndpoint)
+	}
+	return token, nil
+}
+
+// pinnipedCredential exchanges the id token for a cluster credential with a
+// pinniped concierge TokenCredentialRequest.
+func pinnipedCredential(cluster clientcmdapi.Cluster, pinniped *PinnipedSource, idToken string) (clientcmdapi.AuthInfo, error) {
+	kind := pinniped.AuthenticatorKind
+	if kind == "" {
+		kind = defaultPinnipedAuthenticatorKind
+	}
+	suffix := pinniped.ApiGroupSuffix
+	if suffix == "" {
+		suffix = defaultPinnipedApiGroupSuffix
+	}
+
+	request, err := json.Marshal(map[string]interface{}{
+		"apiVersion": "login.concierge." + suffix + "/v1alpha1",
+		"kind":       "TokenCredentialRequest",
+		"spec": map[string]interface{}{
+			"token": idToken,
+			"authenticator": map[string]string{
+				"apiGroup": "authentication.concierge." + suffix,
+				"kind":     kind,
+				"name":     pinniped.Authenticator,
+			},
+		},
+	})
+	if err != nil {
+		return clientcmdapi.AuthInfo{}, err
+	}
+
+	client := tlsClient(cluster.CertificateAuthorityData, cluster.InsecureSkipTLSVerify)
+
+	endpoint := strings.TrimSuffix(cluster.Server, "/") + "/apis/login.concierge." + suffix + "/v1alpha1/tokencredentialrequests"
+	resp, err := client.Post(endpoint, "application/json", bytes.NewReader(request))
+	if err != nil {
+		return clientcmdapi.AuthInfo{}, err
+	}
+	defer resp.Body.Close()
+
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return clientcmdapi.AuthInfo{}, err
+	}
+
+	if resp.StatusCode != http.StatusOK && resp.StatusCode != http.StatusCreated {
+		return clientcmdapi.AuthInfo{}, fmt.Errorf("failed pinniped token credential request: %s: %s", resp.Status, strings.TrimSpace(string(body)))
+	}
+
+	type tokenCredentialRequest struct {
+		Status struct {
+			Credential *struct {
+				Token                 string `json:"token"`
+				ClientCertificateData string `json:"clientCertificateData"`
+				ClientKeyData         string `json:"clientKeyData"`
+			} `json:"credential"`
+			Message string `json:"message"`
+		} `json:"status"`
+	}
+
+	var credentialRequest tokenCredentialRequest
+	err = json.Unmarshal(body, &credentialRequest)
+	if err != nil {
+		return clientcmdapi.AuthInfo{}, err
+	}
+
+	credential := credentialRequest.Status.Credential
+	if credential == nil {
+		return clientcmdapi.AuthInfo{}, fmt.Errorf("pinniped did not issue a credential: %s", credentialRequest.Status.Message)
+	}
+
+	return clientcmdapi.AuthInfo{
+		Token:                 credential.Token,
+		ClientCertificateData: []byte(credential.ClientCertificateData),
+		ClientKeyData:         []byte(credential.ClientKeyData),
+	}, nil
+}
+
+func tlsClient(ca []byte, insecure bool) *http.Client {
+	tlsConfig := &tls.Config{InsecureSkipVerify: insecure}
+	if len(ca) > 0 {
+		tlsConfig.RootCAs = x509.NewCertPool()
+		tlsConfig.RootCAs.AppendCertsFromPEM(ca)
+	}
+	return &http.Client{Transport: &http.Transport{Proxy: http.ProxyFromEnvironment, TLSClientConfig: tlsConfig}}
+}
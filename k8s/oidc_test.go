@@ -0,0 +1,166 @@
+// Copyright 2020-Present VMware, Inc.
+// SPDX-License-Identifier: Apache-2.0
+
+package k8s
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/sclevine/spec"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"k8s.io/client-go/rest"
+)
+
+func TestOIDC(t *testing.T) {
+	spec.Run(t, "TestOIDC", testOIDC)
+}
+
+func testOIDC(t *testing.T, when spec.G, it spec.S) {
+	var (
+		server          *httptest.Server
+		tokenRequest    http.Request
+		pinnipedRequest map[string]interface{}
+	)
+
+	it.Before(func() {
+		server = httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			switch r.URL.Path {
+			case "/dex/.well-known/openid-configuration":
+				_, _ = w.Write([]byte(`{"issuer":"` + server.URL + `/dex","token_endpoint":"` + server.URL + `/dex/token"}`))
+			case "/dex/token":
+				require.NoError(t, r.ParseForm())
+				tokenRequest = *r
+				_, _ = w.Write([]byte(`{"id_token":"some-id-token","refresh_token":"some-refresh-token"}`))
+			case "/apis/login.concierge.pinniped.dev/v1alpha1/tokencredentialrequests":
+				require.NoError(t, json.NewDecoder(r.Body).Decode(&pinnipedRequest))
+				w.WriteHeader(http.StatusCreated)
+				_, _ = w.Write([]byte(`{"status":{"credential":{"clientCertificateData":"some-cert","clientKeyData":"some-key"}}}`))
+			default:
+				w.WriteHeader(http.StatusNotFound)
+			}
+		}))
+	})
+
+	it.After(func() {
+		server.Close()
+	})
+
+	it("configures the oidc auth provider with a password grant", func() {
+		config, err := oidcSetup(&OIDCSource{
+			Server:         "https://cluster.example.com",
+			IssuerURL:      server.URL + "/dex",
+			IssuerInsecure: true,
+			ClientID:       "some-client",
+			ClientSecret:   "some-secret",
+			Username:       "some-user",
+			Password:       "some-password",
+			Scopes:         []string{"groups"},
+		})
+		require.NoError(t, err)
+
+		assert.Equal(t, "password", tokenRequest.PostForm.Get("grant_type"))
+		assert.Equal(t, "some-user", tokenRequest.PostForm.Get("username"))
+		assert.Equal(t, "openid groups", tokenRequest.PostForm.Get("scope"))
+
+		assert.Equal(t, "https://cluster.example.com", config.Host)
+		assert.Equal(t, "oidc", config.AuthProvider.Name)
+		assert.Equal(t, map[string]string{
+			"client-id":      "some-client",
+			"client-secret":  "some-secret",
+			"id-token":       "some-id-token",
+			"idp-issuer-url": server.URL + "/dex",
+			"refresh-token":  "some-refresh-token",
+			"extra-scopes":   "groups",
+		}, config.AuthProvider.Config)
+	})
+
+	it("trusts the issuer certificate authority in the auth provider", func() {
+		issuerCA := string(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: server.Certificate().Raw}))
+
+		config, err := oidcSetup(&OIDCSource{
+			Server:                     "https://cluster.example.com",
+			IssuerURL:                  server.URL + "/dex",
+			IssuerCertificateAuthority: issuerCA,
+			ClientID:                   "some-client",
+			Username:                   "some-user",
+			Password:                   "some-password",
+		})
+		require.NoError(t, err)
+
+		assert.Equal(t, base64.StdEncoding.EncodeToString([]byte(issuerCA)), config.AuthProvider.Config["idp-certificate-authority-data"])
+
+		_, err = rest.TransportFor(config)
+		require.NoError(t, err)
+	})
+
+	it("exchanges a refresh token", func() {
+		_, err := oidcSetup(&OIDCSource{
+			Server:         "https://cluster.example.com",
+			IssuerURL:      server.URL + "/dex",
+			IssuerInsecure: true,
+			ClientID:       "some-client",
+			RefreshToken:   "some-existing-refresh-token",
+		})
+		require.NoError(t, err)
+
+		assert.Equal(t, "refresh_token", tokenRequest.PostForm.Get("grant_type"))
+		assert.Equal(t, "some-existing-refresh-token", tokenRequest.PostForm.Get("refresh_token"))
+	})
+
+	it("exchanges the id token with the pinniped concierge", func() {
+		config, err := oidcSetup(&OIDCSource{
+			Server:         server.URL,
+			Insecure:       true,
+			IssuerURL:      server.URL + "/dex",
+			IssuerInsecure: true,
+			ClientID:       "some-client",
+			Username:       "some-user",
+			Password:       "some-password",
+			Pinniped: &PinnipedSource{
+				Authenticator: "some-authenticator",
+			},
+		})
+		require.NoError(t, err)
+
+		assert.Equal(t, map[string]interface{}{
+			"token": "some-id-token",
+			"authenticator": map[string]interface{}{
+				"apiGroup": "authentication.concierge.pinniped.dev",
+				"kind":     "JWTAuthenticator",
+				"name":     "some-authenticator",
+			},
+		}, pinnipedRequest["spec"])
+
+		assert.Nil(t, config.AuthProvider)
+		assert.Equal(t, []byte("some-cert"), config.CertData)
+		assert.Equal(t, []byte("some-key"), config.KeyData)
+	})
+
+	it("returns an error without credentials", func() {
+		_, err := oidcSetup(&OIDCSource{
+			Server:         "https://cluster.example.com",
+			IssuerURL:      server.URL + "/dex",
+			IssuerInsecure: true,
+			ClientID:       "some-client",
+		})
+		require.EqualError(t, err, "oidc requires either a refresh_token or a username and password")
+	})
+
+	it("verifies the issuer when only the server is insecure", func() {
+		_, err := oidcSetup(&OIDCSource{
+			Server:       "https://cluster.example.com",
+			Insecure:     true,
+			IssuerURL:    server.URL + "/dex",
+			ClientID:     "some-client",
+			RefreshToken: "some-existing-refresh-token",
+		})
+		require.Error(t, err)
+		assert.Contains(t, err.Error(), "certificate")
+	})
+}
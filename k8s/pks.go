@@ -8,7 +8,8 @@ import (
 
 	"crypto/tls"
 	"encoding/json"
-	"errors"
+	"fmt"
+	"io/ioutil"
 	"net/http"
 	"net/url"
 	"strconv"
@@ -19,16 +20,52 @@ import (
 	clientcmdapi "k8s.io/client-go/tools/clientcmd/api"
 )
 
+const (
+	defaultPKSClientID  = "pks_cluster_client"
+	defaultPKSPort      = 8443
+	defaultPKSTokenPath = "/oauth/token"
+
+	passwordGrant          = "password"
+	clientCredentialsGrant = "client_credentials"
+)
+
 func pksSetup(source *PKSSource) (*rest.Config, error) {
-	data := url.Values{
-		"client_id":     []string{"pks_cluster_client"},
-		"client_secret": []string{""},
-		"grant_type":    []string{"password"},
-		"username":      []string{source.Username},
-		"password":      []string{source.Password},
-	}.Encode()
-
-	req, err := http.NewRequest(http.MethodPost, source.Api+"/oauth/token", strings.NewReader(data))
+	clientID := source.ClientID
+	if clientID == "" {
+		clientID = defaultPKSClientID
+	}
+
+	tokenEndpoint := source.TokenEndpoint
+	if tokenEndpoint == "" {
+		tokenEndpoint = source.Api + defaultPKSTokenPath
+	}
+
+	port := source.Port
+	if port == 0 {
+		port = defaultPKSPort
+	}
+
+	grantType := source.GrantType
+	if grantType == "" {
+		grantType = passwordGrant
+	}
+
+	values := url.Values{
+		"client_id":     []string{clientID},
+		"client_secret": []string{source.ClientSecret},
+		"grant_type":    []string{grantType},
+	}
+	switch grantType {
+	case passwordGrant:
+		values.Set("username", source.Username)
+		values.Set("password", source.Password)
+	case clientCredentialsGrant:
+	default:
+		return nil, fmt.Errorf("unsupported grant_type '%s': must be '%s' or '%s'", grantType, passwordGrant, clientCredentialsGrant)
+	}
+	data := values.Encode()
+
+	req, err := http.NewRequest(http.MethodPost, tokenEndpoint, strings.NewReader(data))
 	if err != nil {
 		return nil, err
 	}
@@ -47,10 +84,12 @@ func pksSetup(source *PKSSource) (*rest.Config, error) {
 	defer resp.Body.Close()
 
 	if resp.StatusCode != http.StatusOK {
-		return nil, errors.New("failed to get oidc token")
+		body, _ := ioutil.ReadAll(resp.Body)
+		return nil, fmt.Errorf("failed to get oidc token from %s: %s: %s", tokenEndpoint, resp.Status, strings.TrimSpace(string(body)))
 	}
 
 	type tokenResponse struct {
+		AccessToken  string `json:"access_token"`
 		IdToken      string `json:"id_token"`
 		RefreshToken string `json:"refresh_token"`
 	}
@@ -61,21 +100,30 @@ func pksSetup(source *PKSSource) (*rest.Config, error) {
 		return nil, err
 	}
 
-	return clientcmd.NewDefaultClientConfig(clientcmdapi.Config{}, &clientcmd.ConfigOverrides{
-		AuthInfo: clientcmdapi.AuthInfo{
-			AuthProvider: &clientcmdapi.AuthProviderConfig{
-				Name: "oidc",
-				Config: map[string]string{
-					"client-id":             "pks_cluster_client",
-					"cluster_client_secret": "",
-					"id-token":              token.IdToken,
-					"idp-issuer-url":        source.Api + "/oauth/token",
-					"refresh-token":         token.RefreshToken,
-				},
+	authInfo := clientcmdapi.AuthInfo{
+		AuthProvider: &clientcmdapi.AuthProviderConfig{
+			Name: "oidc",
+			Config: map[string]string{
+				"client-id":      clientID,
+				"client-secret":  source.ClientSecret,
+				"id-token":       token.IdToken,
+				"idp-issuer-url": tokenEndpoint,
+				"refresh-token":  token.RefreshToken,
 			},
 		},
+	}
+	// The client_credentials grant does not issue an id or refresh token,
+	// so the access token is used as a bearer token instead.
+	if grantType == clientCredentialsGrant {
+		authInfo = clientcmdapi.AuthInfo{
+			Token: token.AccessToken,
+		}
+	}
+
+	return clientcmd.NewDefaultClientConfig(clientcmdapi.Config{}, &clientcmd.ConfigOverrides{
+		AuthInfo: authInfo,
 		ClusterInfo: clientcmdapi.Cluster{
-			Server:                "https://" + source.Cluster + ":8443",
+			Server:                fmt.Sprintf("https://%s:%d", source.Cluster, port),
 			InsecureSkipTLSVerify: source.Insecure,
 		},
 	}).ClientConfig()
@@ -0,0 +1,123 @@
+// Copyright 2020-Present VMware, Inc.
+// SPDX-License-Identifier: Apache-2.0
+
+package k8s
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/sclevine/spec"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestPKS(t *testing.T) {
+	spec.Run(t, "TestPKS", testPKS)
+}
+
+func testPKS(t *testing.T, when spec.G, it spec.S) {
+	var (
+		uaa      *httptest.Server
+		received http.Request
+		status   int
+		response string
+	)
+
+	it.Before(func() {
+		status = http.StatusOK
+		response = `{"access_token":"some-access-token","id_token":"some-id-token","refresh_token":"some-refresh-token"}`
+
+		uaa = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			require.NoError(t, r.ParseForm())
+			received = *r
+			w.WriteHeader(status)
+			_, _ = w.Write([]byte(response))
+		}))
+	})
+
+	it.After(func() {
+		uaa.Close()
+	})
+
+	it("uses the default client, port and token path", func() {
+		config, err := pksSetup(&PKSSource{
+			Api:      uaa.URL,
+			Cluster:  "cluster.example.com",
+			Username: "some-user",
+			Password: "some-password",
+		})
+		require.NoError(t, err)
+
+		assert.Equal(t, "/oauth/token", received.URL.Path)
+		assert.Equal(t, "pks_cluster_client", received.PostForm.Get("client_id"))
+		assert.Equal(t, "password", received.PostForm.Get("grant_type"))
+		assert.Equal(t, "some-user", received.PostForm.Get("username"))
+		assert.Equal(t, "some-password", received.PostForm.Get("password"))
+
+		assert.Equal(t, "https://cluster.example.com:8443", config.Host)
+		assert.Equal(t, "oidc", config.AuthProvider.Name)
+		assert.Equal(t, "some-id-token", config.AuthProvider.Config["id-token"])
+		assert.Equal(t, "some-refresh-token", config.AuthProvider.Config["refresh-token"])
+	})
+
+	it("uses the configured client, port and token endpoint", func() {
+		config, err := pksSetup(&PKSSource{
+			Api:           "https://unused.example.com",
+			Cluster:       "cluster.example.com",
+			Port:          443,
+			Username:      "some-user",
+			Password:      "some-password",
+			ClientID:      "some-client",
+			ClientSecret:  "some-secret",
+			TokenEndpoint: uaa.URL + "/uaa/oauth/token",
+		})
+		require.NoError(t, err)
+
+		assert.Equal(t, "/uaa/oauth/token", received.URL.Path)
+		assert.Equal(t, "some-client", received.PostForm.Get("client_id"))
+		assert.Equal(t, "some-secret", received.PostForm.Get("client_secret"))
+
+		assert.Equal(t, "https://cluster.example.com:443", config.Host)
+		assert.Equal(t, "some-client", config.AuthProvider.Config["client-id"])
+		assert.Equal(t, "some-secret", config.AuthProvider.Config["client-secret"])
+	})
+
+	it("uses the access token with the client_credentials grant", func() {
+		config, err := pksSetup(&PKSSource{
+			Api:          uaa.URL,
+			Cluster:      "cluster.example.com",
+			ClientID:     "some-client",
+			ClientSecret: "some-secret",
+			GrantType:    "client_credentials",
+		})
+		require.NoError(t, err)
+
+		assert.Equal(t, "client_credentials", received.PostForm.Get("grant_type"))
+		assert.Empty(t, received.PostForm.Get("username"))
+
+		assert.Nil(t, config.AuthProvider)
+		assert.Equal(t, "some-access-token", config.BearerToken)
+	})
+
+	it("returns an error for an unsupported grant type", func() {
+		_, err := pksSetup(&PKSSource{
+			Api:       uaa.URL,
+			GrantType: "implicit",
+		})
+		require.EqualError(t, err, "unsupported grant_type 'implicit': must be 'password' or 'client_credentials'")
+	})
+
+	it("includes the uaa response in the error", func() {
+		status = http.StatusUnauthorized
+		response = `{"error":"unauthorized","error_description":"Bad credentials"}`
+
+		_, err := pksSetup(&PKSSource{
+			Api:      uaa.URL,
+			Username: "some-user",
+			Password: "wrong-password",
+		})
+		require.EqualError(t, err, "failed to get oidc token from "+uaa.URL+"/oauth/token: 401 Unauthorized: "+response)
+	})
+}
@@ -4,38 +4,145 @@
 package k8s
 
 import (
-	"encoding/json"
-
-	oc "github.com/cloudboss/ofcourse/ofcourse"
+	"fmt"
+	"strings"
 )
 
-func NewSource(ocSource oc.Source) (Source, error) {
-	marshal, err := json.Marshal(ocSource)
-	if err != nil {
-		return Source{}, err
+type Source struct {
+	PKS        *PKSSource  `json:"pks,omitempty"`
+	TKGI       *PKSSource  `json:"tkgi,omitempty"`
+	GKE        *GKESource  `json:"gke,omitempty"`
+	EKS        *EKSSource  `json:"eks,omitempty"`
+	OIDC       *OIDCSource `json:"oidc,omitempty"`
+	Kubeconfig string      `json:"kubeconfig,omitempty"`
+	InCluster  bool        `json:"in_cluster,omitempty"`
+}
+
+// Validate reports unless exactly one way of connecting to the cluster is
+// configured with the keys it requires.
+func (s Source) Validate() []string {
+	var problems, methods []string
+	for _, method := range []struct {
+		key string
+		set bool
+	}{
+		{"pks", s.PKS != nil},
+		{"tkgi", s.TKGI != nil},
+		{"gke", s.GKE != nil},
+		{"eks", s.EKS != nil},
+		{"oidc", s.OIDC != nil},
+		{"kubeconfig", s.Kubeconfig != ""},
+		{"in_cluster", s.InCluster},
+	} {
+		if method.set {
+			methods = append(methods, "'"+method.key+"'")
+		}
 	}
 
-	source := Source{}
-	err = json.Unmarshal(marshal, &source)
-	return source, err
-}
+	switch len(methods) {
+	case 0:
+		problems = append(problems, "one of 'pks', 'tkgi', 'gke', 'eks', 'oidc', 'kubeconfig' or 'in_cluster' is required")
+	case 1:
+	default:
+		problems = append(problems, fmt.Sprintf("only one of 'pks', 'tkgi', 'gke', 'eks', 'oidc', 'kubeconfig' or 'in_cluster' may be set, got %s", strings.Join(methods, " and ")))
+	}
 
-type Source struct {
-	PKS        *PKSSource `json:"pks,omitempty"`
-	TKGI       *PKSSource `json:"tkgi,omitempty"`
-	GKE        *GKESource `json:"gke,omitempty"`
-	Kubeconfig string     `json:"kubeconfig,omitempty"`
+	required := func(key, value string) {
+		if value == "" {
+			problems = append(problems, fmt.Sprintf("missing required key '%s'", key))
+		}
+	}
+
+	pks := func(key string, source *PKSSource) {
+		required(key+".api", source.Api)
+		required(key+".cluster", source.Cluster)
+		switch source.GrantType {
+		case "", passwordGrant:
+			required(key+".username", source.Username)
+			required(key+".password", source.Password)
+		case clientCredentialsGrant:
+		default:
+			problems = append(problems, fmt.Sprintf("unsupported %s.grant_type '%s', must be '%s' or '%s'", key, source.GrantType, passwordGrant, clientCredentialsGrant))
+		}
+	}
+
+	if s.PKS != nil {
+		pks("pks", s.PKS)
+	}
+
+	if s.TKGI != nil {
+		pks("tkgi", s.TKGI)
+	}
+
+	if s.GKE != nil && s.GKE.Kubeconfig == "" {
+		required("gke.project", s.GKE.Project)
+		required("gke.location", s.GKE.Location)
+		required("gke.cluster", s.GKE.Cluster)
+	}
+
+	if s.EKS != nil {
+		required("eks.cluster", s.EKS.Cluster)
+		required("eks.region", s.EKS.Region)
+	}
+
+	if s.OIDC != nil {
+		required("oidc.server", s.OIDC.Server)
+		required("oidc.issuer_url", s.OIDC.IssuerURL)
+		required("oidc.client_id", s.OIDC.ClientID)
+		if s.OIDC.Pinniped != nil {
+			required("oidc.pinniped.authenticator", s.OIDC.Pinniped.Authenticator)
+		}
+	}
+
+	return problems
 }
 
 type PKSSource struct {
-	Api      string `json:"api"`
-	Cluster  string `json:"cluster"`
-	Insecure bool   `json:"insecure"`
-	Password string `json:"password"`
-	Username string `json:"username"`
+	Api           string `json:"api"`
+	Cluster       string `json:"cluster"`
+	Port          int    `json:"port,omitempty"`
+	Insecure      bool   `json:"insecure"`
+	Password      string `json:"password"`
+	Username      string `json:"username"`
+	ClientID      string `json:"client_id,omitempty"`
+	ClientSecret  string `json:"client_secret,omitempty"`
+	GrantType     string `json:"grant_type,omitempty"`
+	TokenEndpoint string `json:"token_endpoint,omitempty"`
 }
 
 type GKESource struct {
-	Kubeconfig string `json:"kubeconfig"`
-	JSONKey    string `json:"json_key"`
+	Kubeconfig string `json:"kubeconfig,omitempty"`
+	JSONKey    string `json:"json_key,omitempty"`
+	Project    string `json:"project,omitempty"`
+	Location   string `json:"location,omitempty"`
+	Cluster    string `json:"cluster,omitempty"`
+}
+
+type EKSSource struct {
+	Cluster              string `json:"cluster"`
+	Region               string `json:"region"`
+	Endpoint             string `json:"endpoint,omitempty"`
+	CertificateAuthority string `json:"certificate_authority,omitempty"`
+}
+
+type OIDCSource struct {
+	Server                     string          `json:"server"`
+	CertificateAuthority       string          `json:"certificate_authority,omitempty"`
+	Insecure                   bool            `json:"insecure,omitempty"`
+	IssuerURL                  string          `json:"issuer_url"`
+	IssuerCertificateAuthority string          `json:"issuer_certificate_authority,omitempty"`
+	IssuerInsecure             bool            `json:"issuer_insecure,omitempty"`
+	ClientID                   string          `json:"client_id"`
+	ClientSecret               string          `json:"client_secret,omitempty"`
+	Username                   string          `json:"username,omitempty"`
+	Password                   string          `json:"password,omitempty"`
+	RefreshToken               string          `json:"refresh_token,omitempty"`
+	Scopes                     []string        `json:"scopes,omitempty"`
+	Pinniped                   *PinnipedSource `json:"pinniped,omitempty"`
+}
+
+type PinnipedSource struct {
+	Authenticator     string `json:"authenticator"`
+	AuthenticatorKind string `json:"authenticator_kind,omitempty"`
+	ApiGroupSuffix    string `json:"api_group_suffix,omitempty"`
 }
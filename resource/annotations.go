@@ -0,0 +1,90 @@
+// Copyright 2020-Present VMware, Inc.
+// SPDX-License-Identifier: Apache-2.0
+
+package resource
+
+import (
+	oc "github.com/cloudboss/ofcourse/ofcourse"
+	"github.com/pivotal/kpack/pkg/apis/build/v1alpha2"
+	corev1alpha1 "github.com/pivotal/kpack/pkg/apis/core/v1alpha1"
+)
+
+const (
+	BuildURLAnnotation       = "concourse.ci/build-url"
+	TeamAnnotation           = "concourse.ci/team"
+	PipelineAnnotation       = "concourse.ci/pipeline"
+	JobAnnotation            = "concourse.ci/job"
+	BuildNameAnnotation      = "concourse.ci/build-name"
+	BuildIDAnnotation        = "concourse.ci/build-id"
+	TriggerVersionAnnotation = "concourse.ci/trigger-version"
+)
+
+// concourseAnnotations lists the annotations a put stamps on an image with
+// the name of the metadata in reads them back into.
+var concourseAnnotations = []struct {
+	annotation string
+	metadata   string
+}{
+	{BuildURLAnnotation, "concourseBuildUrl"},
+	{TeamAnnotation, "concourseTeam"},
+	{PipelineAnnotation, "concoursePipeline"},
+	{JobAnnotation, "concourseJob"},
+	{BuildNameAnnotation, "concourseBuildName"},
+	{BuildIDAnnotation, "concourseBuildId"},
+	{TriggerVersionAnnotation, "concourseTriggerVersion"},
+}
+
+// annotateImage records the Concourse build updating the image and the
+// source version it triggers a build of. kpack copies the annotations of an
+// image to the builds it creates. Outside of a Concourse build the image is
+// left as is.
+func annotateImage(image *v1alpha2.Image, build concourseBuild) {
+	if build == (concourseBuild{}) {
+		return
+	}
+
+	values := map[string]string{
+		BuildURLAnnotation:       build.URL(),
+		TeamAnnotation:           build.Team,
+		PipelineAnnotation:       build.Pipeline,
+		JobAnnotation:            build.Job,
+		BuildNameAnnotation:      build.Name,
+		BuildIDAnnotation:        build.ID,
+		TriggerVersionAnnotation: sourceVersion(image.Spec.Source),
+	}
+
+	if image.Annotations == nil {
+		image.Annotations = map[string]string{}
+	}
+	for key, value := range values {
+		if value == "" {
+			delete(image.Annotations, key)
+		} else {
+			image.Annotations[key] = value
+		}
+	}
+}
+
+// sourceVersion is the revision, blob or image the source builds.
+func sourceVersion(source corev1alpha1.SourceConfig) string {
+	switch {
+	case source.Git != nil:
+		return source.Git.Revision
+	case source.Blob != nil:
+		return source.Blob.URL
+	case source.Registry != nil:
+		return source.Registry.Image
+	default:
+		return ""
+	}
+}
+
+func concourseMetadata(build v1alpha2.Build) []oc.NameVal {
+	var metadata []oc.NameVal
+	for _, a := range concourseAnnotations {
+		if value := build.Annotations[a.annotation]; value != "" {
+			metadata = append(metadata, oc.NameVal{Name: a.metadata, Value: value})
+		}
+	}
+	return metadata
+}
@@ -0,0 +1,153 @@
+// Copyright 2020-Present VMware, Inc.
+// SPDX-License-Identifier: Apache-2.0
+
+package resource_test
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	oc "github.com/cloudboss/ofcourse/ofcourse"
+	"github.com/pivotal/kpack/pkg/apis/build/v1alpha2"
+	corev1alpha1 "github.com/pivotal/kpack/pkg/apis/core/v1alpha1"
+	"github.com/sclevine/spec"
+	"github.com/stretchr/testify/require"
+	v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	clientgotesting "k8s.io/client-go/testing"
+
+	"github.com/pivotal/concourse-kpack-resource/resource"
+)
+
+func TestAnnotations(t *testing.T) {
+	spec.Run(t, "TestAnnotations", testAnnotations)
+}
+
+func testAnnotations(t *testing.T, when spec.G, it spec.S) {
+	const namespace = "test-namespace"
+
+	var dir string
+
+	it.Before(func() {
+		var err error
+		dir, err = ioutil.TempDir("", "annotations_test")
+		require.NoError(t, err)
+	})
+
+	it.After(func() {
+		os.RemoveAll(dir)
+	})
+
+	it("stamps the concourse build on the image", func() {
+		err := ioutil.WriteFile(filepath.Join(dir, "blob_url"), []byte("https://blobs.example.com/app-2.tgz"), 0644)
+		require.NoError(t, err)
+
+		image := &v1alpha2.Image{
+			ObjectMeta: v1.ObjectMeta{
+				Name:      "app",
+				Namespace: namespace,
+				Annotations: map[string]string{
+					"owner":                    "team-a",
+					resource.BuildIDAnnotation: "1001",
+				},
+			},
+			Spec: v1alpha2.ImageSpec{
+				Tag: "gcr.io/app",
+				Source: corev1alpha1.SourceConfig{
+					Blob: &corev1alpha1.Blob{URL: "https://blobs.example.com/app-1.tgz"},
+				},
+			},
+		}
+
+		updatedImage := image.DeepCopy()
+		updatedImage.Spec.Source.Blob.URL = "https://blobs.example.com/app-2.tgz"
+		updatedImage.Annotations = map[string]string{
+			"owner":                        "team-a",
+			"concourse.ci/build-url":       "https://ci.example.com/teams/main/pipelines/app/jobs/build/builds/7",
+			"concourse.ci/team":            "main",
+			"concourse.ci/pipeline":        "app",
+			"concourse.ci/job":             "build",
+			"concourse.ci/build-name":      "7",
+			"concourse.ci/trigger-version": "https://blobs.example.com/app-2.tgz",
+		}
+
+		OutTest{
+			InDir:   dir,
+			Objects: []runtime.Object{image},
+			Environment: map[string]string{
+				"ATC_EXTERNAL_URL":    "https://ci.example.com/",
+				"BUILD_TEAM_NAME":     "main",
+				"BUILD_PIPELINE_NAME": "app",
+				"BUILD_JOB_NAME":      "build",
+				"BUILD_NAME":          "7",
+			},
+			Source: resource.Source{
+				Image:     image.Name,
+				Namespace: namespace,
+			},
+			Parameters:    resource.OutParams{BlobUrlFile: "blob_url"},
+			TerminalImage: "gcr.io/app@sha256:5678",
+			ExpectUpdates: []clientgotesting.UpdateActionImpl{
+				{Object: updatedImage},
+			},
+			ExpectedImageToWaitOn: updatedImage,
+			ExpectedVersion: oc.Version{
+				"image":  "gcr.io/app@sha256:5678",
+				"digest": "sha256:5678",
+			},
+		}.test(t)
+	})
+
+	it("reads the concourse build back from the kpack build", func() {
+		build := &v1alpha2.Build{
+			ObjectMeta: v1.ObjectMeta{
+				Name:      "app-build-3",
+				Namespace: namespace,
+				Labels: map[string]string{
+					v1alpha2.ImageLabel:       "app",
+					v1alpha2.BuildNumberLabel: "3",
+				},
+				Annotations: map[string]string{
+					v1alpha2.BuildReasonAnnotation: "CONFIG",
+					"concourse.ci/build-url":       "https://ci.example.com/teams/main/pipelines/app/jobs/build/builds/7",
+					"concourse.ci/team":            "main",
+					"concourse.ci/pipeline":        "app",
+					"concourse.ci/job":             "build",
+					"concourse.ci/build-name":      "7",
+					"concourse.ci/trigger-version": "some-revision",
+				},
+			},
+			Spec: v1alpha2.BuildSpec{
+				Source: corev1alpha1.SourceConfig{
+					Git: &corev1alpha1.Git{URL: "https://some.git.com", Revision: "some-revision"},
+				},
+			},
+			Status: v1alpha2.BuildStatus{
+				LatestImage: "gcr.io/app@sha256:5678",
+			},
+		}
+
+		InTest{
+			Objects:         []runtime.Object{build},
+			OutDir:          dir,
+			Source:          resource.Source{Image: "app", Namespace: namespace},
+			Version:         oc.Version{"image": "gcr.io/app@sha256:5678"},
+			ExpectedVersion: oc.Version{"image": "gcr.io/app@sha256:5678"},
+			ExpectedMetadata: oc.Metadata{
+				{Name: "buildNumber", Value: "3"},
+				{Name: "buildName", Value: "app-build-3"},
+				{Name: "buildReason", Value: "CONFIG"},
+				{Name: "gitCommit", Value: "some-revision"},
+				{Name: "gitUrl", Value: "https://some.git.com"},
+				{Name: "concourseBuildUrl", Value: "https://ci.example.com/teams/main/pipelines/app/jobs/build/builds/7"},
+				{Name: "concourseTeam", Value: "main"},
+				{Name: "concoursePipeline", Value: "app"},
+				{Name: "concourseJob", Value: "build"},
+				{Name: "concourseBuildName", Value: "7"},
+				{Name: "concourseTriggerVersion", Value: "some-revision"},
+			},
+		}.test(t)
+	})
+}
@@ -0,0 +1,180 @@
+// Copyright 2020-Present VMware, Inc.
+// SPDX-License-Identifier: Apache-2.0
+
+package resource
+
+import (
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"path/filepath"
+
+	oc "github.com/cloudboss/ofcourse/ofcourse"
+	"github.com/pivotal/kpack/pkg/apis/build/v1alpha2"
+	corev1alpha1 "github.com/pivotal/kpack/pkg/apis/core/v1alpha1"
+	"github.com/pkg/errors"
+	k8serrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/yaml"
+)
+
+const orderFile = "order.json"
+
+// kpackBuilder is either a namespaced Builder or a ClusterBuilder.
+type kpackBuilder struct {
+	builder        *v1alpha2.Builder
+	clusterBuilder *v1alpha2.ClusterBuilder
+}
+
+func getBuilder(ctx context.Context, kpackClient KpackClient, src Source) (kpackBuilder, error) {
+	var (
+		b   kpackBuilder
+		err error
+	)
+	if src.resourceKind() == KindClusterBuilder {
+		b.clusterBuilder, err = kpackClient.GetClusterBuilder(ctx, src.Name)
+	} else {
+		b.builder, err = kpackClient.GetBuilder(ctx, src.Namespace, src.Name)
+	}
+
+	if k8serrors.IsNotFound(err) {
+		return kpackBuilder{}, errors.Errorf("%s '%s' does not exist", src.resourceKind(), src.Name)
+	}
+	return b, err
+}
+
+func (b kpackBuilder) update(ctx context.Context, kpackClient KpackClient) (kpackBuilder, error) {
+	var err error
+	if b.clusterBuilder != nil {
+		b.clusterBuilder, err = kpackClient.UpdateClusterBuilder(ctx, b.clusterBuilder)
+	} else {
+		b.builder, err = kpackClient.UpdateBuilder(ctx, b.builder)
+	}
+	return b, err
+}
+
+func (b kpackBuilder) meta() *metav1.ObjectMeta {
+	if b.clusterBuilder != nil {
+		return &b.clusterBuilder.ObjectMeta
+	}
+	return &b.builder.ObjectMeta
+}
+
+func (b kpackBuilder) spec() *v1alpha2.BuilderSpec {
+	if b.clusterBuilder != nil {
+		return &b.clusterBuilder.Spec.BuilderSpec
+	}
+	return &b.builder.Spec.BuilderSpec
+}
+
+func (b kpackBuilder) status() v1alpha2.BuilderStatus {
+	if b.clusterBuilder != nil {
+		return b.clusterBuilder.Status
+	}
+	return b.builder.Status
+}
+
+func checkBuilder(ctx context.Context, kpackClient KpackClient, src Source, version oc.Version) ([]oc.Version, error) {
+	b, err := getBuilder(ctx, kpackClient, src)
+	if err != nil {
+		return nil, err
+	}
+
+	status := b.status()
+	if !status.GetCondition(corev1alpha1.ConditionReady).IsTrue() || status.LatestImage == "" || status.LatestImage == version["image"] {
+		return nil, nil
+	}
+
+	return []oc.Version{{"image": status.LatestImage}}, nil
+}
+
+func inBuilder(ctx context.Context, kpackClient KpackClient, outDir string, src Source, version oc.Version) (oc.Version, oc.Metadata, error) {
+	b, err := getBuilder(ctx, kpackClient, src)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	status := b.status()
+	if status.LatestImage != version["image"] {
+		return nil, nil, errors.Errorf("%s '%s' no longer resolves to image '%s'", src.resourceKind(), src.Name, version["image"])
+	}
+
+	err = ioutil.WriteFile(filepath.Join(outDir, imageFile), []byte(version["image"]), 0644)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	order, err := json.MarshalIndent(status.Order, "", "  ")
+	if err != nil {
+		return nil, nil, err
+	}
+
+	err = ioutil.WriteFile(filepath.Join(outDir, orderFile), order, 0644)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return version, oc.Metadata{
+		{Name: "stack", Value: status.Stack.ID},
+		{Name: "runImage", Value: status.Stack.RunImage},
+	}, nil
+}
+
+func (o *Out) outBuilder(ctx context.Context, kpackClient KpackClient, inDir string, src Source, params OutParams, log Logger) (oc.Version, oc.Metadata, error) {
+	if params.OrderFile == "" && params.Stack == "" {
+		return nil, nil, errors.Errorf("either order_file or stack is required")
+	}
+
+	b, err := getBuilder(ctx, kpackClient, src)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	spec := b.spec()
+	log.Infof("Updating %s '%s'.\n", src.resourceKind(), src.Name)
+
+	if params.Stack != "" {
+		log.Infof("Previous stack: %s\nNew stack: %s\n\n", red(spec.Stack.Name), green(params.Stack))
+		spec.Stack.Name = params.Stack
+	}
+
+	if params.OrderFile != "" {
+		fileContents, err := ioutil.ReadFile(filepath.Join(inDir, params.OrderFile))
+		if err != nil {
+			return nil, nil, errors.Wrapf(err, "reading order: %s", params.OrderFile)
+		}
+
+		var order []corev1alpha1.OrderEntry
+		err = yaml.Unmarshal(fileContents, &order)
+		if err != nil {
+			return nil, nil, errors.Wrapf(err, "parsing order: %s", params.OrderFile)
+		}
+
+		log.Infof("Previous order: %s\nNew order: %s\n\n", red(orderString(spec.Order)), green(orderString(order)))
+		spec.Order = order
+	}
+
+	b, err = b.update(ctx, kpackClient)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	log.Infof(purple("Waiting on kpack to process update...\n\n"))
+	err = pollUntilReady(ctx, src.resourceKind(), src.Name, b.meta().Generation, func(ctx context.Context) (corev1alpha1.Status, error) {
+		b, err = getBuilder(ctx, kpackClient, src)
+		if err != nil {
+			return corev1alpha1.Status{}, err
+		}
+		return b.status().Status, nil
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return oc.Version{"image": b.status().LatestImage}, nil, nil
+}
+
+func orderString(order []corev1alpha1.OrderEntry) string {
+	bytes, _ := json.Marshal(order)
+	return string(bytes)
+}
@@ -0,0 +1,261 @@
+// Copyright 2020-Present VMware, Inc.
+// SPDX-License-Identifier: Apache-2.0
+
+package resource_test
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	oc "github.com/cloudboss/ofcourse/ofcourse"
+	"github.com/pivotal/kpack/pkg/apis/build/v1alpha2"
+	corev1alpha1 "github.com/pivotal/kpack/pkg/apis/core/v1alpha1"
+	"github.com/sclevine/spec"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	corev1 "k8s.io/api/core/v1"
+	v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	clientgotesting "k8s.io/client-go/testing"
+
+	"github.com/pivotal/concourse-kpack-resource/resource"
+)
+
+func TestBuilder(t *testing.T) {
+	spec.Run(t, "TestBuilder", testBuilder)
+}
+
+func testBuilder(t *testing.T, when spec.G, it spec.S) {
+	const (
+		builderImage = "some.reg.io/builder@sha256:3c4f1cbbd81abb0ba4b4ed3f3fcab6b84a8d25bf6a3c1cde5c67a7e6f78c5f60"
+		namespace    = "test-namespace"
+	)
+
+	var (
+		dir     string
+		builder *v1alpha2.Builder
+		order   = []corev1alpha1.OrderEntry{
+			{Group: []corev1alpha1.BuildpackRef{{BuildpackInfo: corev1alpha1.BuildpackInfo{Id: "paketo-buildpacks/java"}}}},
+		}
+	)
+
+	it.Before(func() {
+		var err error
+		dir, err = ioutil.TempDir("", "builder_test")
+		require.NoError(t, err)
+
+		builder = &v1alpha2.Builder{
+			ObjectMeta: v1.ObjectMeta{
+				Name:      "test-builder",
+				Namespace: namespace,
+			},
+			Spec: v1alpha2.NamespacedBuilderSpec{
+				BuilderSpec: v1alpha2.BuilderSpec{
+					Stack: corev1.ObjectReference{Kind: "ClusterStack", Name: "old-stack"},
+					Order: order,
+				},
+			},
+			Status: v1alpha2.BuilderStatus{
+				Status: corev1alpha1.Status{
+					Conditions: corev1alpha1.Conditions{
+						{Type: corev1alpha1.ConditionReady, Status: corev1.ConditionTrue},
+					},
+				},
+				Order:       order,
+				LatestImage: builderImage,
+				Stack: corev1alpha1.BuildStack{
+					RunImage: "some.reg.io/run@sha256:123",
+					ID:       "io.buildpacks.stacks.jammy",
+				},
+			},
+		}
+	})
+
+	it.After(func() {
+		os.RemoveAll(dir)
+	})
+
+	source := resource.Source{
+		Kind:      "builder",
+		Name:      "test-builder",
+		Namespace: namespace,
+	}
+
+	when("checking", func() {
+		it("emits the latest builder image", func() {
+			CheckTest{
+				Objects: []runtime.Object{builder},
+				Source:  source,
+				ExpectedVersion: []oc.Version{
+					{"image": builderImage},
+				},
+			}.test(t)
+		})
+
+		it("does not emit an already checked builder image", func() {
+			CheckTest{
+				Objects: []runtime.Object{builder},
+				Source:  source,
+				Version: oc.Version{"image": builderImage},
+			}.test(t)
+		})
+
+		it("does not emit a builder that is not ready", func() {
+			builder.Status.Conditions[0].Status = corev1.ConditionFalse
+
+			CheckTest{
+				Objects: []runtime.Object{builder},
+				Source:  source,
+			}.test(t)
+		})
+
+		it("emits the latest cluster builder image", func() {
+			CheckTest{
+				Objects: []runtime.Object{
+					&v1alpha2.ClusterBuilder{
+						ObjectMeta: v1.ObjectMeta{Name: "test-cluster-builder"},
+						Status:     builder.Status,
+					},
+				},
+				Source: resource.Source{
+					Kind: "clusterbuilder",
+					Name: "test-cluster-builder",
+				},
+				ExpectedVersion: []oc.Version{
+					{"image": builderImage},
+				},
+			}.test(t)
+		})
+	})
+
+	it("writes the builder image and order", func() {
+		InTest{
+			Objects: []runtime.Object{builder},
+			OutDir:  dir,
+			Source:  source,
+			Version: oc.Version{"image": builderImage},
+			ExpectedVersion: oc.Version{
+				"image": builderImage,
+			},
+			ExpectedMetadata: oc.Metadata{
+				{Name: "stack", Value: "io.buildpacks.stacks.jammy"},
+				{Name: "runImage", Value: "some.reg.io/run@sha256:123"},
+			},
+		}.test(t)
+
+		assertFileContents(t, filepath.Join(dir, "image"), builderImage)
+		assertFileContents(t, filepath.Join(dir, "order.json"), `[
+  {
+    "group": [
+      {
+        "id": "paketo-buildpacks/java"
+      }
+    ]
+  }
+]`)
+	})
+
+	it("returns an error when the builder no longer resolves to the version", func() {
+		InTest{
+			Objects:     []runtime.Object{builder},
+			OutDir:      dir,
+			Source:      source,
+			Version:     oc.Version{"image": "some.reg.io/builder@sha256:previous"},
+			ExpectError: "builder 'test-builder' no longer resolves to image 'some.reg.io/builder@sha256:previous'",
+		}.test(t)
+
+		assert.NoFileExists(t, filepath.Join(dir, "image"))
+		assert.NoFileExists(t, filepath.Join(dir, "order.json"))
+	})
+
+	when("putting", func() {
+		it("updates the stack and order", func() {
+			err := ioutil.WriteFile(filepath.Join(dir, "order.yml"), []byte(`
+- group:
+  - id: paketo-buildpacks/nodejs
+`), 0644)
+			require.NoError(t, err)
+
+			updatedBuilder := builder.DeepCopy()
+			updatedBuilder.Spec.Stack.Name = "new-stack"
+			updatedBuilder.Spec.Order = []corev1alpha1.OrderEntry{
+				{Group: []corev1alpha1.BuildpackRef{{BuildpackInfo: corev1alpha1.BuildpackInfo{Id: "paketo-buildpacks/nodejs"}}}},
+			}
+
+			OutTest{
+				InDir:   dir,
+				Objects: []runtime.Object{builder},
+				Source:  source,
+				Parameters: resource.OutParams{
+					Stack:     "new-stack",
+					OrderFile: "order.yml",
+				},
+				ExpectedOutput: []string{
+					"Updating builder 'test-builder'",
+					"Previous stack", "old-stack",
+					"New stack", "new-stack",
+					"New order", "paketo-buildpacks/nodejs",
+				},
+				ExpectUpdates: []clientgotesting.UpdateActionImpl{
+					{Object: updatedBuilder},
+				},
+				ExpectedVersion: oc.Version{
+					"image": builderImage,
+				},
+			}.test(t)
+		})
+
+		it("returns an error when the builder fails", func() {
+			builder.Status.Conditions[0].Status = corev1.ConditionFalse
+			builder.Status.Conditions[0].Message = "some failure"
+
+			updatedBuilder := builder.DeepCopy()
+			updatedBuilder.Spec.Stack.Name = "new-stack"
+
+			OutTest{
+				InDir:   dir,
+				Objects: []runtime.Object{builder},
+				Source:  source,
+				Parameters: resource.OutParams{
+					Stack: "new-stack",
+				},
+				ExpectUpdates: []clientgotesting.UpdateActionImpl{
+					{Object: updatedBuilder},
+				},
+				ExpectError: "update to builder test-builder failed: some failure",
+			}.test(t)
+		})
+
+		it("returns an error when the builder is not ready before the timeout", func() {
+			builder.Status.Conditions[0].Status = corev1.ConditionUnknown
+
+			updatedBuilder := builder.DeepCopy()
+			updatedBuilder.Spec.Stack.Name = "new-stack"
+
+			OutTest{
+				InDir:   dir,
+				Objects: []runtime.Object{builder},
+				Source:  source,
+				Parameters: resource.OutParams{
+					Stack:   "new-stack",
+					Timeout: "10ms",
+				},
+				ExpectUpdates: []clientgotesting.UpdateActionImpl{
+					{Object: updatedBuilder},
+				},
+				ExpectError: "timed out waiting for builder test-builder to be ready",
+			}.test(t)
+		})
+
+		it("returns an error without an order_file or stack", func() {
+			OutTest{
+				InDir:       dir,
+				Objects:     []runtime.Object{builder},
+				Source:      source,
+				ExpectError: "either order_file or stack is required",
+			}.test(t)
+		})
+	})
+}
@@ -5,51 +5,84 @@ package resource
 
 import (
 	"context"
-	"fmt"
 	"sort"
 
 	oc "github.com/cloudboss/ofcourse/ofcourse"
-	"github.com/pivotal/kpack/pkg/apis/build/v1alpha1"
+	"github.com/pivotal/kpack/pkg/apis/build/v1alpha2"
 	corev1alpha1 "github.com/pivotal/kpack/pkg/apis/core/v1alpha1"
 	"github.com/pivotal/kpack/pkg/client/clientset/versioned"
-	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"github.com/pkg/errors"
 )
 
 func Check(ctx context.Context, clientset versioned.Interface, source Source, version oc.Version, env oc.Environment, logger Logger) ([]oc.Version, error) {
-	buildList, err := clientset.KpackV1alpha1().Builds(source.Namespace).List(ctx, metav1.ListOptions{
-		LabelSelector: fmt.Sprintf("%s=%s", v1alpha1.ImageLabel, source.Image),
-	})
+	kpackClient, err := NewKpackClient(ctx, clientset)
+	if err != nil {
+		return nil, err
+	}
+
+	switch kind := source.resourceKind(); kind {
+	case KindImage:
+	case KindBuilder, KindClusterBuilder:
+		return checkBuilder(ctx, kpackClient, source, version)
+	case KindClusterStack:
+		return checkClusterStack(ctx, kpackClient, source, version)
+	case KindClusterStore:
+		return checkClusterStore(ctx, kpackClient, source, version)
+	default:
+		return nil, errors.Errorf("unsupported kind '%s'", kind)
+	}
+
+	builds, err := listImageBuilds(ctx, kpackClient, source)
 	if err != nil {
 		return nil, err
 	}
 
-	builds := filterBuilds(buildList.Items)
 	index, _ := indexOfBuild(builds, version)
 	builds = builds[index+1:]
 
 	var versions []oc.Version
 	for _, build := range builds {
 		if build.Status.GetCondition(corev1alpha1.ConditionSucceeded).IsTrue() {
-			versions = append(versions, map[string]string{
-				"image": build.Status.LatestImage,
-			})
+			version := imageVersion(build.Status.LatestImage)
+			if source.multiImage() {
+				version["name"] = build.Labels[v1alpha2.ImageLabel]
+			}
+			versions = append(versions, version)
 		}
 	}
 
 	return versions, nil
 }
 
-func filterBuilds(items []v1alpha1.Build) []v1alpha1.Build {
+func filterBuilds(items []v1alpha2.Build) []v1alpha2.Build {
 	sort.Slice(items, func(i, j int) bool {
 		return items[i].CreationTimestamp.Before(&items[j].CreationTimestamp)
 	})
 	return items
 }
 
-func indexOfBuild(items []v1alpha1.Build, version oc.Version) (int, bool) {
+// indexOfBuild finds the latest build that produced version. The combined
+// version of a put that updated several images matches the latest build of
+// any of them.
+func indexOfBuild(items []v1alpha2.Build, version oc.Version) (int, bool) {
 	for i := len(items) - 1; i >= 0; i-- {
 		build := items[i]
-		if build.Status.LatestImage != "" && build.Status.LatestImage == version["image"] {
+		if build.Status.LatestImage == "" {
+			continue
+		}
+
+		imageName := build.Labels[v1alpha2.ImageLabel]
+		if image, ok := version[imageVersionKey(imageName)]; ok {
+			if build.Status.LatestImage == image {
+				return i, true
+			}
+			continue
+		}
+
+		if build.Status.LatestImage != version["image"] {
+			continue
+		}
+		if name, ok := version["name"]; !ok || imageName == name {
 			return i, true
 		}
 	}
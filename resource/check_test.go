@@ -10,6 +10,7 @@ import (
 
 	oc "github.com/cloudboss/ofcourse/ofcourse"
 	"github.com/pivotal/kpack/pkg/apis/build/v1alpha1"
+	"github.com/pivotal/kpack/pkg/apis/build/v1alpha2"
 	corev1alpha1 "github.com/pivotal/kpack/pkg/apis/core/v1alpha1"
 	"github.com/pivotal/kpack/pkg/client/clientset/versioned/fake"
 	"github.com/sclevine/spec"
@@ -40,23 +41,23 @@ func testCheck(t *testing.T, when spec.G, it spec.S) {
 	it("provides the initial version", func() {
 		CheckTest{
 			Objects: []runtime.Object{
-				&v1alpha1.Image{
+				&v1alpha2.Image{
 					ObjectMeta: v1.ObjectMeta{
 						Name:      imageName,
 						Namespace: namespace,
 					},
 				},
-				&v1alpha1.Build{
+				&v1alpha2.Build{
 					ObjectMeta: v1.ObjectMeta{
 						Name:      "build-name",
 						Namespace: namespace,
 						Labels: map[string]string{
-							v1alpha1.ImageLabel:       imageName,
-							v1alpha1.BuildNumberLabel: "1",
+							v1alpha2.ImageLabel:       imageName,
+							v1alpha2.BuildNumberLabel: "1",
 						},
 						CreationTimestamp: v1.Time{Time: firstBuildTime},
 					},
-					Status: v1alpha1.BuildStatus{
+					Status: v1alpha2.BuildStatus{
 						Status: corev1alpha1.Status{
 							Conditions: corev1alpha1.Conditions{
 								{
@@ -68,17 +69,17 @@ func testCheck(t *testing.T, when spec.G, it spec.S) {
 						LatestImage: "some/image@sha256:07c5121b7bc36783614544bd4a7cd6618dc04b963d926cf6e318268cfead0530",
 					},
 				},
-				&v1alpha1.Build{
+				&v1alpha2.Build{
 					ObjectMeta: v1.ObjectMeta{
 						Name:      "not-ready-build",
 						Namespace: namespace,
 						Labels: map[string]string{
-							v1alpha1.ImageLabel:       imageName,
-							v1alpha1.BuildNumberLabel: "2",
+							v1alpha2.ImageLabel:       imageName,
+							v1alpha2.BuildNumberLabel: "2",
 						},
 						CreationTimestamp: v1.Time{Time: firstBuildTime.Add(time.Minute)},
 					},
-					Status: v1alpha1.BuildStatus{
+					Status: v1alpha2.BuildStatus{
 						Status: corev1alpha1.Status{
 							Conditions: corev1alpha1.Conditions{
 								{
@@ -97,7 +98,8 @@ func testCheck(t *testing.T, when spec.G, it spec.S) {
 			Version: nil,
 			ExpectedVersion: []oc.Version{
 				map[string]string{
-					"image": "some/image@sha256:07c5121b7bc36783614544bd4a7cd6618dc04b963d926cf6e318268cfead0530",
+					"image":  "some/image@sha256:07c5121b7bc36783614544bd4a7cd6618dc04b963d926cf6e318268cfead0530",
+					"digest": "sha256:07c5121b7bc36783614544bd4a7cd6618dc04b963d926cf6e318268cfead0530",
 				},
 			},
 		}.test(t)
@@ -106,23 +108,23 @@ func testCheck(t *testing.T, when spec.G, it spec.S) {
 	it("does not return builds already checked", func() {
 		CheckTest{
 			Objects: []runtime.Object{
-				&v1alpha1.Image{
+				&v1alpha2.Image{
 					ObjectMeta: v1.ObjectMeta{
 						Name:      imageName,
 						Namespace: namespace,
 					},
 				},
-				&v1alpha1.Build{
+				&v1alpha2.Build{
 					ObjectMeta: v1.ObjectMeta{
 						Name:      "build-name",
 						Namespace: namespace,
 						Labels: map[string]string{
-							v1alpha1.ImageLabel:       imageName,
-							v1alpha1.BuildNumberLabel: "1",
+							v1alpha2.ImageLabel:       imageName,
+							v1alpha2.BuildNumberLabel: "1",
 						},
 						CreationTimestamp: v1.Time{Time: firstBuildTime},
 					},
-					Status: v1alpha1.BuildStatus{
+					Status: v1alpha2.BuildStatus{
 						Status: corev1alpha1.Status{
 							Conditions: corev1alpha1.Conditions{
 								{
@@ -149,23 +151,23 @@ func testCheck(t *testing.T, when spec.G, it spec.S) {
 	it("returns the next version after the previous checked version", func() {
 		CheckTest{
 			Objects: []runtime.Object{
-				&v1alpha1.Image{
+				&v1alpha2.Image{
 					ObjectMeta: v1.ObjectMeta{
 						Name:      imageName,
 						Namespace: namespace,
 					},
 				},
-				&v1alpha1.Build{
+				&v1alpha2.Build{
 					ObjectMeta: v1.ObjectMeta{
 						Name:      "build-name",
 						Namespace: namespace,
 						Labels: map[string]string{
-							v1alpha1.ImageLabel:       imageName,
-							v1alpha1.BuildNumberLabel: "1",
+							v1alpha2.ImageLabel:       imageName,
+							v1alpha2.BuildNumberLabel: "1",
 						},
 						CreationTimestamp: v1.Time{Time: firstBuildTime.Add(time.Minute)},
 					},
-					Status: v1alpha1.BuildStatus{
+					Status: v1alpha2.BuildStatus{
 						Status: corev1alpha1.Status{
 							Conditions: corev1alpha1.Conditions{
 								{
@@ -177,17 +179,17 @@ func testCheck(t *testing.T, when spec.G, it spec.S) {
 						LatestImage: "some/image@sha256:07c5121b7bc36783614544bd4a7cd6618dc04b963d926cf6e318268cfead0530",
 					},
 				},
-				&v1alpha1.Build{
+				&v1alpha2.Build{
 					ObjectMeta: v1.ObjectMeta{
 						Name:      "build-name-2",
 						Namespace: namespace,
 						Labels: map[string]string{
-							v1alpha1.ImageLabel:       imageName,
-							v1alpha1.BuildNumberLabel: "2",
+							v1alpha2.ImageLabel:       imageName,
+							v1alpha2.BuildNumberLabel: "2",
 						},
 						CreationTimestamp: v1.Time{Time: firstBuildTime.Add(time.Minute)},
 					},
-					Status: v1alpha1.BuildStatus{
+					Status: v1alpha2.BuildStatus{
 						Status: corev1alpha1.Status{
 							Conditions: corev1alpha1.Conditions{
 								{
@@ -209,7 +211,8 @@ func testCheck(t *testing.T, when spec.G, it spec.S) {
 			},
 			ExpectedVersion: []oc.Version{
 				map[string]string{
-					"image": "some/image@sha256:4be3b8b101ee62ba005fcb23d2fa76adad27161a6a60f27f8970e81e9c1def69",
+					"image":  "some/image@sha256:4be3b8b101ee62ba005fcb23d2fa76adad27161a6a60f27f8970e81e9c1def69",
+					"digest": "sha256:4be3b8b101ee62ba005fcb23d2fa76adad27161a6a60f27f8970e81e9c1def69",
 				},
 			},
 		}.test(t)
@@ -218,23 +221,23 @@ func testCheck(t *testing.T, when spec.G, it spec.S) {
 	it("does not return a pervious checked version if builds out of order", func() {
 		CheckTest{
 			Objects: []runtime.Object{
-				&v1alpha1.Image{
+				&v1alpha2.Image{
 					ObjectMeta: v1.ObjectMeta{
 						Name:      imageName,
 						Namespace: namespace,
 					},
 				},
-				&v1alpha1.Build{
+				&v1alpha2.Build{
 					ObjectMeta: v1.ObjectMeta{
 						Name:      "build-name-2",
 						Namespace: namespace,
 						Labels: map[string]string{
-							v1alpha1.ImageLabel:       imageName,
-							v1alpha1.BuildNumberLabel: "2",
+							v1alpha2.ImageLabel:       imageName,
+							v1alpha2.BuildNumberLabel: "2",
 						},
 						CreationTimestamp: v1.Time{Time: firstBuildTime.Add(time.Minute)},
 					},
-					Status: v1alpha1.BuildStatus{
+					Status: v1alpha2.BuildStatus{
 						Status: corev1alpha1.Status{
 							Conditions: corev1alpha1.Conditions{
 								{
@@ -246,6 +249,44 @@ func testCheck(t *testing.T, when spec.G, it spec.S) {
 						LatestImage: "some/image@sha256:4be3b8b101ee62ba005fcb23d2fa76adad27161a6a60f27f8970e81e9c1def69",
 					},
 				},
+				&v1alpha2.Build{
+					ObjectMeta: v1.ObjectMeta{
+						Name:      "build-name",
+						Namespace: namespace,
+						Labels: map[string]string{
+							v1alpha2.ImageLabel:       imageName,
+							v1alpha2.BuildNumberLabel: "1",
+						},
+						CreationTimestamp: v1.Time{Time: firstBuildTime},
+					},
+					Status: v1alpha2.BuildStatus{
+						Status: corev1alpha1.Status{
+							Conditions: corev1alpha1.Conditions{
+								{
+									Type:   corev1alpha1.ConditionSucceeded,
+									Status: corev1.ConditionTrue,
+								},
+							},
+						},
+						LatestImage: "some/image@sha256:07c5121b7bc36783614544bd4a7cd6618dc04b963d926cf6e318268cfead0530",
+					},
+				},
+			},
+			Source: resource.Source{
+				Image:     imageName,
+				Namespace: namespace,
+			},
+			Version: map[string]string{
+				"image": "some/image@sha256:4be3b8b101ee62ba005fcb23d2fa76adad27161a6a60f27f8970e81e9c1def69",
+			},
+			ExpectedVersion: nil,
+		}.test(t)
+	})
+
+	it("falls back to v1alpha1 builds when v1alpha2 is not served", func() {
+		CheckTest{
+			KpackVersion: "v1alpha1",
+			Objects: []runtime.Object{
 				&v1alpha1.Build{
 					ObjectMeta: v1.ObjectMeta{
 						Name:      "build-name",
@@ -273,18 +314,22 @@ func testCheck(t *testing.T, when spec.G, it spec.S) {
 				Image:     imageName,
 				Namespace: namespace,
 			},
-			Version: map[string]string{
-				"image": "some/image@sha256:4be3b8b101ee62ba005fcb23d2fa76adad27161a6a60f27f8970e81e9c1def69",
+			Version: nil,
+			ExpectedVersion: []oc.Version{
+				map[string]string{
+					"image":  "some/image@sha256:07c5121b7bc36783614544bd4a7cd6618dc04b963d926cf6e318268cfead0530",
+					"digest": "sha256:07c5121b7bc36783614544bd4a7cd6618dc04b963d926cf6e318268cfead0530",
+				},
 			},
-			ExpectedVersion: nil,
 		}.test(t)
 	})
 }
 
 type CheckTest struct {
-	Objects []runtime.Object
-	Source  resource.Source
-	Version oc.Version
+	KpackVersion string
+	Objects      []runtime.Object
+	Source       resource.Source
+	Version      oc.Version
 
 	ExpectedOutput  string
 	ExpectedVersion []oc.Version
@@ -293,6 +338,7 @@ type CheckTest struct {
 func (b CheckTest) test(t *testing.T) {
 	t.Helper()
 	client := fake.NewSimpleClientset(b.Objects...)
+	client.Resources = testhelpers.KpackDiscovery(b.KpackVersion)
 
 	testLog := &testhelpers.Logger{}
 	versions, err := resource.Check(context.TODO(), client, b.Source, b.Version, nil, testLog)
@@ -0,0 +1,171 @@
+// Copyright 2020-Present VMware, Inc.
+// SPDX-License-Identifier: Apache-2.0
+
+package resource
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+
+	oc "github.com/cloudboss/ofcourse/ofcourse"
+	"github.com/pivotal/kpack/pkg/apis/build/v1alpha2"
+	corev1alpha1 "github.com/pivotal/kpack/pkg/apis/core/v1alpha1"
+	"github.com/pkg/errors"
+	k8serrors "k8s.io/apimachinery/pkg/api/errors"
+)
+
+const (
+	buildImageFile = "build_image"
+	runImageFile   = "run_image"
+	stackIdFile    = "stack_id"
+)
+
+func getClusterStack(ctx context.Context, kpackClient KpackClient, src Source) (*v1alpha2.ClusterStack, error) {
+	stack, err := kpackClient.GetClusterStack(ctx, src.Name)
+	if k8serrors.IsNotFound(err) {
+		return nil, errors.Errorf("clusterstack '%s' does not exist", src.Name)
+	}
+	return stack, err
+}
+
+func clusterStackVersion(stack *v1alpha2.ClusterStack) oc.Version {
+	return oc.Version{
+		"build_image": stack.Status.BuildImage.LatestImage,
+		"run_image":   stack.Status.RunImage.LatestImage,
+	}
+}
+
+func checkClusterStack(ctx context.Context, kpackClient KpackClient, src Source, version oc.Version) ([]oc.Version, error) {
+	stack, err := getClusterStack(ctx, kpackClient, src)
+	if err != nil {
+		return nil, err
+	}
+
+	if !stack.Status.GetCondition(corev1alpha1.ConditionReady).IsTrue() {
+		return nil, nil
+	}
+
+	latest := clusterStackVersion(stack)
+	if latest["build_image"] == version["build_image"] && latest["run_image"] == version["run_image"] {
+		return nil, nil
+	}
+	return []oc.Version{latest}, nil
+}
+
+func inClusterStack(ctx context.Context, kpackClient KpackClient, outDir string, src Source, version oc.Version) (oc.Version, oc.Metadata, error) {
+	stack, err := getClusterStack(ctx, kpackClient, src)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	// The stack id and mixins are only known for the images the stack
+	// currently resolves to.
+	if current := clusterStackVersion(stack); current["build_image"] != version["build_image"] || current["run_image"] != version["run_image"] {
+		return nil, nil, errors.Errorf("clusterstack '%s' no longer resolves to build image '%s' and run image '%s'", src.Name, version["build_image"], version["run_image"])
+	}
+
+	for file, contents := range map[string]string{
+		buildImageFile: version["build_image"],
+		runImageFile:   version["run_image"],
+	} {
+		err := ioutil.WriteFile(filepath.Join(outDir, file), []byte(contents), 0644)
+		if err != nil {
+			return nil, nil, err
+		}
+	}
+
+	err = ioutil.WriteFile(filepath.Join(outDir, stackIdFile), []byte(stack.Status.Id), 0644)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return version, oc.Metadata{
+		{Name: "stackId", Value: stack.Status.Id},
+		{Name: "mixins", Value: strings.Join(stack.Status.Mixins, ",")},
+	}, nil
+}
+
+func (o *Out) outClusterStack(ctx context.Context, kpackClient KpackClient, inDir string, src Source, params OutParams, log Logger) (oc.Version, oc.Metadata, error) {
+	if params.BuildImageFile == "" && params.RunImageFile == "" {
+		return nil, nil, errors.Errorf("either build_image_file or run_image_file is required")
+	}
+
+	stack, err := getClusterStack(ctx, kpackClient, src)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	log.Infof("Updating clusterstack '%s'.\n", stack.Name)
+
+	if params.BuildImageFile != "" {
+		buildImage, err := readImageRef(inDir, params.BuildImageFile)
+		if err != nil {
+			return nil, nil, errors.Wrapf(err, "reading build image: %s", params.BuildImageFile)
+		}
+
+		log.Infof("Previous build image: %s\nNew build image: %s\n\n", red(stack.Spec.BuildImage.Image), green(buildImage))
+		stack.Spec.BuildImage.Image = buildImage
+	}
+
+	if params.RunImageFile != "" {
+		runImage, err := readImageRef(inDir, params.RunImageFile)
+		if err != nil {
+			return nil, nil, errors.Wrapf(err, "reading run image: %s", params.RunImageFile)
+		}
+
+		log.Infof("Previous run image: %s\nNew run image: %s\n\n", red(stack.Spec.RunImage.Image), green(runImage))
+		stack.Spec.RunImage.Image = runImage
+	}
+
+	stack, err = kpackClient.UpdateClusterStack(ctx, stack)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	log.Infof(purple("Waiting on kpack to process update...\n\n"))
+	err = pollUntilReady(ctx, KindClusterStack, stack.Name, stack.Generation, func(ctx context.Context) (corev1alpha1.Status, error) {
+		stack, err = getClusterStack(ctx, kpackClient, src)
+		if err != nil {
+			return corev1alpha1.Status{}, err
+		}
+		return stack.Status.Status, nil
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return clusterStackVersion(stack), oc.Metadata{
+		{Name: "stackId", Value: stack.Status.Id},
+	}, nil
+}
+
+// readImageRef reads an image reference from a file, or from the
+// repository and digest files of a registry-image resource directory.
+func readImageRef(inDir, path string) (string, error) {
+	path = filepath.Join(inDir, path)
+
+	info, err := os.Stat(path)
+	if err != nil {
+		return "", err
+	}
+
+	if !info.IsDir() {
+		contents, err := ioutil.ReadFile(path)
+		return strings.TrimSpace(string(contents)), err
+	}
+
+	repository, err := ioutil.ReadFile(filepath.Join(path, "repository"))
+	if err != nil {
+		return "", err
+	}
+
+	digest, err := ioutil.ReadFile(filepath.Join(path, "digest"))
+	if err != nil {
+		return "", err
+	}
+
+	return strings.TrimSpace(string(repository)) + "@" + strings.TrimSpace(string(digest)), nil
+}
@@ -0,0 +1,222 @@
+// Copyright 2020-Present VMware, Inc.
+// SPDX-License-Identifier: Apache-2.0
+
+package resource_test
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	oc "github.com/cloudboss/ofcourse/ofcourse"
+	"github.com/pivotal/kpack/pkg/apis/build/v1alpha2"
+	corev1alpha1 "github.com/pivotal/kpack/pkg/apis/core/v1alpha1"
+	"github.com/sclevine/spec"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	corev1 "k8s.io/api/core/v1"
+	v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	clientgotesting "k8s.io/client-go/testing"
+
+	"github.com/pivotal/concourse-kpack-resource/resource"
+)
+
+func TestClusterStack(t *testing.T) {
+	spec.Run(t, "TestClusterStack", testClusterStack)
+}
+
+func testClusterStack(t *testing.T, when spec.G, it spec.S) {
+	const (
+		buildImage = "some.reg.io/build@sha256:9d2b0fa2e9b1c2b0f9a3e4d7a3e0f1e3c1a7c8c8d9e5f6a7b8c9d0e1f2a3b4c5"
+		runImage   = "some.reg.io/run@sha256:1a2b3c4d5e6f7a8b9c0d1e2f3a4b5c6d7e8f9a0b1c2d3e4f5a6b7c8d9e0f1a2b"
+	)
+
+	var (
+		dir   string
+		stack *v1alpha2.ClusterStack
+	)
+
+	it.Before(func() {
+		var err error
+		dir, err = ioutil.TempDir("", "cluster_stack_test")
+		require.NoError(t, err)
+
+		stack = &v1alpha2.ClusterStack{
+			ObjectMeta: v1.ObjectMeta{
+				Name: "test-stack",
+			},
+			Spec: v1alpha2.ClusterStackSpec{
+				Id:         "io.buildpacks.stacks.jammy",
+				BuildImage: v1alpha2.ClusterStackSpecImage{Image: "some.reg.io/build:old"},
+				RunImage:   v1alpha2.ClusterStackSpecImage{Image: "some.reg.io/run:old"},
+			},
+			Status: v1alpha2.ClusterStackStatus{
+				Status: corev1alpha1.Status{
+					Conditions: corev1alpha1.Conditions{
+						{Type: corev1alpha1.ConditionReady, Status: corev1.ConditionTrue},
+					},
+				},
+				ResolvedClusterStack: v1alpha2.ResolvedClusterStack{
+					Id:         "io.buildpacks.stacks.jammy",
+					BuildImage: v1alpha2.ClusterStackStatusImage{LatestImage: buildImage},
+					RunImage:   v1alpha2.ClusterStackStatusImage{LatestImage: runImage},
+					Mixins:     []string{"some-mixin"},
+				},
+			},
+		}
+	})
+
+	it.After(func() {
+		os.RemoveAll(dir)
+	})
+
+	source := resource.Source{
+		Kind: "clusterstack",
+		Name: "test-stack",
+	}
+
+	when("checking", func() {
+		it("emits the resolved stack images", func() {
+			CheckTest{
+				Objects: []runtime.Object{stack},
+				Source:  source,
+				ExpectedVersion: []oc.Version{
+					{"build_image": buildImage, "run_image": runImage},
+				},
+			}.test(t)
+		})
+
+		it("does not emit already checked stack images", func() {
+			CheckTest{
+				Objects: []runtime.Object{stack},
+				Source:  source,
+				Version: oc.Version{"build_image": buildImage, "run_image": runImage},
+			}.test(t)
+		})
+	})
+
+	it("writes the resolved stack images", func() {
+		InTest{
+			Objects: []runtime.Object{stack},
+			OutDir:  dir,
+			Source:  source,
+			Version: oc.Version{"build_image": buildImage, "run_image": runImage},
+			ExpectedVersion: oc.Version{
+				"build_image": buildImage,
+				"run_image":   runImage,
+			},
+			ExpectedMetadata: oc.Metadata{
+				{Name: "stackId", Value: "io.buildpacks.stacks.jammy"},
+				{Name: "mixins", Value: "some-mixin"},
+			},
+		}.test(t)
+
+		assertFileContents(t, filepath.Join(dir, "build_image"), buildImage)
+		assertFileContents(t, filepath.Join(dir, "run_image"), runImage)
+		assertFileContents(t, filepath.Join(dir, "stack_id"), "io.buildpacks.stacks.jammy")
+	})
+
+	it("returns an error when the stack no longer resolves to the version", func() {
+		InTest{
+			Objects:     []runtime.Object{stack},
+			OutDir:      dir,
+			Source:      source,
+			Version:     oc.Version{"build_image": buildImage, "run_image": "some.registry.io/run@sha256:previous"},
+			ExpectError: "clusterstack 'test-stack' no longer resolves to build image '" + buildImage + "' and run image 'some.registry.io/run@sha256:previous'",
+		}.test(t)
+
+		assert.NoFileExists(t, filepath.Join(dir, "stack_id"))
+	})
+
+	when("putting", func() {
+		it("updates the build and run images", func() {
+			err := ioutil.WriteFile(filepath.Join(dir, "build-image-ref"), []byte("some.reg.io/build:new\n"), 0644)
+			require.NoError(t, err)
+
+			runImageDir := filepath.Join(dir, "run-image")
+			require.NoError(t, os.Mkdir(runImageDir, 0755))
+			require.NoError(t, ioutil.WriteFile(filepath.Join(runImageDir, "repository"), []byte("some.reg.io/run\n"), 0644))
+			require.NoError(t, ioutil.WriteFile(filepath.Join(runImageDir, "digest"), []byte("sha256:abc\n"), 0644))
+
+			updatedStack := stack.DeepCopy()
+			updatedStack.Spec.BuildImage.Image = "some.reg.io/build:new"
+			updatedStack.Spec.RunImage.Image = "some.reg.io/run@sha256:abc"
+
+			OutTest{
+				InDir:   dir,
+				Objects: []runtime.Object{stack},
+				Source:  source,
+				Parameters: resource.OutParams{
+					BuildImageFile: "build-image-ref",
+					RunImageFile:   "run-image",
+				},
+				ExpectedOutput: []string{
+					"Updating clusterstack 'test-stack'",
+					"Previous build image", "some.reg.io/build:old",
+					"New build image", "some.reg.io/build:new",
+					"Previous run image", "some.reg.io/run:old",
+					"New run image", "some.reg.io/run@sha256:abc",
+				},
+				ExpectUpdates: []clientgotesting.UpdateActionImpl{
+					{Object: updatedStack},
+				},
+				ExpectedVersion: oc.Version{
+					"build_image": buildImage,
+					"run_image":   runImage,
+				},
+				ExpectedMetadata: oc.Metadata{
+					{Name: "stackId", Value: "io.buildpacks.stacks.jammy"},
+				},
+			}.test(t)
+		})
+
+		it("returns an error when the stack fails", func() {
+			stack.Status.Conditions[0].Status = corev1.ConditionFalse
+			stack.Status.Conditions[0].Message = "some failure"
+
+			err := ioutil.WriteFile(filepath.Join(dir, "build-image-ref"), []byte("some.reg.io/build:new"), 0644)
+			require.NoError(t, err)
+
+			updatedStack := stack.DeepCopy()
+			updatedStack.Spec.BuildImage.Image = "some.reg.io/build:new"
+
+			OutTest{
+				InDir:   dir,
+				Objects: []runtime.Object{stack},
+				Source:  source,
+				Parameters: resource.OutParams{
+					BuildImageFile: "build-image-ref",
+				},
+				ExpectUpdates: []clientgotesting.UpdateActionImpl{
+					{Object: updatedStack},
+				},
+				ExpectError: "update to clusterstack test-stack failed: some failure",
+			}.test(t)
+		})
+
+		it("returns an error if the stack does not exist", func() {
+			err := ioutil.WriteFile(filepath.Join(dir, "build-image-ref"), []byte("some.reg.io/build:new"), 0644)
+			require.NoError(t, err)
+
+			OutTest{
+				InDir:  dir,
+				Source: resource.Source{Kind: "clusterstack", Name: "does-not-exist"},
+				Parameters: resource.OutParams{
+					BuildImageFile: "build-image-ref",
+				},
+				ExpectError: "clusterstack 'does-not-exist' does not exist",
+			}.test(t)
+		})
+
+		it("returns an error without a build_image_file or run_image_file", func() {
+			OutTest{
+				InDir:       dir,
+				Objects:     []runtime.Object{stack},
+				Source:      source,
+				ExpectError: "either build_image_file or run_image_file is required",
+			}.test(t)
+		})
+	})
+}
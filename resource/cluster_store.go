@@ -0,0 +1,225 @@
+// Copyright 2020-Present VMware, Inc.
+// SPDX-License-Identifier: Apache-2.0
+
+package resource
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+
+	oc "github.com/cloudboss/ofcourse/ofcourse"
+	"github.com/pivotal/kpack/pkg/apis/build/v1alpha2"
+	corev1alpha1 "github.com/pivotal/kpack/pkg/apis/core/v1alpha1"
+	"github.com/pkg/errors"
+	k8serrors "k8s.io/apimachinery/pkg/api/errors"
+)
+
+const buildpacksFile = "buildpacks.json"
+
+const (
+	buildpackagesAppend  = "append"
+	buildpackagesReplace = "replace"
+	buildpackagesRemove  = "remove"
+)
+
+func getClusterStore(ctx context.Context, kpackClient KpackClient, src Source) (*v1alpha2.ClusterStore, error) {
+	store, err := kpackClient.GetClusterStore(ctx, src.Name)
+	if k8serrors.IsNotFound(err) {
+		return nil, errors.Errorf("clusterstore '%s' does not exist", src.Name)
+	}
+	return store, err
+}
+
+// clusterStoreVersion is a digest of the resolved buildpacks so that any
+// change to the list produces a new version.
+func clusterStoreVersion(store *v1alpha2.ClusterStore) (oc.Version, error) {
+	buildpacks, err := json.Marshal(store.Status.Buildpacks)
+	if err != nil {
+		return nil, err
+	}
+
+	return oc.Version{
+		"buildpacks": fmt.Sprintf("sha256:%x", sha256.Sum256(buildpacks)),
+	}, nil
+}
+
+func checkClusterStore(ctx context.Context, kpackClient KpackClient, src Source, version oc.Version) ([]oc.Version, error) {
+	store, err := getClusterStore(ctx, kpackClient, src)
+	if err != nil {
+		return nil, err
+	}
+
+	if !store.Status.GetCondition(corev1alpha1.ConditionReady).IsTrue() {
+		return nil, nil
+	}
+
+	latest, err := clusterStoreVersion(store)
+	if err != nil {
+		return nil, err
+	}
+
+	if latest["buildpacks"] == version["buildpacks"] {
+		return nil, nil
+	}
+	return []oc.Version{latest}, nil
+}
+
+func inClusterStore(ctx context.Context, kpackClient KpackClient, outDir string, src Source, version oc.Version) (oc.Version, oc.Metadata, error) {
+	store, err := getClusterStore(ctx, kpackClient, src)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	current, err := clusterStoreVersion(store)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if current["buildpacks"] != version["buildpacks"] {
+		return nil, nil, errors.Errorf("clusterstore '%s' no longer resolves to buildpacks '%s'", src.Name, version["buildpacks"])
+	}
+
+	buildpacks, err := json.MarshalIndent(store.Status.Buildpacks, "", "  ")
+	if err != nil {
+		return nil, nil, err
+	}
+
+	err = ioutil.WriteFile(filepath.Join(outDir, buildpacksFile), buildpacks, 0644)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return version, buildpacksMetadata(store.Status.Buildpacks), nil
+}
+
+func (o *Out) outClusterStore(ctx context.Context, kpackClient KpackClient, inDir string, src Source, params OutParams, log Logger) (oc.Version, oc.Metadata, error) {
+	if params.BuildpackagesFile == "" {
+		return nil, nil, errors.Errorf("buildpackages_file is required")
+	}
+
+	action := strings.ToLower(params.BuildpackagesAction)
+	if action == "" {
+		action = buildpackagesAppend
+	}
+	if action != buildpackagesAppend && action != buildpackagesReplace && action != buildpackagesRemove {
+		return nil, nil, errors.Errorf("unsupported buildpackages_action '%s'", params.BuildpackagesAction)
+	}
+
+	buildpackages, err := readBuildpackages(filepath.Join(inDir, params.BuildpackagesFile))
+	if err != nil {
+		return nil, nil, errors.Wrapf(err, "reading buildpackages: %s", params.BuildpackagesFile)
+	}
+
+	store, err := getClusterStore(ctx, kpackClient, src)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	log.Infof("Updating clusterstore '%s'.\n", store.Name)
+
+	sources := updateStoreSources(store.Spec.Sources, buildpackages, action)
+	log.Infof("Previous sources: %s\nNew sources: %s\n\n", red(storeSourcesString(store.Spec.Sources)), green(storeSourcesString(sources)))
+	store.Spec.Sources = sources
+
+	store, err = kpackClient.UpdateClusterStore(ctx, store)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	log.Infof(purple("Waiting on kpack to process update...\n\n"))
+	err = pollUntilReady(ctx, KindClusterStore, store.Name, store.Generation, func(ctx context.Context) (corev1alpha1.Status, error) {
+		store, err = getClusterStore(ctx, kpackClient, src)
+		if err != nil {
+			return corev1alpha1.Status{}, err
+		}
+		return store.Status.Status, nil
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+
+	version, err := clusterStoreVersion(store)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return version, buildpacksMetadata(store.Status.Buildpacks), nil
+}
+
+// readBuildpackages reads one buildpackage reference per line, ignoring
+// blank lines and comments.
+func readBuildpackages(path string) ([]string, error) {
+	contents, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var refs []string
+	for _, line := range strings.Split(string(contents), "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		refs = append(refs, line)
+	}
+	return refs, nil
+}
+
+// updateStoreSources applies action to sources. Replace matches refs by
+// repository, and remove drops every version of a ref given without a tag
+// or digest.
+func updateStoreSources(sources []corev1alpha1.StoreImage, refs []string, action string) []corev1alpha1.StoreImage {
+	updated := append([]corev1alpha1.StoreImage{}, sources...)
+
+	for _, ref := range refs {
+		if action == buildpackagesRemove {
+			kept := updated[:0]
+			for _, source := range updated {
+				if source.Image != ref && (ref != imageRepository(ref) || imageRepository(source.Image) != ref) {
+					kept = append(kept, source)
+				}
+			}
+			updated = kept
+			continue
+		}
+
+		index := -1
+		for i, source := range updated {
+			if source.Image == ref || (action == buildpackagesReplace && imageRepository(source.Image) == imageRepository(ref)) {
+				index = i
+				break
+			}
+		}
+
+		if index < 0 {
+			updated = append(updated, corev1alpha1.StoreImage{Image: ref})
+		} else {
+			updated[index].Image = ref
+		}
+	}
+	return updated
+}
+
+func storeSourcesString(sources []corev1alpha1.StoreImage) string {
+	images := make([]string, 0, len(sources))
+	for _, source := range sources {
+		images = append(images, source.Image)
+	}
+	return strings.Join(images, ", ")
+}
+
+func buildpacksMetadata(buildpacks []corev1alpha1.StoreBuildpack) oc.Metadata {
+	ids := make([]string, 0, len(buildpacks))
+	for _, buildpack := range buildpacks {
+		ids = append(ids, buildpack.Id+"@"+buildpack.Version)
+	}
+
+	return oc.Metadata{
+		{Name: "buildpacks", Value: strings.Join(ids, ", ")},
+	}
+}
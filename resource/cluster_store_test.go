@@ -0,0 +1,260 @@
+// Copyright 2020-Present VMware, Inc.
+// SPDX-License-Identifier: Apache-2.0
+
+package resource_test
+
+import (
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	oc "github.com/cloudboss/ofcourse/ofcourse"
+	"github.com/pivotal/kpack/pkg/apis/build/v1alpha2"
+	corev1alpha1 "github.com/pivotal/kpack/pkg/apis/core/v1alpha1"
+	"github.com/sclevine/spec"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	corev1 "k8s.io/api/core/v1"
+	v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	clientgotesting "k8s.io/client-go/testing"
+
+	"github.com/pivotal/concourse-kpack-resource/resource"
+)
+
+func TestClusterStore(t *testing.T) {
+	spec.Run(t, "TestClusterStore", testClusterStore)
+}
+
+func testClusterStore(t *testing.T, when spec.G, it spec.S) {
+	var (
+		dir     string
+		store   *v1alpha2.ClusterStore
+		version oc.Version
+	)
+
+	it.Before(func() {
+		var err error
+		dir, err = ioutil.TempDir("", "cluster_store_test")
+		require.NoError(t, err)
+
+		store = &v1alpha2.ClusterStore{
+			ObjectMeta: v1.ObjectMeta{
+				Name: "test-store",
+			},
+			Spec: v1alpha2.ClusterStoreSpec{
+				Sources: []corev1alpha1.StoreImage{
+					{Image: "gcr.io/paketo-buildpacks/java:8.0.0"},
+					{Image: "gcr.io/paketo-buildpacks/nodejs:1.0.0"},
+				},
+			},
+			Status: v1alpha2.ClusterStoreStatus{
+				Status: corev1alpha1.Status{
+					Conditions: corev1alpha1.Conditions{
+						{Type: corev1alpha1.ConditionReady, Status: corev1.ConditionTrue},
+					},
+				},
+				Buildpacks: []corev1alpha1.StoreBuildpack{
+					{BuildpackInfo: corev1alpha1.BuildpackInfo{Id: "paketo-buildpacks/java", Version: "8.0.0"}},
+					{BuildpackInfo: corev1alpha1.BuildpackInfo{Id: "paketo-buildpacks/nodejs", Version: "1.0.0"}},
+				},
+			},
+		}
+
+		buildpacks, err := json.Marshal(store.Status.Buildpacks)
+		require.NoError(t, err)
+		version = oc.Version{"buildpacks": fmt.Sprintf("sha256:%x", sha256.Sum256(buildpacks))}
+	})
+
+	it.After(func() {
+		os.RemoveAll(dir)
+	})
+
+	source := resource.Source{
+		Kind: "clusterstore",
+		Name: "test-store",
+	}
+
+	when("checking", func() {
+		it("emits a digest of the resolved buildpacks", func() {
+			CheckTest{
+				Objects:         []runtime.Object{store},
+				Source:          source,
+				ExpectedVersion: []oc.Version{version},
+			}.test(t)
+		})
+
+		it("does not emit already checked buildpacks", func() {
+			CheckTest{
+				Objects: []runtime.Object{store},
+				Source:  source,
+				Version: version,
+			}.test(t)
+		})
+
+		it("emits a new version when the buildpacks change", func() {
+			previous := version
+			store.Status.Buildpacks[0].Version = "8.1.0"
+
+			buildpacks, err := json.Marshal(store.Status.Buildpacks)
+			require.NoError(t, err)
+
+			CheckTest{
+				Objects: []runtime.Object{store},
+				Source:  source,
+				Version: previous,
+				ExpectedVersion: []oc.Version{
+					{"buildpacks": fmt.Sprintf("sha256:%x", sha256.Sum256(buildpacks))},
+				},
+			}.test(t)
+		})
+	})
+
+	it("writes the resolved buildpacks", func() {
+		InTest{
+			Objects:         []runtime.Object{store},
+			OutDir:          dir,
+			Source:          source,
+			Version:         version,
+			ExpectedVersion: version,
+			ExpectedMetadata: oc.Metadata{
+				{Name: "buildpacks", Value: "paketo-buildpacks/java@8.0.0, paketo-buildpacks/nodejs@1.0.0"},
+			},
+		}.test(t)
+
+		assertFileContents(t, filepath.Join(dir, "buildpacks.json"), `[
+  {
+    "id": "paketo-buildpacks/java",
+    "version": "8.0.0",
+    "buildpackage": {},
+    "storeImage": {}
+  },
+  {
+    "id": "paketo-buildpacks/nodejs",
+    "version": "1.0.0",
+    "buildpackage": {},
+    "storeImage": {}
+  }
+]`)
+	})
+
+	it("returns an error when the store no longer resolves to the version", func() {
+		InTest{
+			Objects:     []runtime.Object{store},
+			OutDir:      dir,
+			Source:      source,
+			Version:     oc.Version{"buildpacks": "sha256:previous"},
+			ExpectError: "clusterstore 'test-store' no longer resolves to buildpacks 'sha256:previous'",
+		}.test(t)
+
+		assert.NoFileExists(t, filepath.Join(dir, "buildpacks.json"))
+	})
+
+	when("putting", func() {
+		for _, tc := range []struct {
+			action        string
+			buildpackages string
+			sources       []string
+		}{
+			{
+				action:        "",
+				buildpackages: "gcr.io/paketo-buildpacks/java:8.1.0\n# comment\n\ngcr.io/paketo-buildpacks/go:1.0.0\n",
+				sources:       []string{"gcr.io/paketo-buildpacks/java:8.0.0", "gcr.io/paketo-buildpacks/nodejs:1.0.0", "gcr.io/paketo-buildpacks/java:8.1.0", "gcr.io/paketo-buildpacks/go:1.0.0"},
+			},
+			{
+				action:        "replace",
+				buildpackages: "gcr.io/paketo-buildpacks/java@sha256:abc\ngcr.io/paketo-buildpacks/go:1.0.0",
+				sources:       []string{"gcr.io/paketo-buildpacks/java@sha256:abc", "gcr.io/paketo-buildpacks/nodejs:1.0.0", "gcr.io/paketo-buildpacks/go:1.0.0"},
+			},
+			{
+				action:        "remove",
+				buildpackages: "gcr.io/paketo-buildpacks/java\ngcr.io/paketo-buildpacks/nodejs:2.0.0",
+				sources:       []string{"gcr.io/paketo-buildpacks/nodejs:1.0.0"},
+			},
+		} {
+			tc := tc
+			it(fmt.Sprintf("updates the sources with action '%s'", tc.action), func() {
+				err := ioutil.WriteFile(filepath.Join(dir, "buildpackages"), []byte(tc.buildpackages), 0644)
+				require.NoError(t, err)
+
+				updatedStore := store.DeepCopy()
+				updatedStore.Spec.Sources = nil
+				for _, image := range tc.sources {
+					updatedStore.Spec.Sources = append(updatedStore.Spec.Sources, corev1alpha1.StoreImage{Image: image})
+				}
+
+				OutTest{
+					InDir:   dir,
+					Objects: []runtime.Object{store},
+					Source:  source,
+					Parameters: resource.OutParams{
+						BuildpackagesFile:   "buildpackages",
+						BuildpackagesAction: tc.action,
+					},
+					ExpectedOutput: []string{
+						"Updating clusterstore 'test-store'",
+						"Previous sources", "gcr.io/paketo-buildpacks/java:8.0.0",
+						"New sources", tc.sources[0],
+					},
+					ExpectUpdates: []clientgotesting.UpdateActionImpl{
+						{Object: updatedStore},
+					},
+					ExpectedVersion: version,
+					ExpectedMetadata: oc.Metadata{
+						{Name: "buildpacks", Value: "paketo-buildpacks/java@8.0.0, paketo-buildpacks/nodejs@1.0.0"},
+					},
+				}.test(t)
+			})
+		}
+
+		it("returns an error when the store fails", func() {
+			store.Status.Conditions[0].Status = corev1.ConditionFalse
+			store.Status.Conditions[0].Message = "some failure"
+
+			err := ioutil.WriteFile(filepath.Join(dir, "buildpackages"), []byte("gcr.io/paketo-buildpacks/go:1.0.0"), 0644)
+			require.NoError(t, err)
+
+			updatedStore := store.DeepCopy()
+			updatedStore.Spec.Sources = append(updatedStore.Spec.Sources, corev1alpha1.StoreImage{Image: "gcr.io/paketo-buildpacks/go:1.0.0"})
+
+			OutTest{
+				InDir:   dir,
+				Objects: []runtime.Object{store},
+				Source:  source,
+				Parameters: resource.OutParams{
+					BuildpackagesFile: "buildpackages",
+				},
+				ExpectUpdates: []clientgotesting.UpdateActionImpl{
+					{Object: updatedStore},
+				},
+				ExpectError: "update to clusterstore test-store failed: some failure",
+			}.test(t)
+		})
+
+		it("returns an error for an unsupported action", func() {
+			OutTest{
+				InDir:   dir,
+				Objects: []runtime.Object{store},
+				Source:  source,
+				Parameters: resource.OutParams{
+					BuildpackagesFile:   "buildpackages",
+					BuildpackagesAction: "upsert",
+				},
+				ExpectError: "unsupported buildpackages_action 'upsert'",
+			}.test(t)
+		})
+
+		it("returns an error without a buildpackages_file", func() {
+			OutTest{
+				InDir:       dir,
+				Objects:     []runtime.Object{store},
+				Source:      source,
+				ExpectError: "buildpackages_file is required",
+			}.test(t)
+		})
+	})
+}
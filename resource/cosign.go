@@ -0,0 +1,264 @@
+// Copyright 2020-Present VMware, Inc.
+// SPDX-License-Identifier: Apache-2.0
+
+package resource
+
+import (
+	"bytes"
+	"context"
+	"crypto"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/pem"
+	"fmt"
+	"strconv"
+	"strings"
+
+	oc "github.com/cloudboss/ofcourse/ofcourse"
+	"github.com/google/go-containerregistry/pkg/name"
+	"github.com/google/go-containerregistry/pkg/v1/remote"
+	"github.com/pkg/errors"
+	"github.com/sigstore/cosign/v2/cmd/cosign/cli/fulcio"
+	"github.com/sigstore/cosign/v2/cmd/cosign/cli/options"
+	"github.com/sigstore/cosign/v2/cmd/cosign/cli/rekor"
+	"github.com/sigstore/cosign/v2/cmd/cosign/cli/sign"
+	"github.com/sigstore/cosign/v2/pkg/cosign"
+	cbundle "github.com/sigstore/cosign/v2/pkg/cosign/bundle"
+	"github.com/sigstore/cosign/v2/pkg/oci"
+	"github.com/sigstore/cosign/v2/pkg/oci/mutate"
+	ociremote "github.com/sigstore/cosign/v2/pkg/oci/remote"
+	"github.com/sigstore/cosign/v2/pkg/oci/static"
+	sigs "github.com/sigstore/cosign/v2/pkg/signature"
+	"github.com/sigstore/sigstore/pkg/cryptoutils"
+	"github.com/sigstore/sigstore/pkg/signature"
+	signatureoptions "github.com/sigstore/sigstore/pkg/signature/options"
+	"github.com/sigstore/sigstore/pkg/signature/payload"
+)
+
+// cosignTag returns the tag cosign attaches an artifact of kind suffix to
+// for digest, such as sha256-<hex>.sig.
+func cosignTag(digest, suffix string) string {
+	return fmt.Sprintf("%s.%s", strings.Replace(digest, ":", "-", 1), suffix)
+}
+
+// sign signs the image with params and pushes the signature to the
+// repository of the image the way cosign sign does.
+func (o *Out) sign(ctx context.Context, namespace, image string, params SignParams, log Logger) (oc.Metadata, error) {
+	if (params.Key == "") == (params.Keyless == nil) {
+		return nil, errors.Errorf("sign requires exactly one of key or keyless")
+	}
+
+	digest, err := name.NewDigest(image)
+	if err != nil {
+		return nil, errors.Wrapf(err, "parsing image: %s", image)
+	}
+
+	keychain, err := registryKeychain(ctx, o.KubeClient, namespace, digest.RegistryStr(), params.RegistryCredentials)
+	if err != nil {
+		return nil, err
+	}
+	opts := ociremote.WithRemoteOptions(remote.WithContext(ctx), remote.WithAuthFromKeychain(keychain))
+
+	signer, err := newSigner(ctx, params)
+	if err != nil {
+		return nil, err
+	}
+
+	signed, err := (&payload.Cosign{Image: digest}).MarshalJSON()
+	if err != nil {
+		return nil, err
+	}
+
+	sig, err := signer.SignMessage(bytes.NewReader(signed), signatureoptions.WithContext(ctx))
+	if err != nil {
+		return nil, errors.Wrap(err, "signing payload")
+	}
+
+	var (
+		sigOpts  []static.Option
+		metadata oc.Metadata
+	)
+	if signer.Cert != nil {
+		sigOpts = append(sigOpts, static.WithCertChain(signer.Cert, signer.Chain))
+	}
+
+	if rekorURL := params.rekorURL(); rekorURL != "" {
+		bundle, err := uploadToRekor(ctx, rekorURL, signer, sig, signed)
+		if err != nil {
+			return nil, err
+		}
+		sigOpts = append(sigOpts, static.WithBundle(bundle))
+		metadata = oc.Metadata{{Name: "rekorLogIndex", Value: strconv.FormatInt(bundle.Payload.LogIndex, 10)}}
+	}
+
+	ociSig, err := static.NewSignature(signed, base64.StdEncoding.EncodeToString(sig), sigOpts...)
+	if err != nil {
+		return nil, err
+	}
+
+	signatureTag := digest.Context().Tag(cosignTag(digest.DigestStr(), "sig"))
+	err = attach(digest, opts, func(entity oci.SignedEntity) (oci.SignedEntity, error) {
+		return mutate.AttachSignatureToEntity(entity, ociSig)
+	}, ociremote.WriteSignatures)
+	if err != nil {
+		return nil, errors.Wrapf(err, "pushing signature %s", signatureTag)
+	}
+
+	log.Infof("Signed %s.\n", green(digest))
+
+	return append(oc.Metadata{{Name: "signature", Value: signatureTag.String()}}, metadata...), nil
+}
+
+// newSigner loads the signing key, or generates an ephemeral key and gets a
+// certificate for it from Fulcio, verifying the certificate's SCT.
+func newSigner(ctx context.Context, params SignParams) (*sign.SignerVerifier, error) {
+	if params.Keyless != nil {
+		signer, err := sign.SignerFromKeyOpts(ctx, "", "", options.KeyOpts{
+			FulcioURL:        params.Keyless.FulcioURL,
+			IDToken:          params.Keyless.IdentityToken,
+			SkipConfirmation: true,
+		})
+		return signer, errors.Wrap(err, "getting certificate")
+	}
+
+	key, err := loadSigner([]byte(params.Key), []byte(params.KeyPassword))
+	if err != nil {
+		return nil, err
+	}
+	return &sign.SignerVerifier{SignerVerifier: key}, nil
+}
+
+// loadSigner loads a key encrypted by cosign generate-key-pair, or an
+// unencrypted PEM encoded private key.
+func loadSigner(key, password []byte) (signature.SignerVerifier, error) {
+	block, _ := pem.Decode(key)
+	if block == nil {
+		return nil, errors.Errorf("key is not PEM encoded")
+	}
+
+	if strings.HasPrefix(block.Type, "ENCRYPTED") {
+		signer, err := cosign.LoadPrivateKey(key, password)
+		if err != nil {
+			return nil, errors.Wrap(err, "loading key")
+		}
+		return signer, nil
+	}
+
+	privateKey, err := cryptoutils.UnmarshalPEMToPrivateKey(key, cryptoutils.SkipPassword)
+	if err != nil {
+		return nil, errors.Wrap(err, "loading key")
+	}
+	return signature.LoadSignerVerifier(privateKey, crypto.SHA256)
+}
+
+// uploadToRekor records the signature of payload in the transparency log
+// and returns the bundle cosign attaches to the signature.
+func uploadToRekor(ctx context.Context, rekorURL string, signer *sign.SignerVerifier, sig, signed []byte) (*cbundle.RekorBundle, error) {
+	client, err := rekor.NewClient(rekorURL)
+	if err != nil {
+		return nil, errors.Wrap(err, "creating rekor client")
+	}
+
+	verifier := signer.Cert
+	if verifier == nil {
+		publicKey, err := signer.PublicKey()
+		if err != nil {
+			return nil, err
+		}
+		verifier, err = cryptoutils.MarshalPublicKeyToPEM(publicKey)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	checksum := sha256.New()
+	checksum.Write(signed)
+
+	entry, err := cosign.TLogUpload(ctx, client, sig, checksum, verifier)
+	if err != nil {
+		return nil, errors.Wrap(err, "uploading to rekor")
+	}
+	return cbundle.EntryToBundle(entry), nil
+}
+
+// attach adds a signature or attestation to the entity at digest and writes
+// it back to the registry.
+func attach(digest name.Digest, opts ociremote.Option, add func(oci.SignedEntity) (oci.SignedEntity, error), write func(name.Repository, oci.SignedEntity, ...ociremote.Option) error) error {
+	entity, err := ociremote.SignedEntity(digest, opts)
+	if err != nil {
+		return err
+	}
+
+	entity, err = add(entity)
+	if err != nil {
+		return err
+	}
+
+	return write(digest.Repository, entity, opts)
+}
+
+// verifySignature succeeds if a signature attached to digest is valid for
+// params, including its certificate chain and transparency log bundle.
+func verifySignature(ctx context.Context, digest name.Digest, params VerifyParams, opts ...remote.Option) error {
+	checkOpts, err := params.checkOpts(ctx)
+	if err != nil {
+		return err
+	}
+	checkOpts.RegistryClientOpts = []ociremote.Option{ociremote.WithRemoteOptions(opts...)}
+
+	_, _, err = cosign.VerifyImageSignatures(ctx, digest, checkOpts)
+	var noSignatures *cosign.ErrNoSignaturesFound
+	if errors.As(err, &noSignatures) {
+		return errors.Errorf("no signature found for %s", digest)
+	}
+	return errors.Wrapf(err, "verifying signature of %s", digest)
+}
+
+// checkOpts are the cosign verify options for params. The transparency log
+// is only checked when rekor_url is set.
+func (p VerifyParams) checkOpts(ctx context.Context) (*cosign.CheckOpts, error) {
+	checkOpts := &cosign.CheckOpts{
+		ClaimVerifier: cosign.SimpleClaimVerifier,
+		IgnoreTlog:    p.RekorURL == "",
+	}
+
+	if p.PublicKey != "" {
+		verifier, err := sigs.LoadPublicKeyRaw([]byte(p.PublicKey), crypto.SHA256)
+		if err != nil {
+			return nil, errors.Wrap(err, "loading public_key")
+		}
+		checkOpts.SigVerifier = verifier
+	} else {
+		var err error
+		checkOpts.RootCerts, err = fulcio.GetRoots()
+		if err != nil {
+			return nil, errors.Wrap(err, "getting fulcio roots")
+		}
+		checkOpts.IntermediateCerts, err = fulcio.GetIntermediates()
+		if err != nil {
+			return nil, errors.Wrap(err, "getting fulcio intermediates")
+		}
+		checkOpts.CTLogPubKeys, err = cosign.GetCTLogPubs(ctx)
+		if err != nil {
+			return nil, errors.Wrap(err, "getting ct log public keys")
+		}
+		checkOpts.Identities = []cosign.Identity{{
+			Subject: p.CertificateIdentity,
+			Issuer:  p.CertificateOIDCIssuer,
+		}}
+	}
+
+	if p.RekorURL != "" {
+		var err error
+		checkOpts.RekorClient, err = rekor.NewClient(p.RekorURL)
+		if err != nil {
+			return nil, errors.Wrap(err, "creating rekor client")
+		}
+		checkOpts.RekorPubKeys, err = cosign.GetRekorPubs(ctx)
+		if err != nil {
+			return nil, errors.Wrap(err, "getting rekor public keys")
+		}
+	}
+
+	return checkOpts, nil
+}
@@ -0,0 +1,549 @@
+// Copyright 2020-Present VMware, Inc.
+// SPDX-License-Identifier: Apache-2.0
+
+package resource_test
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/pem"
+	"io/ioutil"
+	"log"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	oc "github.com/cloudboss/ofcourse/ofcourse"
+	"github.com/cyberphone/json-canonicalization/go/src/webpki.org/jsoncanonicalizer"
+	ct "github.com/google/certificate-transparency-go"
+	cttls "github.com/google/certificate-transparency-go/tls"
+	"github.com/google/certificate-transparency-go/x509util"
+	"github.com/google/go-containerregistry/pkg/name"
+	"github.com/google/go-containerregistry/pkg/registry"
+	"github.com/google/go-containerregistry/pkg/v1/random"
+	"github.com/google/go-containerregistry/pkg/v1/remote"
+	"github.com/pivotal/kpack/pkg/apis/build/v1alpha2"
+	corev1alpha1 "github.com/pivotal/kpack/pkg/apis/core/v1alpha1"
+	"github.com/sclevine/spec"
+	"github.com/secure-systems-lab/go-securesystemslib/encrypted"
+	cbundle "github.com/sigstore/cosign/v2/pkg/cosign/bundle"
+	"github.com/sigstore/sigstore/pkg/cryptoutils"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	clientgotesting "k8s.io/client-go/testing"
+
+	"github.com/pivotal/concourse-kpack-resource/resource"
+)
+
+const (
+	testIdentity = "ci@example.com"
+	testIssuer   = "https://issuer.example.com"
+)
+
+func TestCosign(t *testing.T) {
+	trust := newFakeSigstore(t)
+	spec.Run(t, "TestCosign", func(t *testing.T, when spec.G, it spec.S) {
+		testCosign(t, when, it, trust)
+	})
+}
+
+func testCosign(t *testing.T, when spec.G, it spec.S, trust *fakeSigstore) {
+	const namespace = "test-namespace"
+
+	var (
+		dir             string
+		server          *httptest.Server
+		fulcio          *httptest.Server
+		rekor           *httptest.Server
+		built           name.Digest
+		image           *v1alpha2.Image
+		keyPEM          string
+		encryptedKeyPEM string
+		publicKeyPEM    string
+		password        = "secret"
+	)
+
+	it.Before(func() {
+		var err error
+		dir, err = ioutil.TempDir("", "cosign_test")
+		require.NoError(t, err)
+
+		server = httptest.NewServer(registry.New(registry.Logger(log.New(ioutil.Discard, "", 0))))
+		host := strings.TrimPrefix(server.URL, "http://")
+
+		fulcio = httptest.NewServer(trust.fulcio(t))
+		rekor = httptest.NewServer(trust.rekor(t))
+
+		builtImage, err := random.Image(1024, 1)
+		require.NoError(t, err)
+		digest, err := builtImage.Digest()
+		require.NoError(t, err)
+
+		built, err = name.NewDigest(host + "/dev/app@" + digest.String())
+		require.NoError(t, err)
+		require.NoError(t, remote.Write(built, builtImage))
+
+		privateKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+		require.NoError(t, err)
+		der, err := x509.MarshalPKCS8PrivateKey(privateKey)
+		require.NoError(t, err)
+		keyPEM = string(pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der}))
+
+		// encrypted the way cosign generate-key-pair does
+		encryptedDER, err := encrypted.Encrypt(der, []byte(password))
+		require.NoError(t, err)
+		encryptedKeyPEM = string(pem.EncodeToMemory(&pem.Block{Type: "ENCRYPTED SIGSTORE PRIVATE KEY", Bytes: encryptedDER}))
+
+		publicKey, err := cryptoutils.MarshalPublicKeyToPEM(&privateKey.PublicKey)
+		require.NoError(t, err)
+		publicKeyPEM = string(publicKey)
+
+		err = ioutil.WriteFile(filepath.Join(dir, "commitish"), []byte("new-revision"), 0644)
+		require.NoError(t, err)
+
+		image = &v1alpha2.Image{
+			ObjectMeta: v1.ObjectMeta{
+				Name:      "app",
+				Namespace: namespace,
+			},
+			Spec: v1alpha2.ImageSpec{
+				Tag: host + "/dev/app",
+				Source: corev1alpha1.SourceConfig{
+					Git: &corev1alpha1.Git{
+						URL:      "https://some.git.com",
+						Revision: "old-revision",
+					},
+				},
+			},
+		}
+	})
+
+	it.After(func() {
+		server.Close()
+		fulcio.Close()
+		rekor.Close()
+		os.RemoveAll(dir)
+	})
+
+	signatureTag := func() name.Tag {
+		return built.Context().Tag(strings.Replace(built.DigestStr(), ":", "-", 1) + ".sig")
+	}
+
+	signatureAnnotations := func() map[string]string {
+		signatures, err := remote.Image(signatureTag())
+		require.NoError(t, err)
+		manifest, err := signatures.Manifest()
+		require.NoError(t, err)
+		require.Len(t, manifest.Layers, 1)
+		assert.Equal(t, "application/vnd.dev.cosign.simplesigning.v1+json", string(manifest.Layers[0].MediaType))
+		return manifest.Layers[0].Annotations
+	}
+
+	putSigned := func(sign *resource.SignParams, expectedMetadata oc.Metadata) {
+		updatedImage := image.DeepCopy()
+		updatedImage.Spec.Source.Git.Revision = "new-revision"
+
+		OutTest{
+			InDir:   dir,
+			Objects: []runtime.Object{image},
+			Source: resource.Source{
+				Image:     image.Name,
+				Namespace: namespace,
+			},
+			Parameters: resource.OutParams{
+				Commitish: "commitish",
+				Sign:      sign,
+			},
+			TerminalImage: built.String(),
+			ExpectUpdates: []clientgotesting.UpdateActionImpl{
+				{Object: updatedImage},
+			},
+			ExpectedImageToWaitOn: updatedImage,
+			ExpectedOutput: []string{
+				"Signed", built.String(),
+			},
+			ExpectedVersion: oc.Version{
+				"image":  built.String(),
+				"digest": built.DigestStr(),
+			},
+			ExpectedMetadata: expectedMetadata,
+		}.test(t)
+	}
+
+	getVerified := func(verify map[string]interface{}, expectError string) {
+		var expectedVersion oc.Version
+		if expectError == "" {
+			expectedVersion = oc.Version{"image": built.String()}
+		}
+
+		InTest{
+			OutDir: dir,
+			Source: resource.Source{
+				Image:     image.Name,
+				Namespace: namespace,
+			},
+			Parameters:      oc.Params{"verify": verify},
+			Version:         oc.Version{"image": built.String()},
+			ExpectedVersion: expectedVersion,
+			ExpectError:     expectError,
+		}.test(t)
+	}
+
+	keylessVerify := func(identity string) map[string]interface{} {
+		return map[string]interface{}{
+			"certificate_identity":    identity,
+			"certificate_oidc_issuer": testIssuer,
+			"rekor_url":               rekor.URL,
+		}
+	}
+
+	when("signing with a key", func() {
+		it("signs the built image with an encrypted cosign key and verifies it on get", func() {
+			putSigned(&resource.SignParams{
+				Key:         encryptedKeyPEM,
+				KeyPassword: password,
+			}, oc.Metadata{
+				{Name: "signature", Value: signatureTag().String()},
+			})
+
+			annotations := signatureAnnotations()
+			assert.NotEmpty(t, annotations["dev.cosignproject.cosign/signature"])
+			assert.NotContains(t, annotations, "dev.sigstore.cosign/bundle")
+
+			getVerified(map[string]interface{}{"public_key": publicKeyPEM}, "")
+		})
+
+		it("signs with an unencrypted PKCS8 key", func() {
+			putSigned(&resource.SignParams{
+				Key: keyPEM,
+			}, oc.Metadata{
+				{Name: "signature", Value: signatureTag().String()},
+			})
+
+			getVerified(map[string]interface{}{"public_key": publicKeyPEM}, "")
+		})
+
+		it("records the signature in rekor and verifies its bundle on get", func() {
+			putSigned(&resource.SignParams{
+				Key:      keyPEM,
+				RekorURL: rekor.URL,
+			}, oc.Metadata{
+				{Name: "signature", Value: signatureTag().String()},
+				{Name: "rekorLogIndex", Value: "42"},
+			})
+
+			assert.Contains(t, signatureAnnotations()["dev.sigstore.cosign/bundle"], `"logIndex":42`)
+
+			getVerified(map[string]interface{}{
+				"public_key": publicKeyPEM,
+				"rekor_url":  rekor.URL,
+			}, "")
+		})
+
+		it("returns an error for an incorrect key_password", func() {
+			updatedImage := image.DeepCopy()
+			updatedImage.Spec.Source.Git.Revision = "new-revision"
+
+			OutTest{
+				InDir:   dir,
+				Objects: []runtime.Object{image},
+				Source: resource.Source{
+					Image:     image.Name,
+					Namespace: namespace,
+				},
+				Parameters: resource.OutParams{
+					Commitish: "commitish",
+					Sign: &resource.SignParams{
+						Key:         encryptedKeyPEM,
+						KeyPassword: "wrong",
+					},
+				},
+				TerminalImage: built.String(),
+				ExpectUpdates: []clientgotesting.UpdateActionImpl{
+					{Object: updatedImage},
+				},
+				ExpectedImageToWaitOn: updatedImage,
+				ExpectError:           "signing " + built.String() + ": loading key: decrypt: encrypted: decryption failed",
+			}.test(t)
+		})
+	})
+
+	when("signing keyless", func() {
+		var keyless *resource.SignParams
+
+		it.Before(func() {
+			keyless = &resource.SignParams{
+				Keyless: &resource.KeylessParams{
+					FulcioURL:     fulcio.URL,
+					RekorURL:      rekor.URL,
+					IdentityToken: identityToken(t, testIdentity),
+				},
+			}
+		})
+
+		it("signs with a fulcio certificate, records the signature in rekor and verifies it on get", func() {
+			putSigned(keyless, oc.Metadata{
+				{Name: "signature", Value: signatureTag().String()},
+				{Name: "rekorLogIndex", Value: "42"},
+			})
+
+			annotations := signatureAnnotations()
+			assert.Contains(t, annotations["dev.sigstore.cosign/certificate"], "BEGIN CERTIFICATE")
+			assert.Contains(t, annotations["dev.sigstore.cosign/chain"], "BEGIN CERTIFICATE")
+			assert.Contains(t, annotations["dev.sigstore.cosign/bundle"], `"logIndex":42`)
+
+			getVerified(keylessVerify(testIdentity), "")
+		})
+
+		it("returns an error when the certificate is for another identity", func() {
+			putSigned(keyless, oc.Metadata{
+				{Name: "signature", Value: signatureTag().String()},
+				{Name: "rekorLogIndex", Value: "42"},
+			})
+
+			getVerified(keylessVerify("someone@example.com"), "no matching signatures")
+		})
+	})
+
+	when("verifying", func() {
+		it("returns an error when the image is not signed", func() {
+			getVerified(map[string]interface{}{"public_key": publicKeyPEM}, "no signature found for "+built.String())
+		})
+
+		it("returns an error when no signature is valid for the public key", func() {
+			putSigned(&resource.SignParams{
+				Key: keyPEM,
+			}, oc.Metadata{
+				{Name: "signature", Value: signatureTag().String()},
+			})
+
+			otherKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+			require.NoError(t, err)
+			otherPublicKey, err := cryptoutils.MarshalPublicKeyToPEM(&otherKey.PublicKey)
+			require.NoError(t, err)
+
+			getVerified(map[string]interface{}{"public_key": string(otherPublicKey)}, "no matching signatures")
+		})
+
+		it("returns an error when the signature is not in rekor", func() {
+			putSigned(&resource.SignParams{
+				Key: keyPEM,
+			}, oc.Metadata{
+				{Name: "signature", Value: signatureTag().String()},
+			})
+
+			getVerified(map[string]interface{}{
+				"public_key": publicKeyPEM,
+				"rekor_url":  rekor.URL,
+			}, "no matching signatures")
+		})
+	})
+}
+
+// fakeSigstore is a Fulcio CA, CT log and Rekor trusted through the
+// environment variables cosign reads in place of the sigstore TUF root.
+type fakeSigstore struct {
+	caKey    *ecdsa.PrivateKey
+	ca       *x509.Certificate
+	ctKey    *ecdsa.PrivateKey
+	rekorKey *ecdsa.PrivateKey
+}
+
+func newFakeSigstore(t *testing.T) *fakeSigstore {
+	dir := t.TempDir()
+	s := &fakeSigstore{}
+
+	var err error
+	s.caKey, err = ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "test-fulcio"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+		KeyUsage:              x509.KeyUsageCertSign,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &s.caKey.PublicKey, s.caKey)
+	require.NoError(t, err)
+	s.ca, err = x509.ParseCertificate(der)
+	require.NoError(t, err)
+
+	s.ctKey, err = ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	s.rekorKey, err = ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+
+	write := func(file string, contents []byte) string {
+		path := filepath.Join(dir, file)
+		require.NoError(t, ioutil.WriteFile(path, contents, 0644))
+		return path
+	}
+	publicKeyPEM := func(key *ecdsa.PrivateKey) []byte {
+		contents, err := cryptoutils.MarshalPublicKeyToPEM(&key.PublicKey)
+		require.NoError(t, err)
+		return contents
+	}
+
+	t.Setenv("SIGSTORE_ROOT_FILE", write("fulcio.pem", pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})))
+	t.Setenv("SIGSTORE_CT_LOG_PUBLIC_KEY_FILE", write("ctlog.pub", publicKeyPEM(s.ctKey)))
+	t.Setenv("SIGSTORE_REKOR_PUBLIC_KEY", write("rekor.pub", publicKeyPEM(s.rekorKey)))
+
+	return s
+}
+
+// fulcio issues a certificate for testIdentity with an embedded SCT.
+func (s *fakeSigstore) fulcio(t *testing.T) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		require.Equal(t, "/api/v1/signingCert", r.URL.Path)
+
+		var request struct {
+			PublicKey struct {
+				Content []byte `json:"content"`
+			} `json:"publicKey"`
+		}
+		require.NoError(t, json.NewDecoder(r.Body).Decode(&request))
+		publicKey, err := cryptoutils.UnmarshalPEMToPublicKey(request.PublicKey.Content)
+		require.NoError(t, err)
+
+		template := &x509.Certificate{
+			SerialNumber:   big.NewInt(2),
+			NotBefore:      time.Now().Add(-time.Minute),
+			NotAfter:       time.Now().Add(10 * time.Minute),
+			EmailAddresses: []string{testIdentity},
+			KeyUsage:       x509.KeyUsageDigitalSignature,
+			ExtKeyUsage:    []x509.ExtKeyUsage{x509.ExtKeyUsageCodeSigning},
+			ExtraExtensions: []pkix.Extension{
+				{Id: asn1.ObjectIdentifier{1, 3, 6, 1, 4, 1, 57264, 1, 1}, Value: []byte(testIssuer)},
+			},
+		}
+		precert, err := x509.CreateCertificate(rand.Reader, template, s.ca, publicKey, s.caKey)
+		require.NoError(t, err)
+		parsed, err := x509.ParseCertificate(precert)
+		require.NoError(t, err)
+
+		template.ExtraExtensions = append(template.ExtraExtensions, pkix.Extension{
+			Id:    asn1.ObjectIdentifier{1, 3, 6, 1, 4, 1, 11129, 2, 4, 2},
+			Value: s.sctList(t, parsed.RawTBSCertificate),
+		})
+		leaf, err := x509.CreateCertificate(rand.Reader, template, s.ca, publicKey, s.caKey)
+		require.NoError(t, err)
+
+		w.WriteHeader(http.StatusCreated)
+		w.Write(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: leaf}))
+		w.Write(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: s.ca.Raw}))
+	})
+}
+
+// sctList is the SCT list extension of a certificate with tbs, signed by
+// the CT log.
+func (s *fakeSigstore) sctList(t *testing.T, tbs []byte) []byte {
+	publicKeyDER, err := x509.MarshalPKIXPublicKey(&s.ctKey.PublicKey)
+	require.NoError(t, err)
+
+	sct := ct.SignedCertificateTimestamp{
+		SCTVersion: ct.V1,
+		LogID:      ct.LogID{KeyID: sha256.Sum256(publicKeyDER)},
+		Timestamp:  uint64(time.Now().UnixMilli()),
+	}
+	input, err := ct.SerializeSCTSignatureInput(sct, ct.LogEntry{Leaf: ct.MerkleTreeLeaf{
+		TimestampedEntry: &ct.TimestampedEntry{
+			EntryType: ct.PrecertLogEntryType,
+			PrecertEntry: &ct.PreCert{
+				IssuerKeyHash:  sha256.Sum256(s.ca.RawSubjectPublicKeyInfo),
+				TBSCertificate: tbs,
+			},
+		},
+	}})
+	require.NoError(t, err)
+
+	hash := sha256.Sum256(input)
+	signature, err := ecdsa.SignASN1(rand.Reader, s.ctKey, hash[:])
+	require.NoError(t, err)
+	sct.Signature = ct.DigitallySigned{
+		Algorithm: cttls.SignatureAndHashAlgorithm{Hash: cttls.SHA256, Signature: cttls.ECDSA},
+		Signature: signature,
+	}
+
+	list, err := x509util.MarshalSCTsIntoSCTList([]*ct.SignedCertificateTimestamp{&sct})
+	require.NoError(t, err)
+	serialized, err := cttls.Marshal(*list)
+	require.NoError(t, err)
+	value, err := asn1.Marshal(serialized)
+	require.NoError(t, err)
+	return value
+}
+
+// rekor records every proposed entry at log index 42 and finds nothing.
+func (s *fakeSigstore) rekor(t *testing.T) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/api/v1/log/entries" {
+			http.NotFound(w, r)
+			return
+		}
+
+		body, err := ioutil.ReadAll(r.Body)
+		require.NoError(t, err)
+
+		publicKeyDER, err := x509.MarshalPKIXPublicKey(&s.rekorKey.PublicKey)
+		require.NoError(t, err)
+		logID := sha256.Sum256(publicKeyDER)
+
+		payload := cbundle.RekorPayload{
+			Body:           base64.StdEncoding.EncodeToString(body),
+			IntegratedTime: time.Now().Unix(),
+			LogIndex:       42,
+			LogID:          hex.EncodeToString(logID[:]),
+		}
+		contents, err := json.Marshal(payload)
+		require.NoError(t, err)
+		canonical, err := jsoncanonicalizer.Transform(contents)
+		require.NoError(t, err)
+		hash := sha256.Sum256(canonical)
+		set, err := ecdsa.SignASN1(rand.Reader, s.rekorKey, hash[:])
+		require.NoError(t, err)
+
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusCreated)
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"some-uuid": map[string]interface{}{
+				"body":           payload.Body,
+				"integratedTime": payload.IntegratedTime,
+				"logID":          payload.LogID,
+				"logIndex":       payload.LogIndex,
+				"verification": map[string]interface{}{
+					"signedEntryTimestamp": set,
+				},
+			},
+		})
+	})
+}
+
+// identityToken is an unverified OIDC token for subject, as Fulcio would
+// accept from the issuer.
+func identityToken(t *testing.T, subject string) string {
+	claims, err := json.Marshal(map[string]string{"sub": subject, "iss": testIssuer})
+	require.NoError(t, err)
+
+	return strings.Join([]string{
+		base64.RawURLEncoding.EncodeToString([]byte(`{"alg":"ES256"}`)),
+		base64.RawURLEncoding.EncodeToString(claims),
+		base64.RawURLEncoding.EncodeToString(make([]byte, 64)),
+	}, ".")
+}
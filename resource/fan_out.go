@@ -0,0 +1,212 @@
+// Copyright 2020-Present VMware, Inc.
+// SPDX-License-Identifier: Apache-2.0
+
+package resource
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	oc "github.com/cloudboss/ofcourse/ofcourse"
+	"github.com/pkg/errors"
+)
+
+const defaultParallelism = 4
+
+// imageTargets returns the images a put should update when it updates more
+// than one. The params take precedence over a multi-image source.
+func imageTargets(src Source, params OutParams) ([]string, string) {
+	if len(params.Images) > 0 || params.LabelSelector != "" {
+		return params.Images, params.LabelSelector
+	}
+	return src.Images, src.LabelSelector
+}
+
+// outImages updates every image tracked by src concurrently and returns a
+// version with the resulting image of each, keyed by image/<name>. The result
+// of every image is logged, also when some of them failed.
+func (o *Out) outImages(ctx context.Context, kpackClient KpackClient, inDir string, src Source, params OutParams, env oc.Environment, log Logger) (oc.Version, oc.Metadata, error) {
+	names, err := trackedImages(ctx, kpackClient, src)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if len(names) == 0 {
+		return nil, nil, errors.Errorf("no images in namespace '%s' match label selector '%s'", src.Namespace, src.LabelSelector)
+	}
+
+	parallelism := params.Parallelism
+	if parallelism <= 0 {
+		parallelism = defaultParallelism
+	}
+
+	var (
+		mu        sync.Mutex
+		wg        sync.WaitGroup
+		semaphore = make(chan struct{}, parallelism)
+		results   = make([]string, len(names))
+		metadatas = make([]oc.Metadata, len(names))
+		errs      = make([]error, len(names))
+	)
+
+	for i, name := range names {
+		wg.Add(1)
+		semaphore <- struct{}{}
+		go func(i int, name string) {
+			defer wg.Done()
+			defer func() { <-semaphore }()
+
+			label := fmt.Sprintf("[%s]", name)
+			writer := &labeledWriter{mu: &mu, writer: o.logWriter(), label: label}
+			defer writer.Flush()
+
+			results[i], metadatas[i], errs[i] = o.outImage(ctx, kpackClient, inDir, src, name, params, env, labeledLogger{mu: &mu, log: log, label: label}, writer)
+		}(i, name)
+	}
+	wg.Wait()
+
+	log.Infof(purple("\nResults:\n"))
+
+	version := oc.Version{}
+	var (
+		metadata oc.Metadata
+		failures []string
+	)
+	for i, name := range names {
+		if errs[i] != nil {
+			log.Errorf("  %s: %s\n", name, red(errs[i].Error()))
+			failures = append(failures, fmt.Sprintf("  %s: %s", name, errs[i]))
+			continue
+		}
+		log.Infof("  %s: %s\n", name, green(results[i]))
+		version[imageVersionKey(name)] = results[i]
+		for _, nameVal := range metadatas[i] {
+			metadata = append(metadata, oc.NameVal{Name: name + "/" + nameVal.Name, Value: nameVal.Value})
+		}
+	}
+
+	if len(failures) > 0 {
+		return nil, nil, errors.Errorf("failed to update %d of %d images:\n%s", len(failures), len(names), strings.Join(failures, "\n"))
+	}
+
+	return version, metadata, nil
+}
+
+const imageVersionPrefix = "image/"
+
+func imageVersionKey(name string) string {
+	return imageVersionPrefix + name
+}
+
+// writeVersionImages writes each image of a version produced by outImages
+// to images/<name>.
+func writeVersionImages(outDir string, version oc.Version) error {
+	err := os.MkdirAll(filepath.Join(outDir, imagesDir), 0755)
+	if err != nil {
+		return err
+	}
+
+	for key, image := range version {
+		if !strings.HasPrefix(key, imageVersionPrefix) {
+			continue
+		}
+
+		err = ioutil.WriteFile(filepath.Join(outDir, imagesDir, strings.TrimPrefix(key, imageVersionPrefix)), []byte(image), 0644)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// labeledLogger prefixes every line logged with a label and serializes
+// logging across concurrent image updates.
+type labeledLogger struct {
+	mu    *sync.Mutex
+	log   Logger
+	label string
+}
+
+func (l labeledLogger) Errorf(message string, args ...interface{}) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.log.Errorf("%s", labelLines(l.label, fmt.Sprintf(message, args...)))
+}
+
+func (l labeledLogger) Warnf(message string, args ...interface{}) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.log.Warnf("%s", labelLines(l.label, fmt.Sprintf(message, args...)))
+}
+
+func (l labeledLogger) Infof(message string, args ...interface{}) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.log.Infof("%s", labelLines(l.label, fmt.Sprintf(message, args...)))
+}
+
+func (l labeledLogger) Debugf(message string, args ...interface{}) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.log.Debugf("%s", labelLines(l.label, fmt.Sprintf(message, args...)))
+}
+
+func labelLines(label, message string) string {
+	lines := strings.Split(message, "\n")
+	for i, line := range lines {
+		if line != "" {
+			lines[i] = label + " " + line
+		}
+	}
+	return strings.Join(lines, "\n")
+}
+
+// labeledWriter prefixes each complete line with a label so that build logs
+// of concurrent image updates can be interleaved.
+type labeledWriter struct {
+	mu     *sync.Mutex
+	writer io.Writer
+	label  string
+	buf    []byte
+}
+
+func (w *labeledWriter) Write(p []byte) (int, error) {
+	w.buf = append(w.buf, p...)
+	for {
+		i := bytes.IndexByte(w.buf, '\n')
+		if i < 0 {
+			return len(p), nil
+		}
+
+		err := w.writeLine(w.buf[:i+1])
+		w.buf = w.buf[i+1:]
+		if err != nil {
+			return len(p), err
+		}
+	}
+}
+
+// Flush writes any trailing partial line.
+func (w *labeledWriter) Flush() error {
+	if len(w.buf) == 0 {
+		return nil
+	}
+
+	err := w.writeLine(append(w.buf, '\n'))
+	w.buf = nil
+	return err
+}
+
+func (w *labeledWriter) writeLine(line []byte) error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	_, err := fmt.Fprintf(w.writer, "%s %s", w.label, line)
+	return err
+}
@@ -0,0 +1,275 @@
+// Copyright 2020-Present VMware, Inc.
+// SPDX-License-Identifier: Apache-2.0
+
+package resource_test
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+	"time"
+
+	oc "github.com/cloudboss/ofcourse/ofcourse"
+	"github.com/pivotal/kpack/pkg/apis/build/v1alpha2"
+	corev1alpha1 "github.com/pivotal/kpack/pkg/apis/core/v1alpha1"
+	"github.com/pkg/errors"
+	"github.com/sclevine/spec"
+	"github.com/stretchr/testify/require"
+	corev1 "k8s.io/api/core/v1"
+	v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	clientgotesting "k8s.io/client-go/testing"
+
+	"github.com/pivotal/concourse-kpack-resource/resource"
+)
+
+func TestFanOut(t *testing.T) {
+	spec.Run(t, "TestFanOut", testFanOut)
+}
+
+func testFanOut(t *testing.T, when spec.G, it spec.S) {
+	const (
+		namespace     = "test-namespace"
+		commitishPath = "some-commit-file"
+	)
+
+	var (
+		dir       string
+		orders    *v1alpha2.Image
+		payments  *v1alpha2.Image
+		unrelated *v1alpha2.Image
+	)
+
+	gitImage := func(name, app string) *v1alpha2.Image {
+		return &v1alpha2.Image{
+			ObjectMeta: v1.ObjectMeta{
+				Name:      name,
+				Namespace: namespace,
+				Labels:    map[string]string{"app": app},
+			},
+			Spec: v1alpha2.ImageSpec{
+				Source: corev1alpha1.SourceConfig{
+					Git: &corev1alpha1.Git{
+						URL:      "https://some.git.com",
+						Revision: "oldrevision",
+					},
+					SubPath: name,
+				},
+			},
+		}
+	}
+
+	withRevision := func(image *v1alpha2.Image, revision string) *v1alpha2.Image {
+		updated := image.DeepCopy()
+		updated.Spec.Source.Git.Revision = revision
+		return updated
+	}
+
+	it.Before(func() {
+		var err error
+		dir, err = ioutil.TempDir("", "fan_out_test")
+		require.NoError(t, err)
+
+		err = ioutil.WriteFile(filepath.Join(dir, commitishPath), []byte("new-commit\n"), 0644)
+		require.NoError(t, err)
+
+		orders = gitImage("orders", "shop")
+		payments = gitImage("payments", "shop")
+		unrelated = gitImage("unrelated", "other")
+	})
+
+	it.After(func() {
+		os.RemoveAll(dir)
+	})
+
+	it("updates every listed image and returns a combined version", func() {
+		OutTest{
+			InDir:   dir,
+			Objects: []runtime.Object{orders, payments, unrelated},
+			Source: resource.Source{
+				Image:     "orders",
+				Namespace: namespace,
+			},
+			Parameters: resource.OutParams{
+				Commitish:   commitishPath,
+				Images:      []string{"payments", "orders"},
+				Parallelism: 1,
+			},
+			TerminalImages: map[string]string{
+				"orders":   "some.reg.io/orders@sha256:1",
+				"payments": "some.reg.io/payments@sha256:1",
+			},
+			ExpectedOutput: []string{
+				"[orders] Updating image 'orders' in namespace 'test-namespace'",
+				"[orders] New revision:",
+				"[payments] Updating image 'payments' in namespace 'test-namespace'",
+			},
+			ExpectUpdates: []clientgotesting.UpdateActionImpl{
+				{Object: withRevision(orders, "new-commit")},
+				{Object: withRevision(payments, "new-commit")},
+			},
+			ExpectedImagesToWaitOn: []*v1alpha2.Image{
+				withRevision(orders, "new-commit"),
+				withRevision(payments, "new-commit"),
+			},
+			ExpectedVersion: oc.Version{
+				"image/orders":   "some.reg.io/orders@sha256:1",
+				"image/payments": "some.reg.io/payments@sha256:1",
+			},
+		}.test(t)
+	})
+
+	it("updates the images tracked by a multi-image source", func() {
+		OutTest{
+			InDir:   dir,
+			Objects: []runtime.Object{orders, payments, unrelated},
+			Source: resource.Source{
+				Namespace:     namespace,
+				LabelSelector: "app=shop",
+			},
+			Parameters: resource.OutParams{
+				Commitish:   commitishPath,
+				Parallelism: 1,
+			},
+			TerminalImage: "some.reg.io/image@sha256:1",
+			ExpectUpdates: []clientgotesting.UpdateActionImpl{
+				{Object: withRevision(orders, "new-commit")},
+				{Object: withRevision(payments, "new-commit")},
+			},
+			ExpectedImagesToWaitOn: []*v1alpha2.Image{
+				withRevision(orders, "new-commit"),
+				withRevision(payments, "new-commit"),
+			},
+			ExpectedVersion: oc.Version{
+				"image/orders":   "some.reg.io/image@sha256:1",
+				"image/payments": "some.reg.io/image@sha256:1",
+			},
+		}.test(t)
+	})
+
+	it("reports failures per image", func() {
+		payments.Spec.Source.Git = nil
+
+		OutTest{
+			InDir:   dir,
+			Objects: []runtime.Object{orders, payments, unrelated},
+			Source: resource.Source{
+				Namespace: namespace,
+			},
+			Parameters: resource.OutParams{
+				Commitish:     commitishPath,
+				LabelSelector: "app in (shop, other)",
+				Parallelism:   1,
+			},
+			TerminalImage: "some.reg.io/image@sha256:1",
+			TerminalErrors: map[string]error{
+				"unrelated": errors.New("build failed"),
+			},
+			ExpectUpdates: []clientgotesting.UpdateActionImpl{
+				{Object: withRevision(orders, "new-commit")},
+				{Object: withRevision(unrelated, "new-commit")},
+			},
+			ExpectedImagesToWaitOn: []*v1alpha2.Image{
+				withRevision(orders, "new-commit"),
+				withRevision(unrelated, "new-commit"),
+			},
+			ExpectedOutput: []string{
+				"Results:",
+				"  orders: \033[1;32msome.reg.io/image@sha256:1\033[0m",
+				"  payments: \033[1;31mimage 'payments' is not configured to use a git source\033[0m",
+				"  unrelated: \033[1;31mbuild failed\033[0m",
+			},
+			ExpectError: "failed to update 2 of 3 images:\n" +
+				"  payments: image 'payments' is not configured to use a git source\n" +
+				"  unrelated: build failed",
+		}.test(t)
+	})
+
+	it("checks for builds after the combined version", func() {
+		buildTime := time.Now()
+		build := func(image string, number int, latestImage string) *v1alpha2.Build {
+			buildTime = buildTime.Add(time.Minute)
+			return &v1alpha2.Build{
+				ObjectMeta: v1.ObjectMeta{
+					Name:      fmt.Sprintf("%s-build-%d", image, number),
+					Namespace: namespace,
+					Labels: map[string]string{
+						v1alpha2.ImageLabel:       image,
+						v1alpha2.BuildNumberLabel: strconv.Itoa(number),
+						"app":                     "shop",
+					},
+					CreationTimestamp: v1.Time{Time: buildTime},
+				},
+				Status: v1alpha2.BuildStatus{
+					Status: corev1alpha1.Status{
+						Conditions: corev1alpha1.Conditions{
+							{Type: corev1alpha1.ConditionSucceeded, Status: corev1.ConditionTrue},
+						},
+					},
+					LatestImage: latestImage,
+				},
+			}
+		}
+
+		CheckTest{
+			Objects: []runtime.Object{
+				orders, payments, unrelated,
+				build("orders", 1, "some.reg.io/orders@sha256:1"),
+				build("payments", 1, "some.reg.io/payments@sha256:1"),
+				build("orders", 2, "some.reg.io/orders@sha256:2"),
+				build("payments", 2, "some.reg.io/payments@sha256:2"),
+				build("orders", 3, "some.reg.io/orders@sha256:3"),
+			},
+			Source: resource.Source{
+				Namespace:     namespace,
+				LabelSelector: "app=shop",
+			},
+			Version: oc.Version{
+				"image/orders":   "some.reg.io/orders@sha256:2",
+				"image/payments": "some.reg.io/payments@sha256:2",
+			},
+			ExpectedVersion: []oc.Version{
+				{"image": "some.reg.io/orders@sha256:3", "digest": "sha256:3", "name": "orders"},
+			},
+		}.test(t)
+	})
+
+	it("returns an error when no images match", func() {
+		OutTest{
+			InDir:   dir,
+			Objects: []runtime.Object{orders},
+			Source: resource.Source{
+				Namespace: namespace,
+			},
+			Parameters: resource.OutParams{
+				Commitish:     commitishPath,
+				LabelSelector: "app=missing",
+			},
+			ExpectError: "no images in namespace 'test-namespace' match label selector 'app=missing'",
+		}.test(t)
+	})
+
+	it("writes the images of a combined version", func() {
+		InTest{
+			OutDir: dir,
+			Source: resource.Source{
+				Image:     "orders",
+				Namespace: namespace,
+			},
+			Version: oc.Version{
+				"image/orders":   "some.reg.io/orders@sha256:1",
+				"image/payments": "some.reg.io/payments@sha256:1",
+			},
+			ExpectedVersion: oc.Version{
+				"image/orders":   "some.reg.io/orders@sha256:1",
+				"image/payments": "some.reg.io/payments@sha256:1",
+			},
+		}.test(t)
+
+		assertFileContents(t, filepath.Join(dir, "images", "orders"), "some.reg.io/orders@sha256:1")
+		assertFileContents(t, filepath.Join(dir, "images", "payments"), "some.reg.io/payments@sha256:1")
+	})
+}
@@ -0,0 +1,224 @@
+// Copyright 2020-Present VMware, Inc.
+// SPDX-License-Identifier: Apache-2.0
+
+package resource
+
+import (
+	"context"
+	"fmt"
+	"io"
+
+	"github.com/pivotal/kpack/pkg/apis/build/v1alpha2"
+	corev1alpha1 "github.com/pivotal/kpack/pkg/apis/core/v1alpha1"
+	"github.com/pkg/errors"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/fields"
+	"k8s.io/apimachinery/pkg/watch"
+	watchTools "k8s.io/client-go/tools/watch"
+)
+
+type ImageLogTailer interface {
+	TailBuildName(ctx context.Context, writer io.Writer, namespace, buildName string) error
+}
+
+// imageWaiter waits on kpack to resolve an image update, streaming the
+// logs of any resulting build.
+type imageWaiter struct {
+	kpackClient KpackClient
+	logTailer   ImageLogTailer
+}
+
+func NewImageWaiter(kpackClient KpackClient, logTailer ImageLogTailer) ImageWaiter {
+	return &imageWaiter{kpackClient: kpackClient, logTailer: logTailer}
+}
+
+func (w *imageWaiter) Wait(ctx context.Context, writer io.Writer, image *v1alpha2.Image) (string, error) {
+	if done, err := imageUpdateHasResolved(image.Generation)(watch.Event{Object: image}); err != nil {
+		return "", err
+	} else if done {
+		return w.resultOfImageWait(ctx, writer, image.Generation, image)
+	}
+
+	event, err := until(ctx,
+		image.ResourceVersion,
+		watchOne{namespace: image.Namespace, name: image.Name, watch: w.kpackClient.WatchImages},
+		imageUpdateHasResolved(image.Generation))
+	if err != nil {
+		return "", err
+	}
+
+	image, ok := event.Object.(*v1alpha2.Image)
+	if !ok {
+		return "", errors.New("unexpected object received")
+	}
+
+	return w.resultOfImageWait(ctx, writer, image.Generation, image)
+}
+
+func imageUpdateHasResolved(generation int64) watchTools.ConditionFunc {
+	return func(event watch.Event) (bool, error) {
+		image, ok := event.Object.(*v1alpha2.Image)
+		if !ok {
+			return false, errors.New("unexpected object received")
+		}
+
+		if image.Status.ObservedGeneration == generation { // image is reconciled
+			if !image.Status.GetCondition(corev1alpha1.ConditionReady).IsUnknown() {
+				return true, nil // image is resolved
+			} else if image.Status.LatestBuildImageGeneration == generation {
+				return true, nil // build scheduled
+			} else {
+				return false, nil // still waiting on build to be scheduled
+			}
+		} else if image.Status.ObservedGeneration > generation {
+			return false, errors.Errorf("image %s was updated before original update was processed", image.Name)
+		} else {
+			return false, nil // still waiting on update
+		}
+	}
+}
+
+func (w *imageWaiter) resultOfImageWait(ctx context.Context, writer io.Writer, generation int64, image *v1alpha2.Image) (string, error) {
+	if image.Status.LatestBuildImageGeneration == generation {
+		return w.waitBuild(ctx, writer, image.Namespace, image.Status.LatestBuildRef)
+	}
+
+	if condition := image.Status.GetCondition(corev1alpha1.ConditionReady); condition.IsFalse() {
+		return "", imageFailure(image.Name, condition.Message)
+	}
+
+	return image.Status.LatestImage, nil
+}
+
+func imageFailure(name, statusMessage string) error {
+	errMsg := fmt.Sprintf("update to image %s failed", name)
+
+	if statusMessage != "" {
+		errMsg = fmt.Sprintf("%s: %s", errMsg, statusMessage)
+	}
+	return errors.New(errMsg)
+}
+
+func (w *imageWaiter) waitBuild(ctx context.Context, writer io.Writer, namespace, buildName string) (string, error) {
+	doneChan := make(chan struct{})
+	defer func() { <-doneChan }()
+
+	go func() { // tail logs
+		defer close(doneChan)
+		err := w.logTailer.TailBuildName(ctx, writer, namespace, buildName)
+		if err != nil {
+			fmt.Fprintf(writer, "error tailing logs %s", err)
+		}
+		if flusher, ok := writer.(interface{ Flush() error }); ok {
+			flusher.Flush()
+		}
+	}()
+
+	build, err := w.kpackClient.GetBuild(ctx, namespace, buildName)
+	if err != nil {
+		return "", err
+	}
+
+	if done, _ := buildHasResolved(watch.Event{Object: build}); !done {
+		event, err := until(ctx,
+			build.ResourceVersion,
+			watchOne{namespace: namespace, name: buildName, watch: w.kpackClient.WatchBuilds},
+			buildHasResolved)
+		if err != nil {
+			return "", err
+		}
+
+		var ok bool
+		build, ok = event.Object.(*v1alpha2.Build)
+		if !ok {
+			return "", errors.New("unexpected object received, expected Build")
+		}
+	}
+
+	if condition := build.Status.GetCondition(corev1alpha1.ConditionSucceeded); condition.IsFalse() {
+		return "", buildFailure(condition.Message)
+	}
+
+	return build.Status.LatestImage, nil
+}
+
+func buildHasResolved(event watch.Event) (bool, error) {
+	build, ok := event.Object.(*v1alpha2.Build)
+	if !ok {
+		return false, errors.New("unexpected object received, expected Build")
+	}
+
+	return !build.Status.GetCondition(corev1alpha1.ConditionSucceeded).IsUnknown(), nil
+}
+
+func buildFailure(statusMessage string) error {
+	errMsg := "build failed"
+
+	if statusMessage != "" {
+		errMsg = fmt.Sprintf("%s: %s", errMsg, statusMessage)
+	}
+	return errors.New(errMsg)
+}
+
+func filterErrors(condition watchTools.ConditionFunc) watchTools.ConditionFunc {
+	return func(event watch.Event) (bool, error) {
+		if event.Type == watch.Error {
+			return false, errors.Wrap(apierrors.FromObject(event.Object), "error on watch")
+		}
+
+		return condition(event)
+	}
+}
+
+// until watches w until condition is met. The retry watcher retries error
+// events, so invalid objects, such as ones that could not be converted from
+// v1alpha1, are reported by watchOne and fail the wait.
+func until(ctx context.Context, resourceVersion string, w watchOne, condition watchTools.ConditionFunc) (*watch.Event, error) {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	failed := make(chan error, 1)
+	w.ctx = ctx
+	w.failed = func(err error) {
+		select {
+		case failed <- err:
+		default:
+		}
+		cancel()
+	}
+
+	event, err := watchTools.Until(ctx, resourceVersion, w, filterErrors(condition))
+	select {
+	case err := <-failed:
+		return nil, err
+	default:
+		return event, err
+	}
+}
+
+// watchOne watches a single named object with a KpackClient watch func.
+type watchOne struct {
+	ctx       context.Context
+	namespace string
+	name      string
+	watch     func(ctx context.Context, namespace string, opts metav1.ListOptions) (watch.Interface, error)
+	failed    func(err error)
+}
+
+func (w watchOne) Watch(options metav1.ListOptions) (watch.Interface, error) {
+	options.FieldSelector = fields.OneTermEqualSelector("metadata.name", w.name).String()
+	watcher, err := w.watch(w.ctx, w.namespace, options)
+	if err != nil {
+		return nil, err
+	}
+
+	return watch.Filter(watcher, func(event watch.Event) (watch.Event, bool) {
+		if event.Type == watch.Error {
+			if err := apierrors.FromObject(event.Object); apierrors.IsInvalid(err) {
+				w.failed(errors.Wrap(err, "error on watch"))
+			}
+		}
+		return event, true
+	}), nil
+}
@@ -0,0 +1,167 @@
+// Copyright 2020-Present VMware, Inc.
+// SPDX-License-Identifier: Apache-2.0
+
+package resource_test
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/pivotal/kpack/pkg/apis/build/v1alpha2"
+	corev1alpha1 "github.com/pivotal/kpack/pkg/apis/core/v1alpha1"
+	"github.com/pivotal/kpack/pkg/client/clientset/versioned/fake"
+	"github.com/sclevine/spec"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	corev1 "k8s.io/api/core/v1"
+	v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/watch"
+	clientgotesting "k8s.io/client-go/testing"
+
+	"github.com/pivotal/concourse-kpack-resource/resource"
+	"github.com/pivotal/concourse-kpack-resource/resource/testhelpers"
+)
+
+func TestWait(t *testing.T) {
+	spec.Run(t, "TestWait", testWait)
+}
+
+func testWait(t *testing.T, when spec.G, it spec.S) {
+	const (
+		namespace = "test-namespace"
+		buildName = "test-build-1"
+	)
+
+	var (
+		logTailer = &fakeLogTailer{}
+		image     *v1alpha2.Image
+	)
+
+	it.Before(func() {
+		image = &v1alpha2.Image{
+			ObjectMeta: v1.ObjectMeta{
+				Name:       "test",
+				Namespace:  namespace,
+				Generation: 2,
+			},
+			Status: v1alpha2.ImageStatus{
+				Status: corev1alpha1.Status{
+					ObservedGeneration: 2,
+				},
+				LatestImage: "some.reg.io/image@sha256:previous",
+			},
+		}
+	})
+
+	var watchReactors []clientgotesting.WatchReactionFunc
+
+	it.Before(func() {
+		watchReactors = nil
+	})
+
+	wait := func(objects ...*v1alpha2.Build) (string, error) {
+		client := fake.NewSimpleClientset()
+		client.Resources = testhelpers.KpackDiscovery("")
+		for _, reactor := range watchReactors {
+			client.PrependWatchReactor("*", reactor)
+		}
+		for _, build := range objects {
+			require.NoError(t, client.Tracker().Add(build))
+		}
+
+		kpackClient, err := resource.NewKpackClient(context.TODO(), client)
+		require.NoError(t, err)
+
+		return resource.NewImageWaiter(kpackClient, logTailer).Wait(context.TODO(), &strings.Builder{}, image)
+	}
+
+	build := func(status corev1.ConditionStatus, message string) *v1alpha2.Build {
+		return &v1alpha2.Build{
+			ObjectMeta: v1.ObjectMeta{
+				Name:      buildName,
+				Namespace: namespace,
+			},
+			Status: v1alpha2.BuildStatus{
+				Status: corev1alpha1.Status{
+					Conditions: corev1alpha1.Conditions{
+						{Type: corev1alpha1.ConditionSucceeded, Status: status, Message: message},
+					},
+				},
+				LatestImage: "some.reg.io/image@sha256:built",
+			},
+		}
+	}
+
+	it("returns the latest image when no build was needed", func() {
+		image.Status.Conditions = corev1alpha1.Conditions{
+			{Type: corev1alpha1.ConditionReady, Status: corev1.ConditionTrue},
+		}
+
+		latestImage, err := wait()
+		require.NoError(t, err)
+
+		assert.Equal(t, "some.reg.io/image@sha256:previous", latestImage)
+		assert.Empty(t, logTailer.tailed)
+	})
+
+	it("returns an error when the image failed", func() {
+		image.Status.Conditions = corev1alpha1.Conditions{
+			{Type: corev1alpha1.ConditionReady, Status: corev1.ConditionFalse, Message: "some failure"},
+		}
+
+		_, err := wait()
+		require.EqualError(t, err, "update to image test failed: some failure")
+	})
+
+	when("a build was scheduled", func() {
+		it.Before(func() {
+			image.Status.LatestBuildImageGeneration = 2
+			image.Status.LatestBuildRef = buildName
+		})
+
+		it("tails the build logs and returns the built image", func() {
+			latestImage, err := wait(build(corev1.ConditionTrue, ""))
+			require.NoError(t, err)
+
+			assert.Equal(t, "some.reg.io/image@sha256:built", latestImage)
+			assert.Equal(t, []string{namespace + "/" + buildName}, logTailer.tailed)
+		})
+
+		it("returns an error when the build failed", func() {
+			_, err := wait(build(corev1.ConditionFalse, "some build failure"))
+			require.EqualError(t, err, "build failed: some build failure")
+		})
+
+		it("returns the error of objects that could not be converted", func() {
+			watchReactors = append(watchReactors, func(action clientgotesting.Action) (bool, watch.Interface, error) {
+				watcher := watch.NewFake()
+				go watcher.Error(&v1.Status{
+					Status:  v1.StatusFailure,
+					Code:    http.StatusUnprocessableEntity,
+					Reason:  v1.StatusReasonInvalid,
+					Message: "converting from v1alpha1: some conversion failure",
+				})
+				return true, watcher, nil
+			})
+
+			running := build(corev1.ConditionUnknown, "")
+			running.ResourceVersion = "1"
+
+			_, err := wait(running)
+			require.EqualError(t, err, "error on watch: converting from v1alpha1: some conversion failure")
+		})
+	})
+}
+
+type fakeLogTailer struct {
+	tailed []string
+}
+
+func (f *fakeLogTailer) TailBuildName(ctx context.Context, writer io.Writer, namespace, buildName string) error {
+	f.tailed = append(f.tailed, fmt.Sprintf("%s/%s", namespace, buildName))
+	return nil
+}
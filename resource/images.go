@@ -0,0 +1,113 @@
+// Copyright 2020-Present VMware, Inc.
+// SPDX-License-Identifier: Apache-2.0
+
+package resource
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/pivotal/kpack/pkg/apis/build/v1alpha2"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+const (
+	imageNameFile = "name"
+	imagesDir     = "images"
+)
+
+// trackedImages returns the names of the images tracked by src, which are
+// the listed images and any matching the label selector.
+func trackedImages(ctx context.Context, kpackClient KpackClient, src Source) ([]string, error) {
+	if !src.multiImage() {
+		return []string{src.Image}, nil
+	}
+
+	names := map[string]bool{}
+	for _, name := range src.Images {
+		names[name] = true
+	}
+
+	if src.LabelSelector != "" {
+		images, err := kpackClient.ListImages(ctx, src.Namespace, metav1.ListOptions{
+			LabelSelector: src.LabelSelector,
+		})
+		if err != nil {
+			return nil, err
+		}
+
+		for _, image := range images {
+			names[image.Name] = true
+		}
+	}
+
+	sorted := make([]string, 0, len(names))
+	for name := range names {
+		sorted = append(sorted, name)
+	}
+	sort.Strings(sorted)
+	return sorted, nil
+}
+
+// listImageBuilds returns the builds of every tracked image, oldest first.
+func listImageBuilds(ctx context.Context, kpackClient KpackClient, src Source) ([]v1alpha2.Build, error) {
+	names, err := trackedImages(ctx, kpackClient, src)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(names) == 0 {
+		return nil, nil
+	}
+
+	selector := fmt.Sprintf("%s=%s", v1alpha2.ImageLabel, src.Image)
+	if src.multiImage() {
+		selector = fmt.Sprintf("%s in (%s)", v1alpha2.ImageLabel, strings.Join(names, ","))
+	}
+
+	buildList, err := kpackClient.ListBuilds(ctx, src.Namespace, metav1.ListOptions{
+		LabelSelector: selector,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return filterBuilds(buildList), nil
+}
+
+// writeTrackedImages writes the name of the image that produced version and
+// the latest image of every tracked image to images/<name>.
+func writeTrackedImages(ctx context.Context, kpackClient KpackClient, outDir string, src Source, name string) error {
+	err := ioutil.WriteFile(filepath.Join(outDir, imageNameFile), []byte(name), 0644)
+	if err != nil {
+		return err
+	}
+
+	names, err := trackedImages(ctx, kpackClient, src)
+	if err != nil {
+		return err
+	}
+
+	err = os.MkdirAll(filepath.Join(outDir, imagesDir), 0755)
+	if err != nil {
+		return err
+	}
+
+	for _, name := range names {
+		image, err := kpackClient.GetImage(ctx, src.Namespace, name)
+		if err != nil {
+			return err
+		}
+
+		err = ioutil.WriteFile(filepath.Join(outDir, imagesDir, name), []byte(image.Status.LatestImage), 0644)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
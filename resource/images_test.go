@@ -0,0 +1,164 @@
+// Copyright 2020-Present VMware, Inc.
+// SPDX-License-Identifier: Apache-2.0
+
+package resource_test
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	oc "github.com/cloudboss/ofcourse/ofcourse"
+	"github.com/pivotal/kpack/pkg/apis/build/v1alpha2"
+	corev1alpha1 "github.com/pivotal/kpack/pkg/apis/core/v1alpha1"
+	"github.com/sclevine/spec"
+	"github.com/stretchr/testify/require"
+	corev1 "k8s.io/api/core/v1"
+	v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+
+	"github.com/pivotal/concourse-kpack-resource/resource"
+)
+
+func TestImages(t *testing.T) {
+	spec.Run(t, "TestImages", testImages)
+}
+
+func testImages(t *testing.T, when spec.G, it spec.S) {
+	const namespace = "test-namespace"
+
+	var (
+		firstBuildTime = time.Now()
+		outDir         string
+		objects        []runtime.Object
+	)
+
+	image := func(name, app, latestImage string) *v1alpha2.Image {
+		return &v1alpha2.Image{
+			ObjectMeta: v1.ObjectMeta{
+				Name:      name,
+				Namespace: namespace,
+				Labels:    map[string]string{"app": app},
+			},
+			Status: v1alpha2.ImageStatus{
+				LatestImage: latestImage,
+			},
+		}
+	}
+
+	build := func(name, imageName, latestImage string, created time.Duration) *v1alpha2.Build {
+		return &v1alpha2.Build{
+			ObjectMeta: v1.ObjectMeta{
+				Name:      name,
+				Namespace: namespace,
+				Labels: map[string]string{
+					v1alpha2.ImageLabel:       imageName,
+					v1alpha2.BuildNumberLabel: "1",
+				},
+				CreationTimestamp: v1.Time{Time: firstBuildTime.Add(created)},
+			},
+			Status: v1alpha2.BuildStatus{
+				Status: corev1alpha1.Status{
+					Conditions: corev1alpha1.Conditions{
+						{Type: corev1alpha1.ConditionSucceeded, Status: corev1.ConditionTrue},
+					},
+				},
+				LatestImage: latestImage,
+			},
+		}
+	}
+
+	it.Before(func() {
+		var err error
+		outDir, err = ioutil.TempDir("", "images_test")
+		require.NoError(t, err)
+
+		objects = []runtime.Object{
+			image("orders", "shop", "some/orders@sha256:2"),
+			image("payments", "shop", "some/payments@sha256:1"),
+			image("unrelated", "other", "some/unrelated@sha256:1"),
+			build("orders-build-1", "orders", "some/orders@sha256:1", 0),
+			build("payments-build-1", "payments", "some/payments@sha256:1", time.Minute),
+			build("unrelated-build-1", "unrelated", "some/unrelated@sha256:1", 2*time.Minute),
+			build("orders-build-2", "orders", "some/orders@sha256:2", 3*time.Minute),
+		}
+	})
+
+	it.After(func() {
+		os.RemoveAll(outDir)
+	})
+
+	when("checking", func() {
+		it("emits versions across images matching the label selector", func() {
+			CheckTest{
+				Objects: objects,
+				Source: resource.Source{
+					Namespace:     namespace,
+					LabelSelector: "app=shop",
+				},
+				ExpectedVersion: []oc.Version{
+					{"image": "some/orders@sha256:1", "digest": "sha256:1", "name": "orders"},
+					{"image": "some/payments@sha256:1", "digest": "sha256:1", "name": "payments"},
+					{"image": "some/orders@sha256:2", "digest": "sha256:2", "name": "orders"},
+				},
+			}.test(t)
+		})
+
+		it("emits versions after the previous version across listed images", func() {
+			CheckTest{
+				Objects: objects,
+				Source: resource.Source{
+					Namespace: namespace,
+					Images:    []string{"orders", "unrelated"},
+				},
+				Version: oc.Version{"image": "some/unrelated@sha256:1", "name": "unrelated"},
+				ExpectedVersion: []oc.Version{
+					{"image": "some/orders@sha256:2", "digest": "sha256:2", "name": "orders"},
+				},
+			}.test(t)
+		})
+
+		it("emits nothing when no images match", func() {
+			CheckTest{
+				Objects: objects,
+				Source: resource.Source{
+					Namespace:     namespace,
+					LabelSelector: "app=missing",
+				},
+			}.test(t)
+		})
+	})
+
+	it("writes the triggering image and the latest image of every tracked image", func() {
+		InTest{
+			Objects: objects,
+			Source: resource.Source{
+				Namespace:     namespace,
+				LabelSelector: "app=shop",
+			},
+			Version: oc.Version{"image": "some/payments@sha256:1", "digest": "sha256:1", "name": "payments"},
+			OutDir:  outDir,
+			ExpectedVersion: oc.Version{
+				"image":  "some/payments@sha256:1",
+				"digest": "sha256:1",
+				"name":   "payments",
+			},
+			ExpectedMetadata: oc.Metadata{
+				{Name: "imageName", Value: "payments"},
+				{Name: "buildNumber", Value: "1"},
+				{Name: "buildName", Value: "payments-build-1"},
+				{Name: "buildReason", Value: ""},
+			},
+		}.test(t)
+
+		assertFileContents(t, filepath.Join(outDir, "image"), "some/payments@sha256:1")
+		assertFileContents(t, filepath.Join(outDir, "name"), "payments")
+		assertFileContents(t, filepath.Join(outDir, "images", "orders"), "some/orders@sha256:2")
+		assertFileContents(t, filepath.Join(outDir, "images", "payments"), "some/payments@sha256:1")
+
+		_, err := os.Stat(filepath.Join(outDir, "images", "unrelated"))
+		require.True(t, os.IsNotExist(err))
+	})
+}
@@ -5,52 +5,179 @@ package resource
 
 import (
 	"context"
-	"fmt"
 	"io/ioutil"
 	"path/filepath"
+	"sort"
+	"strings"
 
 	oc "github.com/cloudboss/ofcourse/ofcourse"
-	"github.com/pivotal/kpack/pkg/apis/build/v1alpha1"
+	"github.com/google/go-containerregistry/pkg/name"
+	"github.com/google/go-containerregistry/pkg/v1/remote"
+	"github.com/pivotal/kpack/pkg/apis/build/v1alpha2"
 	"github.com/pivotal/kpack/pkg/client/clientset/versioned"
-	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"github.com/pkg/errors"
+	"k8s.io/client-go/kubernetes"
 )
 
-const imageFile = "image"
+const (
+	imageFile      = "image"
+	repositoryFile = "repository"
+	digestFile     = "digest"
+	tagFile        = "tag"
+	tagsFile       = "tags"
+)
 
 type In struct {
-	Clientset versioned.Interface
+	Clientset  versioned.Interface
+	KubeClient kubernetes.Interface
 }
 
 func (in *In) In(ctx context.Context, outDir string, source Source, params oc.Params, version oc.Version, env oc.Environment, logger Logger) (oc.Version, oc.Metadata, error) {
-	err := ioutil.WriteFile(filepath.Join(outDir, imageFile), []byte(version["image"]), 0644)
+	kpackClient, err := NewKpackClient(ctx, in.Clientset)
 	if err != nil {
 		return nil, nil, err
 	}
 
-	buildList, err := in.Clientset.KpackV1alpha1().Builds(source.Namespace).List(ctx, metav1.ListOptions{
-		LabelSelector: fmt.Sprintf("%s=%s", v1alpha1.ImageLabel, source.Image),
-	})
+	switch kind := source.resourceKind(); kind {
+	case KindImage:
+	case KindBuilder, KindClusterBuilder:
+		return inBuilder(ctx, kpackClient, outDir, source, version)
+	case KindClusterStack:
+		return inClusterStack(ctx, kpackClient, outDir, source, version)
+	case KindClusterStore:
+		return inClusterStore(ctx, kpackClient, outDir, source, version)
+	default:
+		return nil, nil, errors.Errorf("unsupported kind '%s'", kind)
+	}
+
+	inParams, err := NewInParams(params)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if inParams.Verify != nil {
+		err = in.verify(ctx, source.Namespace, version, *inParams.Verify, logger)
+		if err != nil {
+			return nil, nil, err
+		}
+	}
+
+	if _, ok := version["image"]; !ok {
+		if inParams.Provenance != nil || inParams.Policy != nil {
+			return nil, nil, errors.Errorf("provenance and policy are not supported for a version of multiple images")
+		}
+		return version, nil, writeVersionImages(outDir, version)
+	}
+
+	err = ioutil.WriteFile(filepath.Join(outDir, imageFile), []byte(version["image"]), 0644)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if source.multiImage() {
+		err = writeTrackedImages(ctx, kpackClient, outDir, source, version["name"])
+		if err != nil {
+			return nil, nil, err
+		}
+	}
+
+	builds, err := listImageBuilds(ctx, kpackClient, source)
 	if err != nil {
 		return nil, nil, err
 	}
 
-	builds := filterBuilds(buildList.Items)
 	index, ok := indexOfBuild(builds, version)
 	if !ok {
-		return version, nil, nil
+		if inParams.Provenance != nil || inParams.Policy != nil {
+			return nil, nil, errors.Errorf("provenance and policy require the build that produced '%s'", version["image"])
+		}
+		return version, nil, writeReferenceFiles(outDir, version["image"], nil)
 	}
 
 	build := builds[index]
 
-	return version,
-		append(oc.Metadata{
-			{Name: "buildNumber", Value: build.Labels[v1alpha1.BuildNumberLabel]},
-			{Name: "buildName", Value: build.Name},
-			{Name: "buildReason", Value: build.Annotations[v1alpha1.BuildReasonAnnotation]},
-		}, sourceMetadata(build)...), nil
+	if inParams.Policy != nil {
+		err = in.checkPolicy(ctx, source.Namespace, version["image"], build, *inParams.Policy, logger)
+		if err != nil {
+			return nil, nil, err
+		}
+	}
+
+	tags, err := in.presentTags(ctx, source.Namespace, version["image"], build.Spec.Tags, inParams.RegistryCredentials, logger)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	err = writeReferenceFiles(outDir, version["image"], tags)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if waitedOn(build) {
+		err = writeMetrics(outDir, build)
+		if err != nil {
+			return nil, nil, err
+		}
+	}
+
+	metadata := append(oc.Metadata{
+		{Name: "buildNumber", Value: build.Labels[v1alpha2.BuildNumberLabel]},
+		{Name: "buildName", Value: build.Name},
+		{Name: "buildReason", Value: build.Annotations[v1alpha2.BuildReasonAnnotation]},
+	}, sourceMetadata(build)...)
+	metadata = append(metadata, concourseMetadata(build)...)
+
+	for _, tag := range tags {
+		metadata = append(metadata, oc.NameVal{Name: "tag", Value: tag})
+	}
+
+	if source.multiImage() {
+		metadata = append(oc.Metadata{{Name: "imageName", Value: build.Labels[v1alpha2.ImageLabel]}}, metadata...)
+	}
+
+	if inParams.Provenance != nil {
+		provenanceMetadata, err := in.writeProvenance(ctx, outDir, source.Namespace, version["image"], build, env, *inParams.Provenance, logger)
+		if err != nil {
+			return nil, nil, err
+		}
+		metadata = append(metadata, provenanceMetadata...)
+	}
+
+	return version, metadata, nil
+}
+
+// verify fails unless every image in version has a signature valid for
+// the public key or certificate identity.
+func (in *In) verify(ctx context.Context, namespace string, version oc.Version, params VerifyParams, log Logger) error {
+	var images []string
+	for key, image := range version {
+		if key == "image" || strings.HasPrefix(key, imageVersionPrefix) {
+			images = append(images, image)
+		}
+	}
+	sort.Strings(images)
+
+	for _, image := range images {
+		digest, err := name.NewDigest(image)
+		if err != nil {
+			return errors.Wrapf(err, "parsing image: %s", image)
+		}
+
+		opts, err := in.remoteOptions(ctx, namespace, digest, params.RegistryCredentials)
+		if err != nil {
+			return err
+		}
+
+		err = verifySignature(ctx, digest, params, opts...)
+		if err != nil {
+			return err
+		}
+		log.Infof("Verified signature of %s.\n", digest)
+	}
+	return nil
 }
 
-func sourceMetadata(build v1alpha1.Build) []oc.NameVal {
+func sourceMetadata(build v1alpha2.Build) []oc.NameVal {
 	switch {
 	case build.Spec.Source.Git != nil:
 		return []oc.NameVal{
@@ -69,3 +196,63 @@ func sourceMetadata(build v1alpha1.Build) []oc.NameVal {
 		return nil
 	}
 }
+
+// writeReferenceFiles writes the image in the same layout as the
+// registry-image resource, along with every tag the build exported.
+// presentTags returns the tags the build requested that still point at
+// image. kpack does not record which tags it pushed and a later build moves
+// them, so each tag is resolved in the registry. A tag that cannot be
+// resolved is left out with a warning.
+func (in *In) presentTags(ctx context.Context, namespace, image string, tags []string, creds RegistryCredentials, log Logger) ([]string, error) {
+	_, digest := splitDigest(image)
+
+	var present []string
+	for _, tag := range tags {
+		ref, err := name.NewTag(tag, name.WeakValidation)
+		if err != nil {
+			log.Warnf("Skipping invalid tag '%s': %s\n", tag, err)
+			continue
+		}
+
+		opts, err := in.remoteOptions(ctx, namespace, ref, creds)
+		if err != nil {
+			return nil, err
+		}
+
+		desc, err := remote.Head(ref, opts...)
+		if err != nil {
+			log.Warnf("Could not resolve tag %s: %s\n", ref, err)
+			continue
+		}
+
+		if desc.Digest.String() == digest {
+			present = append(present, tag)
+		} else {
+			log.Debugf("Tag %s has moved to %s.\n", ref, desc.Digest)
+		}
+	}
+	return present, nil
+}
+
+func writeReferenceFiles(outDir, image string, tags []string) error {
+	repository, digest := splitDigest(image)
+
+	tags = bareTags(tags)
+	tag := ""
+	if len(tags) > 0 {
+		tag = tags[0]
+	}
+
+	for file, contents := range map[string]string{
+		repositoryFile: repository,
+		digestFile:     digest,
+		tagFile:        tag,
+		tagsFile:       strings.Join(tags, " "),
+	} {
+		err := ioutil.WriteFile(filepath.Join(outDir, file), []byte(contents), 0644)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
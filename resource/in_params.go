@@ -0,0 +1,75 @@
+// Copyright 2020-Present VMware, Inc.
+// SPDX-License-Identifier: Apache-2.0
+
+package resource
+
+import (
+	"fmt"
+
+	oc "github.com/cloudboss/ofcourse/ofcourse"
+
+	"github.com/pivotal/concourse-kpack-resource/config"
+)
+
+func NewInParams(ocParams oc.Params) (InParams, error) {
+	inParams := InParams{}
+	err := config.Decode("params", ocParams, &inParams)
+	return inParams, err
+}
+
+type InParams struct {
+	Verify     *VerifyParams     `json:"verify,omitempty"`
+	Provenance *ProvenanceParams `json:"provenance,omitempty"`
+	Policy     *PolicyParams     `json:"policy,omitempty"`
+	RegistryCredentials
+}
+
+type VerifyParams struct {
+	PublicKey             string `json:"public_key,omitempty"`
+	CertificateIdentity   string `json:"certificate_identity,omitempty"`
+	CertificateOIDCIssuer string `json:"certificate_oidc_issuer,omitempty"`
+	RekorURL              string `json:"rekor_url,omitempty"`
+	RegistryCredentials
+}
+
+type ProvenanceParams struct {
+	Attach      bool   `json:"attach,omitempty"`
+	Key         string `json:"key,omitempty"`
+	KeyPassword string `json:"key_password,omitempty"`
+	RegistryCredentials
+}
+
+type PolicyParams struct {
+	MaxRunImageAgeDays int      `json:"max_run_image_age_days,omitempty"`
+	AllowedStacks      []string `json:"allowed_stacks,omitempty"`
+	DeniedBuildpacks   []string `json:"denied_buildpacks,omitempty"`
+	RequireSBOM        bool     `json:"require_sbom,omitempty"`
+	RegistryCredentials
+}
+
+// Validate reports missing and conflicting verify and provenance keys.
+func (p InParams) Validate() []string {
+	var problems []string
+
+	if p.Verify != nil {
+		if (p.Verify.PublicKey == "") == (p.Verify.CertificateIdentity == "") {
+			problems = append(problems, "exactly one of 'verify.public_key' or 'verify.certificate_identity' is required")
+		}
+		if p.Verify.CertificateIdentity != "" {
+			for _, required := range []struct{ key, value string }{
+				{"certificate_oidc_issuer", p.Verify.CertificateOIDCIssuer},
+				{"rekor_url", p.Verify.RekorURL},
+			} {
+				if required.value == "" {
+					problems = append(problems, fmt.Sprintf("missing required key 'verify.%s'", required.key))
+				}
+			}
+		}
+	}
+
+	if p.Provenance != nil && p.Provenance.Attach && p.Provenance.Key == "" {
+		problems = append(problems, "missing required key 'provenance.key'")
+	}
+
+	return problems
+}
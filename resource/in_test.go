@@ -6,15 +6,24 @@ package resource_test
 import (
 	"context"
 	"io/ioutil"
+	"log"
+	"net/http/httptest"
 	"os"
 	"path/filepath"
+	"strings"
 	"testing"
 	"time"
 
 	oc "github.com/cloudboss/ofcourse/ofcourse"
+	"github.com/google/go-containerregistry/pkg/name"
+	"github.com/google/go-containerregistry/pkg/registry"
+	ggcrv1 "github.com/google/go-containerregistry/pkg/v1"
+	"github.com/google/go-containerregistry/pkg/v1/random"
+	"github.com/google/go-containerregistry/pkg/v1/remote"
 	"github.com/pivotal/concourse-kpack-resource/resource"
 	"github.com/pivotal/concourse-kpack-resource/resource/testhelpers"
 	"github.com/pivotal/kpack/pkg/apis/build/v1alpha1"
+	"github.com/pivotal/kpack/pkg/apis/build/v1alpha2"
 	corev1alpha1 "github.com/pivotal/kpack/pkg/apis/core/v1alpha1"
 	"github.com/pivotal/kpack/pkg/client/clientset/versioned/fake"
 	"github.com/sclevine/spec"
@@ -54,26 +63,26 @@ func testIn(t *testing.T, when spec.G, it spec.S) {
 	it("fetches git metadata and writes the image to file", func() {
 		InTest{
 			Objects: []runtime.Object{
-				&v1alpha1.Image{
+				&v1alpha2.Image{
 					ObjectMeta: v1.ObjectMeta{
 						Name:      imageName,
 						Namespace: namespace,
 					},
 				},
-				&v1alpha1.Build{
+				&v1alpha2.Build{
 					ObjectMeta: v1.ObjectMeta{
 						Name:      "build-name-1",
 						Namespace: namespace,
 						Labels: map[string]string{
-							v1alpha1.ImageLabel:       imageName,
-							v1alpha1.BuildNumberLabel: "1",
+							v1alpha2.ImageLabel:       imageName,
+							v1alpha2.BuildNumberLabel: "1",
 						},
 						Annotations: map[string]string{
-							v1alpha1.BuildReasonAnnotation: "Build1Reason",
+							v1alpha2.BuildReasonAnnotation: "Build1Reason",
 						},
 						CreationTimestamp: v1.Time{Time: firstBuildTime},
 					},
-					Spec: v1alpha1.BuildSpec{
+					Spec: v1alpha2.BuildSpec{
 						Source: corev1alpha1.SourceConfig{
 							Git: &corev1alpha1.Git{
 								URL:      "gitUrl",
@@ -81,21 +90,21 @@ func testIn(t *testing.T, when spec.G, it spec.S) {
 							},
 						},
 					},
-					Status: v1alpha1.BuildStatus{
+					Status: v1alpha2.BuildStatus{
 						LatestImage: imageVersion,
 					},
 				},
-				&v1alpha1.Build{
+				&v1alpha2.Build{
 					ObjectMeta: v1.ObjectMeta{
 						Name:      "build-name-2",
 						Namespace: namespace,
 						Labels: map[string]string{
-							v1alpha1.ImageLabel:       imageName,
-							v1alpha1.BuildNumberLabel: "2",
+							v1alpha2.ImageLabel:       imageName,
+							v1alpha2.BuildNumberLabel: "2",
 						},
 						CreationTimestamp: v1.Time{Time: firstBuildTime.Add(time.Minute)},
 					},
-					Status: v1alpha1.BuildStatus{
+					Status: v1alpha2.BuildStatus{
 						LatestImage: "some/image@sha256:buildtoIgnore",
 					},
 				},
@@ -127,26 +136,26 @@ func testIn(t *testing.T, when spec.G, it spec.S) {
 	it("fetches metadata from the last build", func() {
 		InTest{
 			Objects: []runtime.Object{
-				&v1alpha1.Image{
+				&v1alpha2.Image{
 					ObjectMeta: v1.ObjectMeta{
 						Name:      imageName,
 						Namespace: namespace,
 					},
 				},
-				&v1alpha1.Build{
+				&v1alpha2.Build{
 					ObjectMeta: v1.ObjectMeta{
 						Name:      "build-name-2",
 						Namespace: namespace,
 						Labels: map[string]string{
-							v1alpha1.ImageLabel:       imageName,
-							v1alpha1.BuildNumberLabel: "2",
+							v1alpha2.ImageLabel:       imageName,
+							v1alpha2.BuildNumberLabel: "2",
 						},
 						Annotations: map[string]string{
-							v1alpha1.BuildReasonAnnotation: "Build2Reason",
+							v1alpha2.BuildReasonAnnotation: "Build2Reason",
 						},
 						CreationTimestamp: v1.Time{Time: firstBuildTime.Add(time.Minute)},
 					},
-					Spec: v1alpha1.BuildSpec{
+					Spec: v1alpha2.BuildSpec{
 						Source: corev1alpha1.SourceConfig{
 							Git: &corev1alpha1.Git{
 								URL:      "gitUrl",
@@ -154,21 +163,21 @@ func testIn(t *testing.T, when spec.G, it spec.S) {
 							},
 						},
 					},
-					Status: v1alpha1.BuildStatus{
+					Status: v1alpha2.BuildStatus{
 						LatestImage: imageVersion,
 					},
 				},
-				&v1alpha1.Build{
+				&v1alpha2.Build{
 					ObjectMeta: v1.ObjectMeta{
 						Name:      "build-name-1",
 						Namespace: namespace,
 						Labels: map[string]string{
-							v1alpha1.ImageLabel:       imageName,
-							v1alpha1.BuildNumberLabel: "1",
+							v1alpha2.ImageLabel:       imageName,
+							v1alpha2.BuildNumberLabel: "1",
 						},
 						CreationTimestamp: v1.Time{Time: firstBuildTime},
 					},
-					Spec: v1alpha1.BuildSpec{
+					Spec: v1alpha2.BuildSpec{
 						Source: corev1alpha1.SourceConfig{
 							Git: &corev1alpha1.Git{
 								URL:      "gitUrl to ignore",
@@ -176,7 +185,7 @@ func testIn(t *testing.T, when spec.G, it spec.S) {
 							},
 						},
 					},
-					Status: v1alpha1.BuildStatus{
+					Status: v1alpha2.BuildStatus{
 						LatestImage: imageVersion,
 					},
 				},
@@ -222,38 +231,41 @@ func testIn(t *testing.T, when spec.G, it spec.S) {
 		}.test(t)
 
 		assertFileContents(t, filepath.Join(outDir, "image"), image)
+		assertFileContents(t, filepath.Join(outDir, "repository"), "some/image")
+		assertFileContents(t, filepath.Join(outDir, "digest"), "sha256:07c5121b7bc36783614544bd4a7cd6618dc04b963d926cf6e318268cfead0530")
+		assertFileContents(t, filepath.Join(outDir, "tag"), "")
 	})
 
 	it("fetches blob metadata", func() {
 		InTest{
 			Objects: []runtime.Object{
-				&v1alpha1.Image{
+				&v1alpha2.Image{
 					ObjectMeta: v1.ObjectMeta{
 						Name:      imageName,
 						Namespace: namespace,
 					},
 				},
-				&v1alpha1.Build{
+				&v1alpha2.Build{
 					ObjectMeta: v1.ObjectMeta{
 						Name:      "build-name-1",
 						Namespace: namespace,
 						Labels: map[string]string{
-							v1alpha1.ImageLabel:       imageName,
-							v1alpha1.BuildNumberLabel: "1",
+							v1alpha2.ImageLabel:       imageName,
+							v1alpha2.BuildNumberLabel: "1",
 						},
 						Annotations: map[string]string{
-							v1alpha1.BuildReasonAnnotation: "Build1Reason",
+							v1alpha2.BuildReasonAnnotation: "Build1Reason",
 						},
 						CreationTimestamp: v1.Time{Time: firstBuildTime},
 					},
-					Spec: v1alpha1.BuildSpec{
+					Spec: v1alpha2.BuildSpec{
 						Source: corev1alpha1.SourceConfig{
 							Blob: &corev1alpha1.Blob{
 								URL: "https://some-blob-url.com",
 							},
 						},
 					},
-					Status: v1alpha1.BuildStatus{
+					Status: v1alpha2.BuildStatus{
 						LatestImage: imageVersion,
 					},
 				},
@@ -284,33 +296,33 @@ func testIn(t *testing.T, when spec.G, it spec.S) {
 	it("fetches registry image metadata", func() {
 		InTest{
 			Objects: []runtime.Object{
-				&v1alpha1.Image{
+				&v1alpha2.Image{
 					ObjectMeta: v1.ObjectMeta{
 						Name:      imageName,
 						Namespace: namespace,
 					},
 				},
-				&v1alpha1.Build{
+				&v1alpha2.Build{
 					ObjectMeta: v1.ObjectMeta{
 						Name:      "build-name-1",
 						Namespace: namespace,
 						Labels: map[string]string{
-							v1alpha1.ImageLabel:       imageName,
-							v1alpha1.BuildNumberLabel: "1",
+							v1alpha2.ImageLabel:       imageName,
+							v1alpha2.BuildNumberLabel: "1",
 						},
 						Annotations: map[string]string{
-							v1alpha1.BuildReasonAnnotation: "Build1Reason",
+							v1alpha2.BuildReasonAnnotation: "Build1Reason",
 						},
 						CreationTimestamp: v1.Time{Time: firstBuildTime},
 					},
-					Spec: v1alpha1.BuildSpec{
+					Spec: v1alpha2.BuildSpec{
 						Source: corev1alpha1.SourceConfig{
 							Registry: &corev1alpha1.Registry{
 								Image: "some-source-image@sha256:something",
 							},
 						},
 					},
-					Status: v1alpha1.BuildStatus{
+					Status: v1alpha2.BuildStatus{
 						LatestImage: imageVersion,
 					},
 				},
@@ -337,14 +349,142 @@ func testIn(t *testing.T, when spec.G, it spec.S) {
 		assertFileContents(t, filepath.Join(outDir, "image"), imageVersion)
 
 	})
+
+	it("includes the requested tags that still point at the image", func() {
+		server := httptest.NewServer(registry.New(registry.Logger(log.New(ioutil.Discard, "", 0))))
+		defer server.Close()
+		repository := strings.TrimPrefix(server.URL, "http://") + "/app"
+
+		builtImage, err := random.Image(1024, 1)
+		require.NoError(t, err)
+		digest, err := builtImage.Digest()
+		require.NoError(t, err)
+		laterImage, err := random.Image(1024, 1)
+		require.NoError(t, err)
+
+		for ref, img := range map[string]ggcrv1.Image{
+			repository + ":latest": builtImage,
+			repository + ":v1.4.2": builtImage,
+			repository + ":v1.4.1": laterImage,
+		} {
+			tag, err := name.NewTag(ref)
+			require.NoError(t, err)
+			require.NoError(t, remote.Write(tag, img))
+		}
+
+		builtVersion := repository + "@" + digest.String()
+
+		InTest{
+			Objects: []runtime.Object{
+				&v1alpha2.Build{
+					ObjectMeta: v1.ObjectMeta{
+						Name:      "build-name-1",
+						Namespace: namespace,
+						Labels: map[string]string{
+							v1alpha2.ImageLabel:       imageName,
+							v1alpha2.BuildNumberLabel: "1",
+						},
+						CreationTimestamp: v1.Time{Time: firstBuildTime},
+					},
+					Spec: v1alpha2.BuildSpec{
+						Tags: []string{
+							repository,
+							repository + ":v1.4.2",
+							repository + ":v1.4.1",
+							repository + ":deleted",
+						},
+					},
+					Status: v1alpha2.BuildStatus{
+						LatestImage: builtVersion,
+					},
+				},
+			},
+			Source: resource.Source{
+				Image:     imageName,
+				Namespace: namespace,
+			},
+			Version: oc.Version{
+				"image": builtVersion,
+			},
+			OutDir: outDir,
+			ExpectedVersion: oc.Version{
+				"image": builtVersion,
+			},
+			ExpectedMetadata: oc.Metadata{
+				{Name: "buildNumber", Value: "1"},
+				{Name: "buildName", Value: "build-name-1"},
+				{Name: "buildReason", Value: ""},
+				{Name: "tag", Value: repository},
+				{Name: "tag", Value: repository + ":v1.4.2"},
+			},
+		}.test(t)
+
+		assertFileContents(t, filepath.Join(outDir, "repository"), repository)
+		assertFileContents(t, filepath.Join(outDir, "digest"), digest.String())
+		assertFileContents(t, filepath.Join(outDir, "tag"), "latest")
+		assertFileContents(t, filepath.Join(outDir, "tags"), "latest v1.4.2")
+	})
+
+	it("fetches metadata from v1alpha1 builds when v1alpha2 is not served", func() {
+		InTest{
+			KpackVersion: "v1alpha1",
+			Objects: []runtime.Object{
+				&v1alpha1.Build{
+					ObjectMeta: v1.ObjectMeta{
+						Name:      "build-name-1",
+						Namespace: namespace,
+						Labels: map[string]string{
+							v1alpha1.ImageLabel:       imageName,
+							v1alpha1.BuildNumberLabel: "1",
+						},
+						Annotations: map[string]string{
+							v1alpha1.BuildReasonAnnotation: "Build1Reason",
+						},
+						CreationTimestamp: v1.Time{Time: firstBuildTime},
+					},
+					Spec: v1alpha1.BuildSpec{
+						Source: corev1alpha1.SourceConfig{
+							Git: &corev1alpha1.Git{
+								URL:      "gitUrl",
+								Revision: "gitRevision",
+							},
+						},
+					},
+					Status: v1alpha1.BuildStatus{
+						LatestImage: imageVersion,
+					},
+				},
+			},
+			Source: resource.Source{
+				Image:     imageName,
+				Namespace: namespace,
+			},
+			Version: oc.Version{
+				"image": imageVersion,
+			},
+			OutDir: outDir,
+			ExpectedVersion: oc.Version{
+				"image": imageVersion,
+			},
+			ExpectedMetadata: oc.Metadata{
+				{Name: "buildNumber", Value: "1"},
+				{Name: "buildName", Value: "build-name-1"},
+				{Name: "buildReason", Value: "Build1Reason"},
+				{Name: "gitCommit", Value: "gitRevision"},
+				{Name: "gitUrl", Value: "gitUrl"},
+			},
+		}.test(t)
+	})
 }
 
 type InTest struct {
-	Objects    []runtime.Object
-	OutDir     string
-	Source     resource.Source
-	Parameters oc.Params
-	Version    oc.Version
+	KpackVersion string
+	Objects      []runtime.Object
+	OutDir       string
+	Source       resource.Source
+	Parameters   oc.Params
+	Version      oc.Version
+	Environment  map[string]string
 
 	ExpectedOutput   string
 	ExpectedVersion  oc.Version
@@ -355,6 +495,7 @@ type InTest struct {
 func (b InTest) test(t *testing.T) {
 	t.Helper()
 	client := fake.NewSimpleClientset(b.Objects...)
+	client.Resources = testhelpers.KpackDiscovery(b.KpackVersion)
 
 	testLog := &testhelpers.Logger{}
 
@@ -362,7 +503,12 @@ func (b InTest) test(t *testing.T) {
 		Clientset: client,
 	}
 
-	version, metadata, err := in.In(context.TODO(), b.OutDir, b.Source, b.Parameters, b.Version, nil, testLog)
+	var env oc.Environment
+	if b.Environment != nil {
+		env = oc.NewEnvironment(b.Environment)
+	}
+
+	version, metadata, err := in.In(context.TODO(), b.OutDir, b.Source, b.Parameters, b.Version, env, testLog)
 	if b.ExpectError == "" {
 		require.NoError(t, err)
 	} else {
@@ -0,0 +1,377 @@
+// Copyright 2020-Present VMware, Inc.
+// SPDX-License-Identifier: Apache-2.0
+
+package resource
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+
+	"github.com/pivotal/kpack/pkg/apis/build/v1alpha1"
+	"github.com/pivotal/kpack/pkg/apis/build/v1alpha2"
+	"github.com/pivotal/kpack/pkg/client/clientset/versioned"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/watch"
+)
+
+const (
+	kpackGroup    = "kpack.io"
+	kpackV1alpha1 = "v1alpha1"
+	kpackV1alpha2 = "v1alpha2"
+)
+
+// KpackClient reads and writes kpack resources as v1alpha2 objects
+// regardless of the kpack.io api version served by the cluster.
+type KpackClient interface {
+	Version() string
+	GetImage(ctx context.Context, namespace, name string) (*v1alpha2.Image, error)
+	UpdateImage(ctx context.Context, image *v1alpha2.Image, opts metav1.UpdateOptions) (*v1alpha2.Image, error)
+	ListImages(ctx context.Context, namespace string, opts metav1.ListOptions) ([]v1alpha2.Image, error)
+	WatchImages(ctx context.Context, namespace string, opts metav1.ListOptions) (watch.Interface, error)
+	GetBuild(ctx context.Context, namespace, name string) (*v1alpha2.Build, error)
+	ListBuilds(ctx context.Context, namespace string, opts metav1.ListOptions) ([]v1alpha2.Build, error)
+	WatchBuilds(ctx context.Context, namespace string, opts metav1.ListOptions) (watch.Interface, error)
+	GetBuilder(ctx context.Context, namespace, name string) (*v1alpha2.Builder, error)
+	UpdateBuilder(ctx context.Context, builder *v1alpha2.Builder) (*v1alpha2.Builder, error)
+	GetClusterBuilder(ctx context.Context, name string) (*v1alpha2.ClusterBuilder, error)
+	UpdateClusterBuilder(ctx context.Context, builder *v1alpha2.ClusterBuilder) (*v1alpha2.ClusterBuilder, error)
+	GetClusterStack(ctx context.Context, name string) (*v1alpha2.ClusterStack, error)
+	UpdateClusterStack(ctx context.Context, stack *v1alpha2.ClusterStack) (*v1alpha2.ClusterStack, error)
+	GetClusterStore(ctx context.Context, name string) (*v1alpha2.ClusterStore, error)
+	UpdateClusterStore(ctx context.Context, store *v1alpha2.ClusterStore) (*v1alpha2.ClusterStore, error)
+}
+
+// NewKpackClient uses v1alpha2 when it is served and falls back to v1alpha1
+// for older kpack installations.
+func NewKpackClient(ctx context.Context, clientset versioned.Interface) (KpackClient, error) {
+	groups, err := clientset.Discovery().ServerGroups()
+	if err != nil {
+		return nil, err
+	}
+
+	for _, group := range groups.Groups {
+		if group.Name != kpackGroup {
+			continue
+		}
+		for _, version := range group.Versions {
+			if version.Version == kpackV1alpha2 {
+				return v1alpha2Client{clientset: clientset}, nil
+			}
+		}
+	}
+
+	return v1alpha1Client{clientset: clientset}, nil
+}
+
+type v1alpha2Client struct {
+	clientset versioned.Interface
+}
+
+func (c v1alpha2Client) Version() string {
+	return kpackV1alpha2
+}
+
+func (c v1alpha2Client) GetImage(ctx context.Context, namespace, name string) (*v1alpha2.Image, error) {
+	return c.clientset.KpackV1alpha2().Images(namespace).Get(ctx, name, metav1.GetOptions{})
+}
+
+func (c v1alpha2Client) UpdateImage(ctx context.Context, image *v1alpha2.Image, opts metav1.UpdateOptions) (*v1alpha2.Image, error) {
+	return c.clientset.KpackV1alpha2().Images(image.Namespace).Update(ctx, image, opts)
+}
+
+func (c v1alpha2Client) ListImages(ctx context.Context, namespace string, opts metav1.ListOptions) ([]v1alpha2.Image, error) {
+	imageList, err := c.clientset.KpackV1alpha2().Images(namespace).List(ctx, opts)
+	if err != nil {
+		return nil, err
+	}
+	return imageList.Items, nil
+}
+
+func (c v1alpha2Client) WatchImages(ctx context.Context, namespace string, opts metav1.ListOptions) (watch.Interface, error) {
+	return c.clientset.KpackV1alpha2().Images(namespace).Watch(ctx, opts)
+}
+
+func (c v1alpha2Client) GetBuild(ctx context.Context, namespace, name string) (*v1alpha2.Build, error) {
+	return c.clientset.KpackV1alpha2().Builds(namespace).Get(ctx, name, metav1.GetOptions{})
+}
+
+func (c v1alpha2Client) ListBuilds(ctx context.Context, namespace string, opts metav1.ListOptions) ([]v1alpha2.Build, error) {
+	buildList, err := c.clientset.KpackV1alpha2().Builds(namespace).List(ctx, opts)
+	if err != nil {
+		return nil, err
+	}
+	return buildList.Items, nil
+}
+
+func (c v1alpha2Client) WatchBuilds(ctx context.Context, namespace string, opts metav1.ListOptions) (watch.Interface, error) {
+	return c.clientset.KpackV1alpha2().Builds(namespace).Watch(ctx, opts)
+}
+
+func (c v1alpha2Client) GetBuilder(ctx context.Context, namespace, name string) (*v1alpha2.Builder, error) {
+	return c.clientset.KpackV1alpha2().Builders(namespace).Get(ctx, name, metav1.GetOptions{})
+}
+
+func (c v1alpha2Client) UpdateBuilder(ctx context.Context, builder *v1alpha2.Builder) (*v1alpha2.Builder, error) {
+	return c.clientset.KpackV1alpha2().Builders(builder.Namespace).Update(ctx, builder, metav1.UpdateOptions{})
+}
+
+func (c v1alpha2Client) GetClusterBuilder(ctx context.Context, name string) (*v1alpha2.ClusterBuilder, error) {
+	return c.clientset.KpackV1alpha2().ClusterBuilders().Get(ctx, name, metav1.GetOptions{})
+}
+
+func (c v1alpha2Client) UpdateClusterBuilder(ctx context.Context, builder *v1alpha2.ClusterBuilder) (*v1alpha2.ClusterBuilder, error) {
+	return c.clientset.KpackV1alpha2().ClusterBuilders().Update(ctx, builder, metav1.UpdateOptions{})
+}
+
+func (c v1alpha2Client) GetClusterStack(ctx context.Context, name string) (*v1alpha2.ClusterStack, error) {
+	return c.clientset.KpackV1alpha2().ClusterStacks().Get(ctx, name, metav1.GetOptions{})
+}
+
+func (c v1alpha2Client) UpdateClusterStack(ctx context.Context, stack *v1alpha2.ClusterStack) (*v1alpha2.ClusterStack, error) {
+	return c.clientset.KpackV1alpha2().ClusterStacks().Update(ctx, stack, metav1.UpdateOptions{})
+}
+
+func (c v1alpha2Client) GetClusterStore(ctx context.Context, name string) (*v1alpha2.ClusterStore, error) {
+	return c.clientset.KpackV1alpha2().ClusterStores().Get(ctx, name, metav1.GetOptions{})
+}
+
+func (c v1alpha2Client) UpdateClusterStore(ctx context.Context, store *v1alpha2.ClusterStore) (*v1alpha2.ClusterStore, error) {
+	return c.clientset.KpackV1alpha2().ClusterStores().Update(ctx, store, metav1.UpdateOptions{})
+}
+
+// v1alpha1Client converts to and from v1alpha2 with the kpack conversion
+// functions, which keep v1alpha2 only fields in annotations.
+type v1alpha1Client struct {
+	clientset versioned.Interface
+}
+
+func (c v1alpha1Client) Version() string {
+	return kpackV1alpha1
+}
+
+func (c v1alpha1Client) GetImage(ctx context.Context, namespace, name string) (*v1alpha2.Image, error) {
+	image, err := c.clientset.KpackV1alpha1().Images(namespace).Get(ctx, name, metav1.GetOptions{})
+	if err != nil {
+		return nil, err
+	}
+	return imageFromV1alpha1(ctx, image)
+}
+
+func (c v1alpha1Client) UpdateImage(ctx context.Context, image *v1alpha2.Image, opts metav1.UpdateOptions) (*v1alpha2.Image, error) {
+	v1alpha1Image := &v1alpha1.Image{}
+	err := image.ConvertTo(ctx, v1alpha1Image)
+	if err != nil {
+		return nil, err
+	}
+
+	v1alpha1Image, err = c.clientset.KpackV1alpha1().Images(image.Namespace).Update(ctx, v1alpha1Image, opts)
+	if err != nil {
+		return nil, err
+	}
+	return imageFromV1alpha1(ctx, v1alpha1Image)
+}
+
+func (c v1alpha1Client) ListImages(ctx context.Context, namespace string, opts metav1.ListOptions) ([]v1alpha2.Image, error) {
+	imageList, err := c.clientset.KpackV1alpha1().Images(namespace).List(ctx, opts)
+	if err != nil {
+		return nil, err
+	}
+
+	images := make([]v1alpha2.Image, 0, len(imageList.Items))
+	for i := range imageList.Items {
+		image, err := imageFromV1alpha1(ctx, &imageList.Items[i])
+		if err != nil {
+			return nil, err
+		}
+		images = append(images, *image)
+	}
+	return images, nil
+}
+
+func (c v1alpha1Client) WatchImages(ctx context.Context, namespace string, opts metav1.ListOptions) (watch.Interface, error) {
+	w, err := c.clientset.KpackV1alpha1().Images(namespace).Watch(ctx, opts)
+	if err != nil {
+		return nil, err
+	}
+	return watch.Filter(w, func(event watch.Event) (watch.Event, bool) {
+		if image, ok := event.Object.(*v1alpha1.Image); ok {
+			converted, err := imageFromV1alpha1(ctx, image)
+			if err != nil {
+				return conversionError(err), true
+			}
+			event.Object = converted
+		}
+		return event, true
+	}), nil
+}
+
+func (c v1alpha1Client) GetBuild(ctx context.Context, namespace, name string) (*v1alpha2.Build, error) {
+	build, err := c.clientset.KpackV1alpha1().Builds(namespace).Get(ctx, name, metav1.GetOptions{})
+	if err != nil {
+		return nil, err
+	}
+	return buildFromV1alpha1(ctx, build)
+}
+
+func (c v1alpha1Client) ListBuilds(ctx context.Context, namespace string, opts metav1.ListOptions) ([]v1alpha2.Build, error) {
+	buildList, err := c.clientset.KpackV1alpha1().Builds(namespace).List(ctx, opts)
+	if err != nil {
+		return nil, err
+	}
+
+	builds := make([]v1alpha2.Build, 0, len(buildList.Items))
+	for i := range buildList.Items {
+		build, err := buildFromV1alpha1(ctx, &buildList.Items[i])
+		if err != nil {
+			return nil, err
+		}
+		builds = append(builds, *build)
+	}
+	return builds, nil
+}
+
+func (c v1alpha1Client) WatchBuilds(ctx context.Context, namespace string, opts metav1.ListOptions) (watch.Interface, error) {
+	w, err := c.clientset.KpackV1alpha1().Builds(namespace).Watch(ctx, opts)
+	if err != nil {
+		return nil, err
+	}
+	return watch.Filter(w, func(event watch.Event) (watch.Event, bool) {
+		if build, ok := event.Object.(*v1alpha1.Build); ok {
+			converted, err := buildFromV1alpha1(ctx, build)
+			if err != nil {
+				return conversionError(err), true
+			}
+			event.Object = converted
+		}
+		return event, true
+	}), nil
+}
+
+func (c v1alpha1Client) GetBuilder(ctx context.Context, namespace, name string) (*v1alpha2.Builder, error) {
+	builder, err := c.clientset.KpackV1alpha1().Builders(namespace).Get(ctx, name, metav1.GetOptions{})
+	if err != nil {
+		return nil, err
+	}
+
+	converted := &v1alpha2.Builder{}
+	return converted, converted.ConvertFrom(ctx, builder)
+}
+
+func (c v1alpha1Client) UpdateBuilder(ctx context.Context, builder *v1alpha2.Builder) (*v1alpha2.Builder, error) {
+	v1alpha1Builder := &v1alpha1.Builder{}
+	err := builder.ConvertTo(ctx, v1alpha1Builder)
+	if err != nil {
+		return nil, err
+	}
+
+	v1alpha1Builder, err = c.clientset.KpackV1alpha1().Builders(builder.Namespace).Update(ctx, v1alpha1Builder, metav1.UpdateOptions{})
+	if err != nil {
+		return nil, err
+	}
+
+	converted := &v1alpha2.Builder{}
+	return converted, converted.ConvertFrom(ctx, v1alpha1Builder)
+}
+
+func (c v1alpha1Client) GetClusterBuilder(ctx context.Context, name string) (*v1alpha2.ClusterBuilder, error) {
+	builder, err := c.clientset.KpackV1alpha1().ClusterBuilders().Get(ctx, name, metav1.GetOptions{})
+	if err != nil {
+		return nil, err
+	}
+
+	converted := &v1alpha2.ClusterBuilder{}
+	return converted, converted.ConvertFrom(ctx, builder)
+}
+
+func (c v1alpha1Client) UpdateClusterBuilder(ctx context.Context, builder *v1alpha2.ClusterBuilder) (*v1alpha2.ClusterBuilder, error) {
+	v1alpha1Builder := &v1alpha1.ClusterBuilder{}
+	err := builder.ConvertTo(ctx, v1alpha1Builder)
+	if err != nil {
+		return nil, err
+	}
+
+	v1alpha1Builder, err = c.clientset.KpackV1alpha1().ClusterBuilders().Update(ctx, v1alpha1Builder, metav1.UpdateOptions{})
+	if err != nil {
+		return nil, err
+	}
+
+	converted := &v1alpha2.ClusterBuilder{}
+	return converted, converted.ConvertFrom(ctx, v1alpha1Builder)
+}
+
+func (c v1alpha1Client) GetClusterStack(ctx context.Context, name string) (*v1alpha2.ClusterStack, error) {
+	stack, err := c.clientset.KpackV1alpha1().ClusterStacks().Get(ctx, name, metav1.GetOptions{})
+	if err != nil {
+		return nil, err
+	}
+
+	converted := &v1alpha2.ClusterStack{}
+	return converted, converted.ConvertFrom(ctx, stack)
+}
+
+func (c v1alpha1Client) UpdateClusterStack(ctx context.Context, stack *v1alpha2.ClusterStack) (*v1alpha2.ClusterStack, error) {
+	v1alpha1Stack := &v1alpha1.ClusterStack{}
+	err := stack.ConvertTo(ctx, v1alpha1Stack)
+	if err != nil {
+		return nil, err
+	}
+
+	v1alpha1Stack, err = c.clientset.KpackV1alpha1().ClusterStacks().Update(ctx, v1alpha1Stack, metav1.UpdateOptions{})
+	if err != nil {
+		return nil, err
+	}
+
+	converted := &v1alpha2.ClusterStack{}
+	return converted, converted.ConvertFrom(ctx, v1alpha1Stack)
+}
+
+func (c v1alpha1Client) GetClusterStore(ctx context.Context, name string) (*v1alpha2.ClusterStore, error) {
+	store, err := c.clientset.KpackV1alpha1().ClusterStores().Get(ctx, name, metav1.GetOptions{})
+	if err != nil {
+		return nil, err
+	}
+
+	converted := &v1alpha2.ClusterStore{}
+	return converted, converted.ConvertFrom(ctx, store)
+}
+
+func (c v1alpha1Client) UpdateClusterStore(ctx context.Context, store *v1alpha2.ClusterStore) (*v1alpha2.ClusterStore, error) {
+	v1alpha1Store := &v1alpha1.ClusterStore{}
+	err := store.ConvertTo(ctx, v1alpha1Store)
+	if err != nil {
+		return nil, err
+	}
+
+	v1alpha1Store, err = c.clientset.KpackV1alpha1().ClusterStores().Update(ctx, v1alpha1Store, metav1.UpdateOptions{})
+	if err != nil {
+		return nil, err
+	}
+
+	converted := &v1alpha2.ClusterStore{}
+	return converted, converted.ConvertFrom(ctx, v1alpha1Store)
+}
+
+// conversionError is a watch.Error event for an object that could not be
+// converted to v1alpha2, so watchers fail instead of seeing v1alpha1 objects.
+func conversionError(err error) watch.Event {
+	return watch.Event{
+		Type: watch.Error,
+		Object: &metav1.Status{
+			Status:  metav1.StatusFailure,
+			Code:    http.StatusUnprocessableEntity,
+			Reason:  metav1.StatusReasonInvalid,
+			Message: fmt.Sprintf("converting from %s: %s", kpackV1alpha1, err),
+		},
+	}
+}
+
+func imageFromV1alpha1(ctx context.Context, image *v1alpha1.Image) (*v1alpha2.Image, error) {
+	converted := &v1alpha2.Image{}
+	return converted, converted.ConvertFrom(ctx, image)
+}
+
+func buildFromV1alpha1(ctx context.Context, build *v1alpha1.Build) (*v1alpha2.Build, error) {
+	converted := &v1alpha2.Build{}
+	return converted, converted.ConvertFrom(ctx, build)
+}
@@ -3,7 +3,181 @@
 
 package resource
 
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
+	oc "github.com/cloudboss/ofcourse/ofcourse"
+	"github.com/pkg/errors"
+)
+
 type Logger interface {
+	Errorf(message string, args ...interface{})
+	Warnf(message string, args ...interface{})
 	Infof(message string, args ...interface{})
 	Debugf(message string, args ...interface{})
 }
+
+const (
+	LogFormatText = "text"
+	LogFormatJSON = "json"
+)
+
+const (
+	silentLevel = iota
+	errorLevel
+	warnLevel
+	infoLevel
+	debugLevel
+)
+
+var logLevels = map[string]int{
+	"silent": silentLevel,
+	"error":  errorLevel,
+	"warn":   warnLevel,
+	"info":   infoLevel,
+	"debug":  debugLevel,
+}
+
+var ansiEscape = regexp.MustCompile("\033\\[[0-9;]*m")
+
+// StreamLogger writes log messages at or above a level to a writer as text
+// or as JSON lines. It is also an io.Writer for build logs.
+type StreamLogger struct {
+	mu     sync.Mutex
+	writer io.Writer
+	level  int
+	format string
+	color  bool
+	buf    []byte
+}
+
+// NewLogger configures a logger from the log_level, log_format and no_color
+// source options. Colors are also disabled when NO_COLOR is set.
+func NewLogger(writer io.Writer, source Source, env oc.Environment) (*StreamLogger, error) {
+	level := infoLevel
+	if source.LogLevel != "" {
+		var ok bool
+		level, ok = logLevels[strings.ToLower(source.LogLevel)]
+		if !ok {
+			return nil, errors.Errorf("unsupported log_level '%s'", source.LogLevel)
+		}
+	}
+
+	format := strings.ToLower(source.LogFormat)
+	switch format {
+	case "":
+		format = LogFormatText
+	case LogFormatText, LogFormatJSON:
+	default:
+		return nil, errors.Errorf("unsupported log_format '%s'", source.LogFormat)
+	}
+
+	noColor := source.NoColor || (env != nil && env.Get("NO_COLOR") != "")
+
+	return &StreamLogger{
+		writer: writer,
+		level:  level,
+		format: format,
+		color:  !noColor && format == LogFormatText,
+	}, nil
+}
+
+func (l *StreamLogger) Errorf(message string, args ...interface{}) {
+	l.log(errorLevel, "error", red, message, args...)
+}
+
+func (l *StreamLogger) Warnf(message string, args ...interface{}) {
+	l.log(warnLevel, "warn", yellow, message, args...)
+}
+
+func (l *StreamLogger) Infof(message string, args ...interface{}) {
+	l.log(infoLevel, "info", nil, message, args...)
+}
+
+func (l *StreamLogger) Debugf(message string, args ...interface{}) {
+	l.log(debugLevel, "debug", nil, message, args...)
+}
+
+// Write logs complete lines of build logs at info level.
+func (l *StreamLogger) Write(p []byte) (int, error) {
+	if l.format == LogFormatText {
+		if l.level < infoLevel {
+			return len(p), nil
+		}
+		l.mu.Lock()
+		defer l.mu.Unlock()
+		_, err := l.writer.Write([]byte(l.decolor(string(p))))
+		return len(p), err
+	}
+
+	l.buf = append(l.buf, p...)
+	for {
+		i := strings.IndexByte(string(l.buf), '\n')
+		if i < 0 {
+			return len(p), nil
+		}
+
+		l.Infof("%s", l.buf[:i])
+		l.buf = l.buf[i+1:]
+	}
+}
+
+// Flush logs any trailing partial line of build logs.
+func (l *StreamLogger) Flush() error {
+	if len(l.buf) == 0 {
+		return nil
+	}
+
+	l.Infof("%s", l.buf)
+	l.buf = nil
+	return nil
+}
+
+func (l *StreamLogger) log(level int, name string, colorize func(...interface{}) string, message string, args ...interface{}) {
+	if level > l.level {
+		return
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	text := l.decolor(fmt.Sprintf(message, args...))
+
+	if l.format == LogFormatJSON {
+		text = strings.TrimSpace(text)
+		if text == "" {
+			return
+		}
+
+		line, _ := json.Marshal(struct {
+			Time    string `json:"time"`
+			Level   string `json:"level"`
+			Message string `json:"message"`
+		}{
+			Time:    time.Now().UTC().Format(time.RFC3339),
+			Level:   name,
+			Message: text,
+		})
+		fmt.Fprintf(l.writer, "%s\n", line)
+		return
+	}
+
+	if l.color && colorize != nil {
+		trimmed := strings.TrimRight(text, "\n")
+		text = colorize(trimmed) + text[len(trimmed):]
+	}
+	fmt.Fprint(l.writer, text)
+}
+
+func (l *StreamLogger) decolor(text string) string {
+	if l.color {
+		return text
+	}
+	return ansiEscape.ReplaceAllString(text, "")
+}
@@ -0,0 +1,129 @@
+// Copyright 2020-Present VMware, Inc.
+// SPDX-License-Identifier: Apache-2.0
+
+package resource_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"strings"
+	"testing"
+
+	oc "github.com/cloudboss/ofcourse/ofcourse"
+	"github.com/sclevine/spec"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/pivotal/concourse-kpack-resource/resource"
+)
+
+func TestLogger(t *testing.T) {
+	spec.Run(t, "TestLogger", testLogger)
+}
+
+func testLogger(t *testing.T, when spec.G, it spec.S) {
+	var out bytes.Buffer
+
+	it.Before(func() {
+		out.Reset()
+	})
+
+	it("logs text at or above the log level", func() {
+		logger, err := resource.NewLogger(&out, resource.Source{LogLevel: "warn"}, oc.NewEnvironment(map[string]string{}))
+		require.NoError(t, err)
+
+		logger.Debugf("debug\n")
+		logger.Infof("info\n")
+		logger.Warnf("warn %s\n", "message")
+		logger.Errorf("error\n")
+
+		assert.Equal(t, "\033[1;33mwarn message\033[0m\n\033[1;31merror\033[0m\n", out.String())
+	})
+
+	it("keeps colors in text by default", func() {
+		logger, err := resource.NewLogger(&out, resource.Source{}, oc.NewEnvironment(map[string]string{}))
+		require.NoError(t, err)
+
+		logger.Infof("Previous tag: %s\n", "\033[1;31mold\033[0m")
+
+		assert.Equal(t, "Previous tag: \033[1;31mold\033[0m\n", out.String())
+	})
+
+	it("strips colors with no_color", func() {
+		logger, err := resource.NewLogger(&out, resource.Source{NoColor: true}, oc.NewEnvironment(map[string]string{}))
+		require.NoError(t, err)
+
+		logger.Infof("Previous tag: %s\n", "\033[1;31mold\033[0m")
+		logger.Warnf("careful\n")
+		_, err = logger.Write([]byte("\033[1;34mbuild log\033[0m\n"))
+		require.NoError(t, err)
+
+		assert.Equal(t, "Previous tag: old\ncareful\nbuild log\n", out.String())
+	})
+
+	it("strips colors when NO_COLOR is set", func() {
+		logger, err := resource.NewLogger(&out, resource.Source{}, oc.NewEnvironment(map[string]string{"NO_COLOR": "1"}))
+		require.NoError(t, err)
+
+		logger.Errorf("failed\n")
+
+		assert.Equal(t, "failed\n", out.String())
+	})
+
+	it("logs json lines", func() {
+		logger, err := resource.NewLogger(&out, resource.Source{LogFormat: "json", LogLevel: "debug"}, nil)
+		require.NoError(t, err)
+
+		logger.Infof("Updating image '%s'.\n", "app")
+		logger.Debugf("\n")
+		logger.Errorf("\033[1;31mfailed\033[0m\n")
+		_, err = logger.Write([]byte("step one\nstep "))
+		require.NoError(t, err)
+		_, err = logger.Write([]byte("two\n"))
+		require.NoError(t, err)
+
+		var entries []map[string]string
+		for _, line := range strings.Split(strings.TrimSpace(out.String()), "\n") {
+			var entry map[string]string
+			require.NoError(t, json.Unmarshal([]byte(line), &entry))
+			assert.NotEmpty(t, entry["time"])
+			delete(entry, "time")
+			entries = append(entries, entry)
+		}
+
+		assert.Equal(t, []map[string]string{
+			{"level": "info", "message": "Updating image 'app'."},
+			{"level": "error", "message": "failed"},
+			{"level": "info", "message": "step one"},
+			{"level": "info", "message": "step two"},
+		}, entries)
+	})
+
+	it("logs a trailing partial line when flushed", func() {
+		logger, err := resource.NewLogger(&out, resource.Source{LogFormat: "json"}, nil)
+		require.NoError(t, err)
+
+		_, err = logger.Write([]byte("step one\nBuild successful"))
+		require.NoError(t, err)
+		assert.Equal(t, 1, strings.Count(out.String(), "\n"))
+
+		require.NoError(t, logger.Flush())
+		require.NoError(t, logger.Flush())
+
+		lines := strings.Split(strings.TrimSpace(out.String()), "\n")
+		require.Len(t, lines, 2)
+		var entry map[string]string
+		require.NoError(t, json.Unmarshal([]byte(lines[1]), &entry))
+		assert.Equal(t, "Build successful", entry["message"])
+	})
+
+	it("returns an error for an unsupported log_level", func() {
+		_, err := resource.NewLogger(&out, resource.Source{LogLevel: "verbose"}, nil)
+		require.EqualError(t, err, "unsupported log_level 'verbose'")
+	})
+
+	it("returns an error for an unsupported log_format", func() {
+		_, err := resource.NewLogger(&out, resource.Source{LogFormat: "xml"}, nil)
+		require.EqualError(t, err, "unsupported log_format 'xml'")
+	})
+}
@@ -0,0 +1,339 @@
+// Copyright 2020-Present VMware, Inc.
+// SPDX-License-Identifier: Apache-2.0
+
+package resource
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	oc "github.com/cloudboss/ofcourse/ofcourse"
+	"github.com/pivotal/kpack/pkg/apis/build/v1alpha2"
+	corev1alpha1 "github.com/pivotal/kpack/pkg/apis/core/v1alpha1"
+	"github.com/pkg/errors"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+const (
+	metricsFile = "metrics.json"
+
+	defaultPushgatewayJob = "concourse_kpack_resource"
+	eventComponent        = "concourse-kpack-resource"
+)
+
+// MetricsConfig configures where out reports the durations of the builds
+// it waits on.
+type MetricsConfig struct {
+	PushgatewayURL  string `json:"pushgateway_url,omitempty"`
+	PushgatewayJob  string `json:"pushgateway_job,omitempty"`
+	OTLPEndpoint    string `json:"otlp_endpoint,omitempty"`
+	KubernetesEvent bool   `json:"kubernetes_event,omitempty"`
+}
+
+type buildMetrics struct {
+	Image        string        `json:"image"`
+	Build        string        `json:"build"`
+	QueueSeconds float64       `json:"queueSeconds"`
+	TotalSeconds float64       `json:"totalSeconds"`
+	Steps        []stepMetrics `json:"steps"`
+}
+
+type stepMetrics struct {
+	Name    string  `json:"name"`
+	Seconds float64 `json:"seconds"`
+}
+
+// newBuildMetrics measures the time build spent waiting for its first step,
+// in each step and in total.
+func newBuildMetrics(build v1alpha2.Build) buildMetrics {
+	metrics := buildMetrics{
+		Image: build.Labels[v1alpha2.ImageLabel],
+		Build: build.Name,
+		Steps: []stepMetrics{},
+	}
+
+	created := build.CreationTimestamp.Time
+	var firstStarted, lastFinished time.Time
+
+	// kpack only names the steps that completed, in the order of their
+	// states, so a failed or skipped step leaves the names after it shifted.
+	completed := build.Status.StepsCompleted
+	for i, state := range build.Status.StepStates {
+		name := fmt.Sprintf("step-%d", i)
+		if state.Terminated != nil && state.Terminated.ExitCode == 0 && len(completed) > 0 {
+			name, completed = completed[0], completed[1:]
+		}
+
+		var started, finished time.Time
+		switch {
+		case state.Terminated != nil:
+			started, finished = state.Terminated.StartedAt.Time, state.Terminated.FinishedAt.Time
+		case state.Running != nil:
+			started = state.Running.StartedAt.Time
+		}
+
+		if !started.IsZero() && (firstStarted.IsZero() || started.Before(firstStarted)) {
+			firstStarted = started
+		}
+		if finished.After(lastFinished) {
+			lastFinished = finished
+		}
+
+		if !started.IsZero() && !finished.IsZero() {
+			metrics.Steps = append(metrics.Steps, stepMetrics{Name: name, Seconds: finished.Sub(started).Seconds()})
+		}
+	}
+
+	if condition := build.Status.GetCondition(corev1alpha1.ConditionSucceeded); condition != nil && condition.Status != corev1.ConditionUnknown {
+		if transitioned := condition.LastTransitionTime.Inner.Time; transitioned.After(lastFinished) {
+			lastFinished = transitioned
+		}
+	}
+
+	if !created.IsZero() && !firstStarted.IsZero() {
+		metrics.QueueSeconds = firstStarted.Sub(created).Seconds()
+	}
+	if !created.IsZero() && !lastFinished.IsZero() {
+		metrics.TotalSeconds = lastFinished.Sub(created).Seconds()
+	}
+	return metrics
+}
+
+// waitedOn reports whether a put waited on build: the put annotated the
+// image and changed its source or configuration, which triggered the build.
+// Builds kpack ran for a new stack or buildpack are only annotated because
+// they inherit the annotations of the image.
+func waitedOn(build v1alpha2.Build) bool {
+	if len(concourseMetadata(build)) == 0 {
+		return false
+	}
+
+	for _, reason := range strings.Split(build.Annotations[v1alpha2.BuildReasonAnnotation], ",") {
+		if reason == v1alpha2.BuildReasonCommit || reason == v1alpha2.BuildReasonConfig {
+			return true
+		}
+	}
+	return false
+}
+
+func writeMetrics(outDir string, build v1alpha2.Build) error {
+	contents, err := json.MarshalIndent(newBuildMetrics(build), "", "  ")
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(filepath.Join(outDir, metricsFile), contents, 0644)
+}
+
+// reportBuild reports the metrics of the build that produced the image and
+// records an event on the image. Failures are logged as warnings so that a
+// metrics outage does not fail the put.
+func (o *Out) reportBuild(ctx context.Context, kpackClient KpackClient, src Source, name, latestImage string, env oc.Environment, log Logger) {
+	builds, err := listImageBuilds(ctx, kpackClient, Source{Image: name, Namespace: src.Namespace})
+	if err != nil {
+		log.Warnf("Could not list builds of image '%s' for metrics: %s\n", name, err)
+		return
+	}
+
+	index, ok := indexOfBuild(builds, oc.Version{"image": latestImage})
+	if !ok {
+		log.Warnf("Could not find the build that produced %s for metrics.\n", latestImage)
+		return
+	}
+	build := builds[index]
+	metrics := newBuildMetrics(build)
+	concourse := newConcourseBuild(env)
+
+	if src.Metrics.PushgatewayURL != "" {
+		if err := pushGateway(ctx, *src.Metrics, src.Namespace, metrics, concourse); err != nil {
+			log.Warnf("Could not push metrics to pushgateway: %s\n", err)
+		}
+	}
+
+	if src.Metrics.OTLPEndpoint != "" {
+		if err := pushOTLP(ctx, src.Metrics.OTLPEndpoint, src.Namespace, metrics, concourse); err != nil {
+			log.Warnf("Could not push metrics to otlp endpoint: %s\n", err)
+		}
+	}
+
+	if src.Metrics.KubernetesEvent {
+		if err := o.recordEvent(ctx, kpackClient, src.Namespace, name, latestImage, metrics, concourse); err != nil {
+			log.Warnf("Could not record event on image '%s': %s\n", name, err)
+		}
+	}
+
+	log.Debugf("Build '%s' queued for %.0fs and took %.0fs.\n", build.Name, metrics.QueueSeconds, metrics.TotalSeconds)
+}
+
+// pushGateway replaces the metrics of the image in the pushgateway group
+// job/<job>/namespace/<namespace>/image/<image>.
+func pushGateway(ctx context.Context, config MetricsConfig, namespace string, metrics buildMetrics, concourse concourseBuild) error {
+	job := config.PushgatewayJob
+	if job == "" {
+		job = defaultPushgatewayJob
+	}
+
+	labels := fmt.Sprintf(`build=%q,pipeline=%q,concourse_job=%q`, metrics.Build, concourse.Pipeline, concourse.Job)
+
+	var body strings.Builder
+	body.WriteString("# TYPE kpack_build_queue_seconds gauge\n")
+	fmt.Fprintf(&body, "kpack_build_queue_seconds{%s} %s\n", labels, formatSeconds(metrics.QueueSeconds))
+	body.WriteString("# TYPE kpack_build_duration_seconds gauge\n")
+	fmt.Fprintf(&body, "kpack_build_duration_seconds{%s} %s\n", labels, formatSeconds(metrics.TotalSeconds))
+	body.WriteString("# TYPE kpack_build_step_duration_seconds gauge\n")
+	for _, step := range metrics.Steps {
+		fmt.Fprintf(&body, "kpack_build_step_duration_seconds{%s,step=%q} %s\n", labels, step.Name, formatSeconds(step.Seconds))
+	}
+
+	endpoint := fmt.Sprintf("%s/metrics/job/%s/namespace/%s/image/%s",
+		strings.TrimSuffix(config.PushgatewayURL, "/"), url.PathEscape(job), url.PathEscape(namespace), url.PathEscape(metrics.Image))
+
+	return send(ctx, http.MethodPut, endpoint, "text/plain; version=0.0.4", []byte(body.String()))
+}
+
+// pushOTLP sends the metrics as gauges to an OTLP/HTTP endpoint in the JSON
+// encoding.
+func pushOTLP(ctx context.Context, endpoint, namespace string, metrics buildMetrics, concourse concourseBuild) error {
+	timestamp := strconv.FormatInt(time.Now().UnixNano(), 10)
+
+	attributes := otlpAttributes(map[string]string{
+		"k8s.namespace.name":  namespace,
+		"kpack.image":         metrics.Image,
+		"kpack.build":         metrics.Build,
+		"concourse.pipeline":  concourse.Pipeline,
+		"concourse.job":       concourse.Job,
+		"concourse.build.url": concourse.URL(),
+	})
+
+	gauge := func(name string, seconds float64, attributes []map[string]interface{}) map[string]interface{} {
+		return map[string]interface{}{
+			"name": name,
+			"unit": "s",
+			"gauge": map[string]interface{}{
+				"dataPoints": []map[string]interface{}{
+					{"asDouble": seconds, "timeUnixNano": timestamp, "attributes": attributes},
+				},
+			},
+		}
+	}
+
+	otlpMetrics := []map[string]interface{}{
+		gauge("kpack.build.queue", metrics.QueueSeconds, attributes),
+		gauge("kpack.build.duration", metrics.TotalSeconds, attributes),
+	}
+	for _, step := range metrics.Steps {
+		stepAttributes := append(otlpAttributes(map[string]string{"kpack.build.step": step.Name}), attributes...)
+		otlpMetrics = append(otlpMetrics, gauge("kpack.build.step.duration", step.Seconds, stepAttributes))
+	}
+
+	body, err := json.Marshal(map[string]interface{}{
+		"resourceMetrics": []map[string]interface{}{{
+			"resource": map[string]interface{}{
+				"attributes": otlpAttributes(map[string]string{"service.name": eventComponent}),
+			},
+			"scopeMetrics": []map[string]interface{}{{
+				"scope":   map[string]string{"name": eventComponent},
+				"metrics": otlpMetrics,
+			}},
+		}},
+	})
+	if err != nil {
+		return err
+	}
+
+	return send(ctx, http.MethodPost, strings.TrimSuffix(endpoint, "/")+"/v1/metrics", "application/json", body)
+}
+
+func otlpAttributes(values map[string]string) []map[string]interface{} {
+	keys := make([]string, 0, len(values))
+	for key, value := range values {
+		if value != "" {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+
+	attributes := make([]map[string]interface{}, 0, len(keys))
+	for _, key := range keys {
+		attributes = append(attributes, map[string]interface{}{
+			"key":   key,
+			"value": map[string]string{"stringValue": values[key]},
+		})
+	}
+	return attributes
+}
+
+// recordEvent records an event on the image linking the build kpack ran to
+// the Concourse build that waited on it.
+func (o *Out) recordEvent(ctx context.Context, kpackClient KpackClient, namespace, name, latestImage string, metrics buildMetrics, concourse concourseBuild) error {
+	if o.KubeClient == nil {
+		return errors.Errorf("no kubernetes client")
+	}
+
+	image, err := kpackClient.GetImage(ctx, namespace, name)
+	if err != nil {
+		return err
+	}
+
+	message := fmt.Sprintf("Build %s produced %s in %s", metrics.Build, latestImage, time.Duration(metrics.TotalSeconds*float64(time.Second)))
+	if url := concourse.URL(); url != "" {
+		message += " for Concourse build " + url
+	}
+
+	now := metav1.Now()
+	_, err = o.KubeClient.CoreV1().Events(namespace).Create(ctx, &corev1.Event{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      fmt.Sprintf("%s.%x", name, now.UnixNano()),
+			Namespace: namespace,
+		},
+		InvolvedObject: corev1.ObjectReference{
+			APIVersion: v1alpha2.SchemeGroupVersion.String(),
+			Kind:       v1alpha2.ImageKind,
+			Name:       image.Name,
+			Namespace:  image.Namespace,
+			UID:        image.UID,
+		},
+		Reason:         "ConcourseBuild",
+		Message:        message,
+		Type:           corev1.EventTypeNormal,
+		Source:         corev1.EventSource{Component: eventComponent},
+		FirstTimestamp: now,
+		LastTimestamp:  now,
+		Count:          1,
+	}, metav1.CreateOptions{})
+	return err
+}
+
+func formatSeconds(seconds float64) string {
+	return strconv.FormatFloat(seconds, 'f', -1, 64)
+}
+
+func send(ctx context.Context, method, url, contentType string, body []byte) error {
+	req, err := http.NewRequestWithContext(ctx, method, url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", contentType)
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		message, _ := ioutil.ReadAll(resp.Body)
+		return errors.Errorf("%s returned %s: %s", url, resp.Status, strings.TrimSpace(string(message)))
+	}
+	return nil
+}
@@ -0,0 +1,339 @@
+// Copyright 2020-Present VMware, Inc.
+// SPDX-License-Identifier: Apache-2.0
+
+package resource_test
+
+import (
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	oc "github.com/cloudboss/ofcourse/ofcourse"
+	"github.com/pivotal/kpack/pkg/apis/build/v1alpha2"
+	corev1alpha1 "github.com/pivotal/kpack/pkg/apis/core/v1alpha1"
+	"github.com/sclevine/spec"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	corev1 "k8s.io/api/core/v1"
+	v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	k8sfake "k8s.io/client-go/kubernetes/fake"
+	clientgotesting "k8s.io/client-go/testing"
+
+	"github.com/pivotal/concourse-kpack-resource/resource"
+)
+
+func TestMetrics(t *testing.T) {
+	spec.Run(t, "TestMetrics", testMetrics)
+}
+
+func testMetrics(t *testing.T, when spec.G, it spec.S) {
+	const (
+		namespace   = "test-namespace"
+		latestImage = "gcr.io/app@sha256:1234"
+	)
+
+	var (
+		dir   string
+		image *v1alpha2.Image
+		build *v1alpha2.Build
+	)
+
+	created := time.Date(2026, 10, 1, 12, 0, 0, 0, time.UTC)
+	at := func(seconds int) v1.Time {
+		return v1.NewTime(created.Add(time.Duration(seconds) * time.Second))
+	}
+
+	it.Before(func() {
+		var err error
+		dir, err = ioutil.TempDir("", "metrics_test")
+		require.NoError(t, err)
+
+		err = ioutil.WriteFile(filepath.Join(dir, "commitish"), []byte("new-revision"), 0644)
+		require.NoError(t, err)
+
+		image = &v1alpha2.Image{
+			ObjectMeta: v1.ObjectMeta{
+				Name:      "app",
+				Namespace: namespace,
+				UID:       "image-uid",
+			},
+			Spec: v1alpha2.ImageSpec{
+				Tag: "gcr.io/app",
+				Source: corev1alpha1.SourceConfig{
+					Git: &corev1alpha1.Git{
+						URL:      "https://some.git.com",
+						Revision: "old-revision",
+					},
+				},
+			},
+		}
+
+		build = &v1alpha2.Build{
+			ObjectMeta: v1.ObjectMeta{
+				Name:              "app-build-1",
+				Namespace:         namespace,
+				CreationTimestamp: at(0),
+				Labels: map[string]string{
+					v1alpha2.ImageLabel:       "app",
+					v1alpha2.BuildNumberLabel: "1",
+				},
+			},
+			Status: v1alpha2.BuildStatus{
+				Status: corev1alpha1.Status{
+					Conditions: corev1alpha1.Conditions{
+						{
+							Type:               corev1alpha1.ConditionSucceeded,
+							Status:             corev1.ConditionTrue,
+							LastTransitionTime: corev1alpha1.VolatileTime{Inner: at(100)},
+						},
+					},
+				},
+				StepsCompleted: []string{"prepare", "build"},
+				StepStates: []corev1.ContainerState{
+					{Terminated: &corev1.ContainerStateTerminated{StartedAt: at(15), FinishedAt: at(20)}},
+					{Terminated: &corev1.ContainerStateTerminated{StartedAt: at(20), FinishedAt: at(95)}},
+				},
+				LatestImage: latestImage,
+			},
+		}
+	})
+
+	it.After(func() {
+		os.RemoveAll(dir)
+	})
+
+	it("writes metrics.json on get of a build a put waited on", func() {
+		build.Annotations = map[string]string{
+			v1alpha2.BuildReasonAnnotation: "CONFIG,STACK",
+			resource.BuildIDAnnotation:     "42",
+		}
+
+		InTest{
+			Objects:         []runtime.Object{build},
+			OutDir:          dir,
+			Source:          resource.Source{Image: "app", Namespace: namespace},
+			Version:         oc.Version{"image": latestImage},
+			ExpectedVersion: oc.Version{"image": latestImage},
+			ExpectedMetadata: oc.Metadata{
+				{Name: "buildNumber", Value: "1"},
+				{Name: "buildName", Value: "app-build-1"},
+				{Name: "buildReason", Value: "CONFIG,STACK"},
+				{Name: "concourseBuildId", Value: "42"},
+			},
+		}.test(t)
+
+		contents, err := ioutil.ReadFile(filepath.Join(dir, "metrics.json"))
+		require.NoError(t, err)
+		assert.JSONEq(t, `{
+			"image": "app",
+			"build": "app-build-1",
+			"queueSeconds": 15,
+			"totalSeconds": 100,
+			"steps": [
+				{"name": "prepare", "seconds": 5},
+				{"name": "build", "seconds": 75}
+			]
+		}`, string(contents))
+	})
+
+	it("does not write metrics.json on get of a build no put waited on", func() {
+		build.Annotations = map[string]string{
+			v1alpha2.BuildReasonAnnotation: "STACK",
+			resource.BuildIDAnnotation:     "42",
+		}
+
+		InTest{
+			Objects:         []runtime.Object{build},
+			OutDir:          dir,
+			Source:          resource.Source{Image: "app", Namespace: namespace},
+			Version:         oc.Version{"image": latestImage},
+			ExpectedVersion: oc.Version{"image": latestImage},
+			ExpectedMetadata: oc.Metadata{
+				{Name: "buildNumber", Value: "1"},
+				{Name: "buildName", Value: "app-build-1"},
+				{Name: "buildReason", Value: "STACK"},
+				{Name: "concourseBuildId", Value: "42"},
+			},
+		}.test(t)
+
+		assert.NoFileExists(t, filepath.Join(dir, "metrics.json"))
+	})
+
+	it("names the steps that completed after a step that did not", func() {
+		build.Annotations = map[string]string{
+			v1alpha2.BuildReasonAnnotation: "COMMIT",
+			resource.BuildIDAnnotation:     "42",
+		}
+		build.Status.StepsCompleted = []string{"build"}
+		build.Status.StepStates[0].Terminated.ExitCode = 1
+
+		InTest{
+			Objects:         []runtime.Object{build},
+			OutDir:          dir,
+			Source:          resource.Source{Image: "app", Namespace: namespace},
+			Version:         oc.Version{"image": latestImage},
+			ExpectedVersion: oc.Version{"image": latestImage},
+			ExpectedMetadata: oc.Metadata{
+				{Name: "buildNumber", Value: "1"},
+				{Name: "buildName", Value: "app-build-1"},
+				{Name: "buildReason", Value: "COMMIT"},
+				{Name: "concourseBuildId", Value: "42"},
+			},
+		}.test(t)
+
+		contents, err := ioutil.ReadFile(filepath.Join(dir, "metrics.json"))
+		require.NoError(t, err)
+		assert.JSONEq(t, `{
+			"image": "app",
+			"build": "app-build-1",
+			"queueSeconds": 15,
+			"totalSeconds": 100,
+			"steps": [
+				{"name": "step-0", "seconds": 5},
+				{"name": "build", "seconds": 75}
+			]
+		}`, string(contents))
+	})
+
+	when("metrics are configured", func() {
+		var (
+			requests map[string]string
+			server   *httptest.Server
+		)
+
+		it.Before(func() {
+			requests = map[string]string{}
+			server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				body, err := ioutil.ReadAll(r.Body)
+				require.NoError(t, err)
+				requests[r.Method+" "+r.URL.Path] = string(body)
+			}))
+		})
+
+		it.After(func() {
+			server.Close()
+		})
+
+		put := func(metrics *resource.MetricsConfig, kubeClient *k8sfake.Clientset, expectedOutput ...string) {
+			updatedImage := image.DeepCopy()
+			updatedImage.Spec.Source.Git.Revision = "new-revision"
+			updatedImage.Annotations = map[string]string{
+				"concourse.ci/build-url":       "https://ci.example.com/teams/main/pipelines/app/jobs/build/builds/42",
+				"concourse.ci/team":            "main",
+				"concourse.ci/pipeline":        "app",
+				"concourse.ci/job":             "build",
+				"concourse.ci/build-name":      "42",
+				"concourse.ci/trigger-version": "new-revision",
+			}
+
+			OutTest{
+				InDir:      dir,
+				Objects:    []runtime.Object{image, build},
+				KubeClient: kubeClient,
+				Environment: map[string]string{
+					"ATC_EXTERNAL_URL":    "https://ci.example.com",
+					"BUILD_TEAM_NAME":     "main",
+					"BUILD_PIPELINE_NAME": "app",
+					"BUILD_JOB_NAME":      "build",
+					"BUILD_NAME":          "42",
+				},
+				Source: resource.Source{
+					Image:     image.Name,
+					Namespace: namespace,
+					Metrics:   metrics,
+				},
+				Parameters:    resource.OutParams{Commitish: "commitish"},
+				TerminalImage: latestImage,
+				ExpectUpdates: []clientgotesting.UpdateActionImpl{
+					{Object: updatedImage},
+				},
+				ExpectedImageToWaitOn: updatedImage,
+				ExpectedOutput:        expectedOutput,
+				ExpectedVersion: oc.Version{
+					"image":  latestImage,
+					"digest": "sha256:1234",
+				},
+			}.test(t)
+		}
+
+		it("pushes the build durations to a pushgateway", func() {
+			put(&resource.MetricsConfig{PushgatewayURL: server.URL}, nil)
+
+			labels := `build="app-build-1",pipeline="app",concourse_job="build"`
+			assert.Equal(t, "# TYPE kpack_build_queue_seconds gauge\n"+
+				"kpack_build_queue_seconds{"+labels+"} 15\n"+
+				"# TYPE kpack_build_duration_seconds gauge\n"+
+				"kpack_build_duration_seconds{"+labels+"} 100\n"+
+				"# TYPE kpack_build_step_duration_seconds gauge\n"+
+				"kpack_build_step_duration_seconds{"+labels+`,step="prepare"} 5`+"\n"+
+				"kpack_build_step_duration_seconds{"+labels+`,step="build"} 75`+"\n",
+				requests["PUT /metrics/job/concourse_kpack_resource/namespace/test-namespace/image/app"])
+		})
+
+		it("pushes the build durations to an otlp endpoint", func() {
+			put(&resource.MetricsConfig{OTLPEndpoint: server.URL}, nil)
+
+			var payload struct {
+				ResourceMetrics []struct {
+					ScopeMetrics []struct {
+						Metrics []struct {
+							Name  string `json:"name"`
+							Gauge struct {
+								DataPoints []struct {
+									AsDouble float64 `json:"asDouble"`
+								} `json:"dataPoints"`
+							} `json:"gauge"`
+						} `json:"metrics"`
+					} `json:"scopeMetrics"`
+				} `json:"resourceMetrics"`
+			}
+			require.NoError(t, json.Unmarshal([]byte(requests["POST /v1/metrics"]), &payload))
+
+			values := map[string][]float64{}
+			for _, metric := range payload.ResourceMetrics[0].ScopeMetrics[0].Metrics {
+				for _, point := range metric.Gauge.DataPoints {
+					values[metric.Name] = append(values[metric.Name], point.AsDouble)
+				}
+			}
+			assert.Equal(t, map[string][]float64{
+				"kpack.build.queue":         {15},
+				"kpack.build.duration":      {100},
+				"kpack.build.step.duration": {5, 75},
+			}, values)
+		})
+
+		it("records an event on the image linking to the concourse build", func() {
+			kubeClient := k8sfake.NewSimpleClientset()
+			put(&resource.MetricsConfig{KubernetesEvent: true}, kubeClient)
+
+			events, err := kubeClient.CoreV1().Events(namespace).List(context.TODO(), v1.ListOptions{})
+			require.NoError(t, err)
+			require.Len(t, events.Items, 1)
+
+			event := events.Items[0]
+			assert.Equal(t, corev1.ObjectReference{
+				APIVersion: "kpack.io/v1alpha2",
+				Kind:       "Image",
+				Name:       "app",
+				Namespace:  namespace,
+				UID:        "image-uid",
+			}, event.InvolvedObject)
+			assert.Equal(t, "ConcourseBuild", event.Reason)
+			assert.Equal(t, corev1.EventTypeNormal, event.Type)
+			assert.Equal(t, "Build app-build-1 produced "+latestImage+" in 1m40s for Concourse build https://ci.example.com/teams/main/pipelines/app/jobs/build/builds/42", event.Message)
+		})
+
+		it("warns without failing the put when metrics cannot be pushed", func() {
+			server.Close()
+
+			put(&resource.MetricsConfig{PushgatewayURL: server.URL}, nil, "Could not push metrics to pushgateway")
+		})
+	})
+}
@@ -13,95 +13,296 @@ import (
 	"strings"
 
 	oc "github.com/cloudboss/ofcourse/ofcourse"
-	"github.com/pivotal/kpack/pkg/apis/build/v1alpha1"
-	corev1alpha1 "github.com/pivotal/kpack/pkg/apis/core/v1alpha1"
+	"github.com/google/go-cmp/cmp"
+	"github.com/google/go-containerregistry/pkg/name"
+	"github.com/pivotal/kpack/pkg/apis/build/v1alpha2"
 	"github.com/pivotal/kpack/pkg/client/clientset/versioned"
 	"github.com/pkg/errors"
 	k8serrors "k8s.io/apimachinery/pkg/api/errors"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
 )
 
 type Out struct {
 	Clientset   versioned.Interface
+	KubeClient  kubernetes.Interface
 	ImageWaiter ImageWaiter
+	// LogWriter receives build logs, defaulting to stderr.
+	LogWriter io.Writer
 }
 
 type ImageWaiter interface {
-	Wait(ctx context.Context, writer io.Writer, image *v1alpha1.Image) (string, error)
+	Wait(ctx context.Context, writer io.Writer, image *v1alpha2.Image) (string, error)
 }
 
 func (o *Out) Out(ctx context.Context, inDir string, src Source, params OutParams, env oc.Environment, log Logger) (oc.Version, oc.Metadata, error) {
-	image, err := o.Clientset.KpackV1alpha1().Images(src.Namespace).Get(ctx, src.Image, metav1.GetOptions{})
-	if err != nil && !k8serrors.IsNotFound(err) {
+	if timeout := params.timeout(); timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, timeout)
+		defer cancel()
+	}
+
+	kpackClient, err := NewKpackClient(ctx, o.Clientset)
+	if err != nil {
 		return nil, nil, err
+	}
+
+	if params.DryRun && src.resourceKind() != KindImage {
+		return nil, nil, errors.Errorf("dry_run is not supported for kind '%s'", src.resourceKind())
+	}
+
+	if params.Promote != nil {
+		if src.resourceKind() != KindImage {
+			return nil, nil, errors.Errorf("promote is not supported for kind '%s'", src.resourceKind())
+		}
+		return o.promote(ctx, inDir, src, *params.Promote, log)
+	}
+
+	switch kind := src.resourceKind(); kind {
+	case KindImage:
+	case KindBuilder, KindClusterBuilder:
+		return o.outBuilder(ctx, kpackClient, inDir, src, params, log)
+	case KindClusterStack:
+		return o.outClusterStack(ctx, kpackClient, inDir, src, params, log)
+	case KindClusterStore:
+		return o.outClusterStore(ctx, kpackClient, inDir, src, params, log)
+	default:
+		return nil, nil, errors.Errorf("unsupported kind '%s'", kind)
+	}
+
+	if images, selector := imageTargets(src, params); len(images) > 0 || selector != "" {
+		fanOut := src
+		fanOut.Image, fanOut.Images, fanOut.LabelSelector = "", images, selector
+		return o.outImages(ctx, kpackClient, inDir, fanOut, params, env, log)
+	}
+
+	resultingImage, metadata, err := o.outImage(ctx, kpackClient, inDir, src, src.Image, params, env, log, o.logWriter())
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return imageVersion(resultingImage), metadata, nil
+}
+
+func (o *Out) logWriter() io.Writer {
+	if o.LogWriter == nil {
+		return os.Stderr
+	}
+	return o.LogWriter
+}
+
+// outImage updates a single image, waits for kpack to build it, reports
+// the build's metrics and signs the result if requested.
+func (o *Out) outImage(ctx context.Context, kpackClient KpackClient, inDir string, src Source, name string, params OutParams, env oc.Environment, log Logger, writer io.Writer) (string, oc.Metadata, error) {
+	namespace := src.Namespace
+	image, err := kpackClient.GetImage(ctx, namespace, name)
+	if err != nil && !k8serrors.IsNotFound(err) {
+		return "", nil, err
 	} else if k8serrors.IsNotFound(err) {
-		return nil, nil, errors.Errorf("image '%s' in namespace '%s' does not exist. Please create it first.", src.Image, src.Namespace)
+		return "", nil, errors.Errorf("image '%s' in namespace '%s' does not exist. Please create it first.", name, namespace)
 	}
 
+	current := image.DeepCopy()
+
 	image, err = updateImage(image, inDir, params, log)
 	if err != nil {
-		return nil, nil, err
+		return "", nil, err
+	}
+
+	var rollbackTo string
+	if params.RollbackTo != "" {
+		rollbackTo, err = rollbackImage(ctx, kpackClient, inDir, image, params, log)
+		if err != nil {
+			return "", nil, err
+		}
+	}
+
+	annotateImage(image, newConcourseBuild(env))
+
+	if params.DryRun {
+		latestImage, err := dryRunImage(ctx, kpackClient, current, image, log)
+		return latestImage, nil, err
 	}
 
-	image, err = o.Clientset.KpackV1alpha1().Images(src.Namespace).Update(ctx, image, metav1.UpdateOptions{})
+	image, err = kpackClient.UpdateImage(ctx, image, metav1.UpdateOptions{})
 	if err != nil {
-		return nil, nil, err
+		return "", nil, err
 	}
 
 	log.Infof(purple("Waiting on kpack to process update...\n\n"))
-	resultingImage, err := o.ImageWaiter.Wait(context.Background(), os.Stderr, image)
+	resultingImage, err := o.ImageWaiter.Wait(ctx, writer, image)
+	if err != nil && ctx.Err() == context.DeadlineExceeded {
+		return "", nil, errors.Errorf("timed out waiting on kpack to build image '%s'", name)
+	} else if err != nil {
+		return "", nil, err
+	}
+
+	if rollbackTo != "" {
+		reportRollback(log, rollbackTo, resultingImage)
+	}
+
+	if src.Metrics != nil {
+		o.reportBuild(ctx, kpackClient, src, name, resultingImage, env, log)
+	}
+
+	if params.Sign == nil {
+		return resultingImage, nil, nil
+	}
+
+	metadata, err := o.sign(ctx, namespace, resultingImage, *params.Sign, log)
 	if err != nil {
-		return nil, nil, err
+		return "", nil, errors.Wrapf(err, "signing %s", resultingImage)
+	}
+	return resultingImage, metadata, nil
+}
+
+// dryRunImage validates the update server side without persisting it and
+// returns the current latest image, failing for an image that never built.
+func dryRunImage(ctx context.Context, kpackClient KpackClient, current, image *v1alpha2.Image, log Logger) (string, error) {
+	validated, err := kpackClient.UpdateImage(ctx, image, metav1.UpdateOptions{
+		DryRun: []string{metav1.DryRunAll},
+	})
+	if err != nil {
+		return "", err
 	}
 
-	return oc.Version{"image": resultingImage}, nil, nil
+	diff := cmp.Diff(current.Spec, validated.Spec)
+	if diff == "" {
+		log.Infof(purple("Dry run: no changes to image '%s'.\n"), image.Name)
+	} else {
+		log.Infof(purple("Dry run: image '%s' would change (-current +updated):\n")+"%s\n", image.Name, diff)
+	}
+
+	if current.Status.LatestImage == "" {
+		return "", errors.Errorf("image '%s' has no latest image; dry_run cannot produce a version", image.Name)
+	}
+	return current.Status.LatestImage, nil
 }
 
-func updateImage(image *v1alpha1.Image, inDir string, params OutParams, log Logger) (*v1alpha1.Image, error) {
-	if params.BlobUrlFile == "" && params.Commitish == "" {
-		return nil, errors.Errorf("either commitish or blob_url_file is required")
+func updateImage(image *v1alpha2.Image, inDir string, params OutParams, log Logger) (*v1alpha2.Image, error) {
+	if params.Commitish == "" && params.GitUrl == "" && params.BlobUrlFile == "" && params.BlobStripComponents == nil && params.SubPath == nil &&
+		params.Tag == "" && params.AdditionalTags == nil && params.AdditionalTagsFile == "" && params.RollbackTo == "" {
+		return nil, errors.Errorf("one of commitish, git_url, blob_url_file, blob_strip_components, sub_path, tag, additional_tags, additional_tags_file or rollback_to is required")
 	}
 
-	switch {
-	case params.Commitish != "":
+	source := &image.Spec.Source
+
+	if params.Commitish != "" || params.GitUrl != "" {
+		if source.Git == nil {
+			return nil, errors.Errorf("image '%s' is not configured to use a git source", image.Name)
+		}
+	}
+
+	if params.BlobUrlFile != "" || params.BlobStripComponents != nil {
+		if source.Blob == nil {
+			return nil, errors.Errorf("image '%s' is not configured to use a blob source", image.Name)
+		}
+	}
+
+	log.Infof("Updating image '%s' in namespace '%s'.\n", image.Name, image.Namespace)
+
+	if params.GitUrl != "" {
+		log.Infof("Previous git url: %s\nNew git url: %s\n\n", red(source.Git.URL), green(params.GitUrl))
+		source.Git.URL = params.GitUrl
+	}
+
+	if params.Commitish != "" {
 		fileContents, err := ioutil.ReadFile(filepath.Join(inDir, params.Commitish))
 		if err != nil {
 			return nil, errors.Wrapf(err, "reading commitish: %s", params.Commitish)
 		}
 		commit := strings.TrimSpace(string(fileContents))
 
-		if image.Spec.Source.Git == nil {
-			return nil, errors.Errorf("image '%s' is not configured to use a git source", image.Name)
-		}
-
-		log.Infof("Updating image '%s' in namespace '%s'.\nPrevious revision: %s\nNew revision: %s\n\n",
-			image.Name, image.Namespace, red(image.Spec.Source.Git.Revision), green(commit))
+		log.Infof("Previous revision: %s\nNew revision: %s\n\n", red(source.Git.Revision), green(commit))
+		source.Git.Revision = commit
+	}
 
-		image.Spec.Source.Git.Revision = commit
-	case params.BlobUrlFile != "":
+	if params.BlobUrlFile != "" {
 		fileContents, err := ioutil.ReadFile(filepath.Join(inDir, params.BlobUrlFile))
 		if err != nil {
 			return nil, errors.Wrapf(err, "reading blobUrl: %s", params.BlobUrlFile)
 		}
 		blobUrl := strings.TrimSpace(string(fileContents))
 
-		if image.Spec.Source.Blob == nil {
-			return nil, errors.Errorf("image '%s' is not configured to use a blob source", image.Name)
-		}
+		log.Infof("Previous blobUrl: %s\nNew blobUrl: %s\n\n", red(source.Blob.URL), green(blobUrl))
+		source.Blob.URL = blobUrl
+	}
+
+	if params.BlobStripComponents != nil {
+		log.Infof("Previous blob stripComponents: %s\nNew blob stripComponents: %s\n\n", red(source.Blob.StripComponents), green(*params.BlobStripComponents))
+		source.Blob.StripComponents = *params.BlobStripComponents
+	}
 
-		log.Infof("Updating image '%s' in namespace '%s'.\nPrevious blobUrl: %s\nNew blobUrl: %s\n\n",
-			image.Name, image.Namespace, red(image.Spec.Source.Blob.URL), green(blobUrl))
+	if params.SubPath != nil {
+		log.Infof("Previous subPath: %s\nNew subPath: %s\n\n", red(source.SubPath), green(*params.SubPath))
+		source.SubPath = *params.SubPath
+	}
 
-		image.Spec.Source.Blob = &corev1alpha1.Blob{
-			URL: blobUrl,
+	if params.Tag != "" || params.AdditionalTags != nil || params.AdditionalTagsFile != "" {
+		tags, err := additionalTags(image.Spec, inDir, params)
+		if err != nil {
+			return nil, err
 		}
+
+		log.Infof("Previous additionalTags: %s\nNew additionalTags: %s\n\n", red(strings.Join(image.Spec.AdditionalTags, ", ")), green(strings.Join(tags, ", ")))
+		image.Spec.AdditionalTags = tags
 	}
+
 	return image, nil
 }
 
+// additionalTags returns the tags from params and the whitespace separated
+// tags in additional_tags_file, or the current additional tags of the image
+// when neither is set, followed by the tag param. kpack rejects changes to
+// the image tag, so the tag param is exported as an additional tag. Tags
+// that are not a reference with a registry and tag, such as v1.4.2, are
+// qualified with the repository of the image tag.
+func additionalTags(spec v1alpha2.ImageSpec, inDir string, params OutParams) ([]string, error) {
+	var tags []string
+	if params.AdditionalTags == nil && params.AdditionalTagsFile == "" {
+		tags = append(tags, spec.AdditionalTags...)
+	}
+	tags = append(tags, params.AdditionalTags...)
+
+	if params.AdditionalTagsFile != "" {
+		fileContents, err := ioutil.ReadFile(filepath.Join(inDir, params.AdditionalTagsFile))
+		if err != nil {
+			return nil, errors.Wrapf(err, "reading additional tags: %s", params.AdditionalTagsFile)
+		}
+		tags = append(tags, strings.Fields(string(fileContents))...)
+	}
+
+	if params.Tag != "" {
+		tags = append(tags, params.Tag)
+	}
+
+	imageTag := spec.Tag
+	seen := map[string]bool{imageTag: true}
+	if ref, err := name.NewTag(imageTag, name.WeakValidation); err == nil {
+		seen[ref.String()] = true
+	}
+	qualified := make([]string, 0, len(tags))
+	for _, tag := range tags {
+		if _, err := name.NewTag(tag, name.StrictValidation); err != nil {
+			// Not a reference with a registry and tag, so a bare tag.
+			ref := imageRepository(imageTag) + ":" + tag
+			if _, err := name.NewTag(ref, name.WeakValidation); err != nil {
+				return nil, errors.Errorf("invalid additional tag '%s'", tag)
+			}
+			tag = ref
+		}
+		if !seen[tag] {
+			seen[tag] = true
+			qualified = append(qualified, tag)
+		}
+	}
+	return qualified, nil
+}
+
 var (
 	red    = color("\033[1;31m%s\033[0m")
 	green  = color("\033[1;32m%s\033[0m")
+	yellow = color("\033[1;33m%s\033[0m")
 	purple = color("\033[1;34m%s\033[0m")
 )
 
@@ -4,23 +4,168 @@
 package resource
 
 import (
-	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
 
 	oc "github.com/cloudboss/ofcourse/ofcourse"
+
+	"github.com/pivotal/concourse-kpack-resource/config"
 )
 
 func NewOutParams(ocParams oc.Params) (OutParams, error) {
-	marshal, err := json.Marshal(ocParams)
-	if err != nil {
-		return OutParams{}, err
-	}
-
 	outParams := OutParams{}
-	err = json.Unmarshal(marshal, &outParams)
+	err := config.Decode("params", ocParams, &outParams)
 	return outParams, err
 }
 
 type OutParams struct {
 	Commitish   string `json:"commitish,omitempty"`
 	BlobUrlFile string `json:"blob_url_file,omitempty"`
+	OrderFile   string `json:"order_file,omitempty"`
+	Stack       string `json:"stack,omitempty"`
+
+	GitUrl              string  `json:"git_url,omitempty"`
+	SubPath             *string `json:"sub_path,omitempty"`
+	BlobStripComponents *int64  `json:"blob_strip_components,omitempty"`
+
+	Tag                string   `json:"tag,omitempty"`
+	AdditionalTags     []string `json:"additional_tags,omitempty"`
+	AdditionalTagsFile string   `json:"additional_tags_file,omitempty"`
+
+	BuildImageFile string `json:"build_image_file,omitempty"`
+	RunImageFile   string `json:"run_image_file,omitempty"`
+
+	BuildpackagesFile   string `json:"buildpackages_file,omitempty"`
+	BuildpackagesAction string `json:"buildpackages_action,omitempty"`
+
+	Images        []string `json:"images,omitempty"`
+	LabelSelector string   `json:"label_selector,omitempty"`
+	Parallelism   int      `json:"parallelism,omitempty"`
+
+	DryRun     bool   `json:"dry_run,omitempty"`
+	RollbackTo string `json:"rollback_to,omitempty"`
+	Timeout    string `json:"timeout,omitempty"`
+
+	Promote *PromoteParams `json:"promote,omitempty"`
+	Sign    *SignParams    `json:"sign,omitempty"`
+}
+
+type PromoteParams struct {
+	ImageFile  string `json:"image_file"`
+	Repository string `json:"repository"`
+	Tag        string `json:"tag,omitempty"`
+	RegistryCredentials
+}
+
+type SignParams struct {
+	Key         string         `json:"key,omitempty"`
+	KeyPassword string         `json:"key_password,omitempty"`
+	Keyless     *KeylessParams `json:"keyless,omitempty"`
+	RekorURL    string         `json:"rekor_url,omitempty"`
+	RegistryCredentials
+}
+
+// rekorURL is the transparency log the signature is recorded in, if any.
+func (p SignParams) rekorURL() string {
+	if p.Keyless != nil {
+		return p.Keyless.RekorURL
+	}
+	return p.RekorURL
+}
+
+type KeylessParams struct {
+	FulcioURL     string `json:"fulcio_url"`
+	RekorURL      string `json:"rekor_url"`
+	IdentityToken string `json:"identity_token"`
+}
+
+// Validate reports params that cannot be combined and missing or
+// unsupported values.
+func (p OutParams) Validate() []string {
+	var problems []string
+
+	var sources []string
+	for _, group := range []struct {
+		set  bool
+		keys string
+	}{
+		{p.Commitish != "" || p.GitUrl != "", "'commitish' or 'git_url'"},
+		{p.BlobUrlFile != "" || p.BlobStripComponents != nil, "'blob_url_file' or 'blob_strip_components'"},
+		{p.RollbackTo != "", "'rollback_to'"},
+		{p.Promote != nil, "'promote'"},
+	} {
+		if group.set {
+			sources = append(sources, group.keys)
+		}
+	}
+	if len(sources) > 1 {
+		problems = append(problems, fmt.Sprintf("%s cannot be combined", strings.Join(sources, " with ")))
+	}
+
+	// Each kind of resource needs one of these; which one is checked once
+	// the kind is known.
+	if p.Commitish == "" && p.GitUrl == "" && p.BlobUrlFile == "" && p.BlobStripComponents == nil && p.SubPath == nil &&
+		p.Tag == "" && p.AdditionalTags == nil && p.AdditionalTagsFile == "" && p.RollbackTo == "" && p.Promote == nil &&
+		p.OrderFile == "" && p.Stack == "" && p.BuildImageFile == "" && p.RunImageFile == "" && p.BuildpackagesFile == "" {
+		problems = append(problems, "one of 'commitish', 'git_url', 'blob_url_file', 'blob_strip_components', 'sub_path', 'tag', 'additional_tags', "+
+			"'additional_tags_file', 'rollback_to', 'promote', 'order_file', 'stack', 'build_image_file', 'run_image_file' or 'buildpackages_file' is required")
+	}
+
+	if p.RollbackTo != "" && p.SubPath != nil {
+		problems = append(problems, "'rollback_to' cannot be combined with 'sub_path'")
+	}
+
+	if p.Timeout != "" {
+		if timeout, err := time.ParseDuration(p.Timeout); err != nil || timeout <= 0 {
+			problems = append(problems, fmt.Sprintf("'timeout' must be a positive duration such as '30m', got '%s'", p.Timeout))
+		}
+	}
+
+	if p.Parallelism < 0 {
+		problems = append(problems, "'parallelism' must not be negative")
+	}
+
+	switch strings.ToLower(p.BuildpackagesAction) {
+	case "", buildpackagesAppend, buildpackagesReplace, buildpackagesRemove:
+	default:
+		problems = append(problems, fmt.Sprintf("unsupported buildpackages_action '%s'", p.BuildpackagesAction))
+	}
+
+	if p.Promote != nil {
+		if p.Promote.ImageFile == "" {
+			problems = append(problems, "missing required key 'promote.image_file'")
+		}
+		if p.Promote.Repository == "" {
+			problems = append(problems, "missing required key 'promote.repository'")
+		}
+	}
+
+	if p.Sign != nil {
+		if (p.Sign.Key == "") == (p.Sign.Keyless == nil) {
+			problems = append(problems, "exactly one of 'sign.key' or 'sign.keyless' is required")
+		}
+		if p.Sign.Keyless != nil && p.Sign.RekorURL != "" {
+			problems = append(problems, "'sign.rekor_url' cannot be combined with 'sign.keyless', use 'sign.keyless.rekor_url'")
+		}
+		if p.Sign.Keyless != nil {
+			for _, required := range []struct{ key, value string }{
+				{"fulcio_url", p.Sign.Keyless.FulcioURL},
+				{"rekor_url", p.Sign.Keyless.RekorURL},
+				{"identity_token", p.Sign.Keyless.IdentityToken},
+			} {
+				if required.value == "" {
+					problems = append(problems, fmt.Sprintf("missing required key 'sign.keyless.%s'", required.key))
+				}
+			}
+		}
+	}
+
+	return problems
+}
+
+// timeout is how long a put waits on kpack, or zero to wait indefinitely.
+func (p OutParams) timeout() time.Duration {
+	timeout, _ := time.ParseDuration(p.Timeout)
+	return timeout
 }
@@ -9,10 +9,12 @@ import (
 	"io/ioutil"
 	"os"
 	"path/filepath"
+	"sync"
 	"testing"
 
 	oc "github.com/cloudboss/ofcourse/ofcourse"
 	"github.com/pivotal/kpack/pkg/apis/build/v1alpha1"
+	"github.com/pivotal/kpack/pkg/apis/build/v1alpha2"
 	corev1alpha1 "github.com/pivotal/kpack/pkg/apis/core/v1alpha1"
 	"github.com/pivotal/kpack/pkg/client/clientset/versioned/fake"
 	"github.com/sclevine/spec"
@@ -20,6 +22,7 @@ import (
 	"github.com/stretchr/testify/require"
 	v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/runtime"
+	k8sfake "k8s.io/client-go/kubernetes/fake"
 	clientgotesting "k8s.io/client-go/testing"
 
 	"github.com/pivotal/concourse-kpack-resource/resource"
@@ -48,12 +51,12 @@ func testOut(t *testing.T, when spec.G, it spec.S) {
 		const commitishPath = "some-commit-file"
 
 		var (
-			image = &v1alpha1.Image{
+			image = &v1alpha2.Image{
 				ObjectMeta: v1.ObjectMeta{
 					Name:      "test",
 					Namespace: "test-namespace",
 				},
-				Spec: v1alpha1.ImageSpec{
+				Spec: v1alpha2.ImageSpec{
 					Source: corev1alpha1.SourceConfig{
 						Git: &corev1alpha1.Git{
 							URL:      "https://some.git.com",
@@ -98,13 +101,54 @@ func testOut(t *testing.T, when spec.G, it spec.S) {
 					},
 				},
 				ExpectedVersion: oc.Version{
-					"image": "some.reg.io/image@sha256:1234567",
+					"image":  "some.reg.io/image@sha256:1234567",
+					"digest": "sha256:1234567",
 				},
 				ExpectedImageToWaitOn: updatedImage,
 			}.test(t)
 
 		})
 
+		it("updates the git url and sub path", func() {
+			image.Spec.Source.SubPath = "old-path"
+
+			updatedImage := image.DeepCopy()
+			updatedImage.Spec.Source.Git.URL = "https://other.git.com"
+			updatedImage.Spec.Source.SubPath = ""
+
+			subPath := ""
+			OutTest{
+				InDir: inDir,
+				Objects: []runtime.Object{
+					image,
+				},
+				Source: resource.Source{
+					Image:     image.Name,
+					Namespace: image.Namespace,
+				},
+				Parameters: resource.OutParams{
+					GitUrl:  "https://other.git.com",
+					SubPath: &subPath,
+				},
+				TerminalImage: "some.reg.io/image@sha256:1234567",
+				ExpectedOutput: []string{
+					"Previous git url", "https://some.git.com",
+					"New git url:", "https://other.git.com",
+					"Previous subPath", "old-path",
+				},
+				ExpectUpdates: []clientgotesting.UpdateActionImpl{
+					{
+						Object: updatedImage,
+					},
+				},
+				ExpectedVersion: oc.Version{
+					"image":  "some.reg.io/image@sha256:1234567",
+					"digest": "sha256:1234567",
+				},
+				ExpectedImageToWaitOn: updatedImage,
+			}.test(t)
+		})
+
 		it("returns error is image does not have a git source", func() {
 			image.Spec.Source.Git = nil
 			OutTest{
@@ -146,15 +190,16 @@ func testOut(t *testing.T, when spec.G, it spec.S) {
 		const blobUrlPath = "some-blob-url-file"
 
 		var (
-			image = &v1alpha1.Image{
+			image = &v1alpha2.Image{
 				ObjectMeta: v1.ObjectMeta{
 					Name:      "test",
 					Namespace: "test-namespace",
 				},
-				Spec: v1alpha1.ImageSpec{
+				Spec: v1alpha2.ImageSpec{
 					Source: corev1alpha1.SourceConfig{
 						Blob: &corev1alpha1.Blob{
-							URL: "https://old-blob-url.com",
+							URL:             "https://old-blob-url.com",
+							StripComponents: 1,
 						},
 					},
 				},
@@ -195,13 +240,49 @@ func testOut(t *testing.T, when spec.G, it spec.S) {
 					},
 				},
 				ExpectedVersion: oc.Version{
-					"image": "some.reg.io/image@sha256:1234567",
+					"image":  "some.reg.io/image@sha256:1234567",
+					"digest": "sha256:1234567",
 				},
 				ExpectedImageToWaitOn: updatedImage,
 			}.test(t)
 
 		})
 
+		it("updates blob stripComponents", func() {
+			updatedImage := image.DeepCopy()
+			updatedImage.Spec.Source.Blob.StripComponents = 2
+
+			stripComponents := int64(2)
+			OutTest{
+				InDir: inDir,
+				Objects: []runtime.Object{
+					image,
+				},
+				Source: resource.Source{
+					Image:     image.Name,
+					Namespace: image.Namespace,
+				},
+				Parameters: resource.OutParams{
+					BlobStripComponents: &stripComponents,
+				},
+				TerminalImage: "some.reg.io/image@sha256:1234567",
+				ExpectedOutput: []string{
+					"Previous blob stripComponents", "1",
+					"New blob stripComponents:", "2",
+				},
+				ExpectUpdates: []clientgotesting.UpdateActionImpl{
+					{
+						Object: updatedImage,
+					},
+				},
+				ExpectedVersion: oc.Version{
+					"image":  "some.reg.io/image@sha256:1234567",
+					"digest": "sha256:1234567",
+				},
+				ExpectedImageToWaitOn: updatedImage,
+			}.test(t)
+		})
+
 		it("returns error is image does not have a blob source", func() {
 			image.Spec.Source.Blob = nil
 			OutTest{
@@ -221,13 +302,325 @@ func testOut(t *testing.T, when spec.G, it spec.S) {
 		})
 	})
 
+	when("updating tags", func() {
+		var (
+			image = &v1alpha2.Image{
+				ObjectMeta: v1.ObjectMeta{
+					Name:      "test",
+					Namespace: "test-namespace",
+				},
+				Spec: v1alpha2.ImageSpec{
+					Tag:            "some.reg.io/image",
+					AdditionalTags: []string{"some.reg.io/image:v1.4.1"},
+					Source: corev1alpha1.SourceConfig{
+						Git: &corev1alpha1.Git{
+							URL:      "https://some.git.com",
+							Revision: "oldrevision",
+						},
+					},
+				},
+			}
+		)
+
+		it("replaces the additional tags and qualifies bare tags", func() {
+			err := ioutil.WriteFile(filepath.Join(inDir, "tags"), []byte("v1.4.2\nabc123  v1.4.2\n"), 0644)
+			require.NoError(t, err)
+
+			updatedImage := image.DeepCopy()
+			updatedImage.Spec.AdditionalTags = []string{
+				"some.reg.io/other:latest",
+				"some.reg.io/image:v1.4.2",
+				"some.reg.io/image:abc123",
+				"some.reg.io/image:release",
+			}
+
+			OutTest{
+				InDir: inDir,
+				Objects: []runtime.Object{
+					image,
+				},
+				Source: resource.Source{
+					Image:     image.Name,
+					Namespace: image.Namespace,
+				},
+				Parameters: resource.OutParams{
+					Tag:                "release",
+					AdditionalTags:     []string{"some.reg.io/other:latest"},
+					AdditionalTagsFile: "tags",
+				},
+				TerminalImage: "some.reg.io/image@sha256:1234567",
+				ExpectedOutput: []string{
+					"Previous additionalTags", "some.reg.io/image:v1.4.1",
+					"New additionalTags", "some.reg.io/image:v1.4.2",
+				},
+				ExpectUpdates: []clientgotesting.UpdateActionImpl{
+					{
+						Object: updatedImage,
+					},
+				},
+				ExpectedVersion: oc.Version{
+					"image":  "some.reg.io/image@sha256:1234567",
+					"digest": "sha256:1234567",
+				},
+				ExpectedImageToWaitOn: updatedImage,
+			}.test(t)
+		})
+
+		it("adds the tag to the additional tags without changing the image tag", func() {
+			updatedImage := image.DeepCopy()
+			updatedImage.Spec.AdditionalTags = []string{
+				"some.reg.io/image:v1.4.1",
+				"some.reg.io/image:v1.5.0",
+			}
+
+			OutTest{
+				InDir: inDir,
+				Objects: []runtime.Object{
+					image,
+				},
+				Source: resource.Source{
+					Image:     image.Name,
+					Namespace: image.Namespace,
+				},
+				Parameters: resource.OutParams{
+					Tag: "v1.5.0",
+				},
+				TerminalImage: "some.reg.io/image@sha256:1234567",
+				ExpectUpdates: []clientgotesting.UpdateActionImpl{
+					{
+						Object: updatedImage,
+					},
+				},
+				ExpectedVersion: oc.Version{
+					"image":  "some.reg.io/image@sha256:1234567",
+					"digest": "sha256:1234567",
+				},
+				ExpectedImageToWaitOn: updatedImage,
+			}.test(t)
+		})
+
+		it("clears the additional tags with an empty list", func() {
+			updatedImage := image.DeepCopy()
+			updatedImage.Spec.AdditionalTags = []string{}
+
+			OutTest{
+				InDir: inDir,
+				Objects: []runtime.Object{
+					image,
+				},
+				Source: resource.Source{
+					Image:     image.Name,
+					Namespace: image.Namespace,
+				},
+				Parameters: resource.OutParams{
+					AdditionalTags: []string{},
+				},
+				TerminalImage: "some.reg.io/image@sha256:1234567",
+				ExpectUpdates: []clientgotesting.UpdateActionImpl{
+					{
+						Object: updatedImage,
+					},
+				},
+				ExpectedVersion: oc.Version{
+					"image":  "some.reg.io/image@sha256:1234567",
+					"digest": "sha256:1234567",
+				},
+				ExpectedImageToWaitOn: updatedImage,
+			}.test(t)
+		})
+
+		it("returns an error for an invalid tag", func() {
+			OutTest{
+				InDir: inDir,
+				Objects: []runtime.Object{
+					image,
+				},
+				Source: resource.Source{
+					Image:     image.Name,
+					Namespace: image.Namespace,
+				},
+				Parameters: resource.OutParams{
+					AdditionalTags: []string{"app:v1.4.2"},
+				},
+				ExpectError: "invalid additional tag 'app:v1.4.2'",
+			}.test(t)
+		})
+	})
+
+	when("dry running", func() {
+		const commitishPath = "some-commit-file"
+
+		var (
+			image = &v1alpha2.Image{
+				ObjectMeta: v1.ObjectMeta{
+					Name:      "test",
+					Namespace: "test-namespace",
+				},
+				Spec: v1alpha2.ImageSpec{
+					Source: corev1alpha1.SourceConfig{
+						Git: &corev1alpha1.Git{
+							URL:      "https://some.git.com",
+							Revision: "oldrevision",
+						},
+					},
+				},
+				Status: v1alpha2.ImageStatus{
+					LatestImage: "some.reg.io/image@sha256:current",
+				},
+			}
+		)
+
+		it.Before(func() {
+			err := ioutil.WriteFile(filepath.Join(inDir, commitishPath), []byte("new-commit\n"), 0644)
+			require.NoError(t, err)
+		})
+
+		it("validates the update, prints the diff and returns the current image without waiting", func() {
+			updatedImage := image.DeepCopy()
+			updatedImage.Spec.Source.Git.Revision = "new-commit"
+
+			OutTest{
+				InDir: inDir,
+				Objects: []runtime.Object{
+					image,
+				},
+				Source: resource.Source{
+					Image:     image.Name,
+					Namespace: image.Namespace,
+				},
+				Parameters: resource.OutParams{
+					Commitish: commitishPath,
+					DryRun:    true,
+				},
+				ExpectedOutput: []string{
+					"Dry run: image 'test' would change (-current +updated)",
+					`-`, `"oldrevision"`,
+					`+`, `"new-commit"`,
+				},
+				ExpectUpdates: []clientgotesting.UpdateActionImpl{
+					{
+						Object: updatedImage,
+					},
+				},
+				ExpectedVersion: oc.Version{
+					"image":  "some.reg.io/image@sha256:current",
+					"digest": "sha256:current",
+				},
+			}.test(t)
+		})
+
+		it("returns an error for an image that has never built", func() {
+			unbuiltImage := image.DeepCopy()
+			unbuiltImage.Status = v1alpha2.ImageStatus{}
+			updatedImage := unbuiltImage.DeepCopy()
+			updatedImage.Spec.Source.Git.Revision = "new-commit"
+
+			OutTest{
+				InDir: inDir,
+				Objects: []runtime.Object{
+					unbuiltImage,
+				},
+				Source: resource.Source{
+					Image:     image.Name,
+					Namespace: image.Namespace,
+				},
+				Parameters: resource.OutParams{
+					Commitish: commitishPath,
+					DryRun:    true,
+				},
+				ExpectUpdates: []clientgotesting.UpdateActionImpl{
+					{
+						Object: updatedImage,
+					},
+				},
+				ExpectError: "image 'test' has no latest image; dry_run cannot produce a version",
+			}.test(t)
+		})
+
+		it("returns an error for kinds other than image", func() {
+			OutTest{
+				InDir: inDir,
+				Source: resource.Source{
+					Kind: "clusterstack",
+					Name: "some-stack",
+				},
+				Parameters: resource.OutParams{
+					BuildImageFile: "build-image",
+					DryRun:         true,
+				},
+				ExpectError: "dry_run is not supported for kind 'clusterstack'",
+			}.test(t)
+		})
+	})
+
+	when("only v1alpha1 is served", func() {
+		it("updates the v1alpha1 image", func() {
+			const commitishPath = "some-commit-file"
+			err := ioutil.WriteFile(filepath.Join(inDir, commitishPath), []byte("new-commit\n"), 0644)
+			require.NoError(t, err)
+
+			image := &v1alpha1.Image{
+				ObjectMeta: v1.ObjectMeta{
+					Name:      "test",
+					Namespace: "test-namespace",
+				},
+				Spec: v1alpha1.ImageSpec{
+					Source: corev1alpha1.SourceConfig{
+						Git: &corev1alpha1.Git{
+							URL:      "https://some.git.com",
+							Revision: "oldrevision",
+						},
+					},
+				},
+			}
+
+			updatedImage := image.DeepCopy()
+			updatedImage.Spec.Source.Git.Revision = "new-commit"
+
+			OutTest{
+				KpackVersion: "v1alpha1",
+				InDir:        inDir,
+				Objects: []runtime.Object{
+					image,
+				},
+				Source: resource.Source{
+					Image:     image.Name,
+					Namespace: image.Namespace,
+				},
+				Parameters: resource.OutParams{
+					Commitish: commitishPath,
+				},
+				TerminalImage: "some.reg.io/image@sha256:1234567",
+				ExpectUpdates: []clientgotesting.UpdateActionImpl{
+					{
+						Object: updatedImage,
+					},
+				},
+				ExpectedVersion: oc.Version{
+					"image":  "some.reg.io/image@sha256:1234567",
+					"digest": "sha256:1234567",
+				},
+				ExpectedImageToWaitOn: &v1alpha2.Image{
+					ObjectMeta: v1.ObjectMeta{
+						Name:        "test",
+						Namespace:   "test-namespace",
+						Annotations: map[string]string{},
+					},
+					Spec: v1alpha2.ImageSpec{
+						Source: updatedImage.Spec.Source,
+					},
+				},
+			}.test(t)
+		})
+	})
+
 	it("returns error if no put parameter is set", func() {
-		image := &v1alpha1.Image{
+		image := &v1alpha2.Image{
 			ObjectMeta: v1.ObjectMeta{
 				Name:      "test",
 				Namespace: "test-namespace",
 			},
-			Spec: v1alpha1.ImageSpec{
+			Spec: v1alpha2.ImageSpec{
 				Source: corev1alpha1.SourceConfig{
 					Blob: &corev1alpha1.Blob{
 						URL: "https://old-blob-url.com",
@@ -248,44 +641,67 @@ func testOut(t *testing.T, when spec.G, it spec.S) {
 				Commitish:   "",
 				BlobUrlFile: "",
 			},
-			ExpectError: "either commitish or blob_url_file is required",
+			ExpectError: "one of commitish, git_url, blob_url_file, blob_strip_components, sub_path, tag, additional_tags, additional_tags_file or rollback_to is required",
 		}.test(t)
 	})
 }
 
 type OutTest struct {
-	Objects       []runtime.Object
+	KpackVersion string
+	Objects      []runtime.Object
+	KubeObjects  []runtime.Object
+	// KubeClient replaces the client built from KubeObjects so tests can
+	// inspect what was created with it.
+	KubeClient    *k8sfake.Clientset
+	Environment   map[string]string
 	InDir         string
 	Source        resource.Source
 	Parameters    resource.OutParams
 	TerminalImage string
 	TerminalError error
-
-	ExpectedOutput        []string
-	ExpectedImageToWaitOn *v1alpha1.Image
-	ExpectUpdates         []clientgotesting.UpdateActionImpl
-	ExpectCreates         []runtime.Object
-	ExpectedVersion       oc.Version
-	ExpectedMetadata      oc.Metadata
-	ExpectError           string
+	// TerminalImages and TerminalErrors override the result per image name.
+	TerminalImages map[string]string
+	TerminalErrors map[string]error
+
+	ExpectedOutput         []string
+	ExpectedImageToWaitOn  *v1alpha2.Image
+	ExpectedImagesToWaitOn []*v1alpha2.Image
+	ExpectUpdates          []clientgotesting.UpdateActionImpl
+	ExpectCreates          []runtime.Object
+	ExpectedVersion        oc.Version
+	ExpectedMetadata       oc.Metadata
+	ExpectError            string
 }
 
 func (b OutTest) test(t *testing.T) {
 	t.Helper()
 	client := fake.NewSimpleClientset(b.Objects...)
+	client.Resources = testhelpers.KpackDiscovery(b.KpackVersion)
 
 	testLog := &testhelpers.Logger{}
 
 	waiter := &TestImageWaiter{
-		terminalImage: b.TerminalImage,
-		error:         b.TerminalError,
+		terminalImage:  b.TerminalImage,
+		error:          b.TerminalError,
+		terminalImages: b.TerminalImages,
+		errors:         b.TerminalErrors,
+	}
+	kubeClient := b.KubeClient
+	if kubeClient == nil {
+		kubeClient = k8sfake.NewSimpleClientset(b.KubeObjects...)
 	}
 	out := resource.Out{
 		Clientset:   client,
+		KubeClient:  kubeClient,
 		ImageWaiter: waiter,
 	}
 
-	version, metadata, err := out.Out(context.TODO(), b.InDir, b.Source, b.Parameters, nil, testLog)
+	var env oc.Environment
+	if b.Environment != nil {
+		env = oc.NewEnvironment(b.Environment)
+	}
+
+	version, metadata, err := out.Out(context.TODO(), b.InDir, b.Source, b.Parameters, env, testLog)
 	if b.ExpectError == "" {
 		require.NoError(t, err)
 	} else {
@@ -297,7 +713,11 @@ func (b OutTest) test(t *testing.T) {
 
 	testhelpers.TestUpdatesAndCreates(t, client, b.ExpectUpdates, b.ExpectCreates)
 
-	assert.Equal(t, b.ExpectedImageToWaitOn, waiter.waitedOnImage, "unexpected image was waited on")
+	if b.ExpectedImagesToWaitOn != nil {
+		assert.ElementsMatch(t, b.ExpectedImagesToWaitOn, waiter.waitedOnImages, "unexpected images were waited on")
+	} else {
+		assert.Equal(t, b.ExpectedImageToWaitOn, waiter.waitedOnImage, "unexpected image was waited on")
+	}
 
 	for _, o := range b.ExpectedOutput {
 		assert.Contains(t, testLog.Out.String(), o)
@@ -305,17 +725,33 @@ func (b OutTest) test(t *testing.T) {
 }
 
 type TestImageWaiter struct {
-	waitedOnImage *v1alpha1.Image
-	terminalImage string
-	error         error
+	mu             sync.Mutex
+	waitedOnImage  *v1alpha2.Image
+	waitedOnImages []*v1alpha2.Image
+	terminalImage  string
+	error          error
+	terminalImages map[string]string
+	errors         map[string]error
 }
 
-func (w *TestImageWaiter) Wait(ctx context.Context, writer io.Writer, image *v1alpha1.Image) (string, error) {
+func (w *TestImageWaiter) Wait(ctx context.Context, writer io.Writer, image *v1alpha2.Image) (string, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
 	w.waitedOnImage = image
+	w.waitedOnImages = append(w.waitedOnImages, image)
+
+	if err, ok := w.errors[image.Name]; ok {
+		return "", err
+	}
 
 	if w.error != nil {
 		return "", w.error
 	}
 
+	if terminalImage, ok := w.terminalImages[image.Name]; ok {
+		return terminalImage, nil
+	}
+
 	return w.terminalImage, nil
 }
@@ -0,0 +1,142 @@
+// Copyright 2020-Present VMware, Inc.
+// SPDX-License-Identifier: Apache-2.0
+
+package resource
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/google/go-containerregistry/pkg/name"
+	"github.com/google/go-containerregistry/pkg/v1/remote"
+	"github.com/pivotal/kpack/pkg/apis/build/v1alpha2"
+	"github.com/pkg/errors"
+)
+
+const lifecycleMetadataLabel = "io.buildpacks.lifecycle.metadata"
+
+// checkPolicy fails with every violation of params by the image and the
+// build that produced it.
+func (in *In) checkPolicy(ctx context.Context, namespace, image string, build v1alpha2.Build, params PolicyParams, log Logger) error {
+	var violations []string
+
+	if len(params.AllowedStacks) > 0 && !contains(params.AllowedStacks, build.Status.Stack.ID) {
+		violations = append(violations, fmt.Sprintf("stack '%s' is not in allowed_stacks", build.Status.Stack.ID))
+	}
+
+	for _, buildpack := range build.Status.BuildMetadata {
+		if contains(params.DeniedBuildpacks, buildpack.Id) || contains(params.DeniedBuildpacks, buildpack.Id+"@"+buildpack.Version) {
+			violations = append(violations, fmt.Sprintf("buildpack '%s@%s' is in denied_buildpacks", buildpack.Id, buildpack.Version))
+		}
+	}
+
+	if params.MaxRunImageAgeDays > 0 {
+		violation, err := in.checkRunImageAge(ctx, namespace, build.Status.Stack.RunImage, params)
+		if err != nil {
+			return err
+		}
+		if violation != "" {
+			violations = append(violations, violation)
+		}
+	}
+
+	if params.RequireSBOM {
+		hasSBOM, err := in.hasSBOM(ctx, namespace, image, params.RegistryCredentials)
+		if err != nil {
+			return err
+		}
+		if !hasSBOM {
+			violations = append(violations, "image has no SBOM")
+		}
+	}
+
+	if len(violations) > 0 {
+		return errors.Errorf("image '%s' violates policy:\n  - %s", image, strings.Join(violations, "\n  - "))
+	}
+
+	log.Infof("Image %s satisfies policy.\n", image)
+	return nil
+}
+
+func (in *In) checkRunImageAge(ctx context.Context, namespace, runImage string, params PolicyParams) (string, error) {
+	if runImage == "" {
+		return "build has no run image", nil
+	}
+
+	ref, err := name.ParseReference(runImage)
+	if err != nil {
+		return "", errors.Wrapf(err, "parsing run image: %s", runImage)
+	}
+
+	opts, err := in.remoteOptions(ctx, namespace, ref, params.RegistryCredentials)
+	if err != nil {
+		return "", err
+	}
+
+	img, err := remote.Image(ref, opts...)
+	if err != nil {
+		return "", errors.Wrapf(err, "fetching run image %s", runImage)
+	}
+
+	config, err := img.ConfigFile()
+	if err != nil {
+		return "", errors.Wrapf(err, "reading run image %s", runImage)
+	}
+
+	age := time.Since(config.Created.Time)
+	if age <= time.Duration(params.MaxRunImageAgeDays)*24*time.Hour {
+		return "", nil
+	}
+	return fmt.Sprintf("run image %s is %d days old, more than max_run_image_age_days %d", runImage, int(age.Hours()/24), params.MaxRunImageAgeDays), nil
+}
+
+// hasSBOM reports whether the buildpacks recorded an SBOM layer in the
+// image or an SBOM is attached to it with cosign.
+func (in *In) hasSBOM(ctx context.Context, namespace, image string, creds RegistryCredentials) (bool, error) {
+	digest, err := name.NewDigest(image)
+	if err != nil {
+		return false, errors.Wrapf(err, "parsing image: %s", image)
+	}
+
+	opts, err := in.remoteOptions(ctx, namespace, digest, creds)
+	if err != nil {
+		return false, err
+	}
+
+	img, err := remote.Image(digest, opts...)
+	if err != nil {
+		return false, errors.Wrapf(err, "fetching image %s", image)
+	}
+
+	config, err := img.ConfigFile()
+	if err != nil {
+		return false, errors.Wrapf(err, "reading image %s", image)
+	}
+
+	if label, ok := config.Config.Labels[lifecycleMetadataLabel]; ok {
+		var metadata struct {
+			SBOM *json.RawMessage `json:"sbom"`
+		}
+		if err := json.Unmarshal([]byte(label), &metadata); err == nil && metadata.SBOM != nil {
+			return true, nil
+		}
+	}
+
+	_, err = remote.Head(digest.Context().Tag(cosignTag(digest.DigestStr(), "sbom")), opts...)
+	if isNotFound(err) {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+func contains(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,192 @@
+// Copyright 2020-Present VMware, Inc.
+// SPDX-License-Identifier: Apache-2.0
+
+package resource_test
+
+import (
+	"io/ioutil"
+	"log"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	oc "github.com/cloudboss/ofcourse/ofcourse"
+	"github.com/google/go-containerregistry/pkg/name"
+	"github.com/google/go-containerregistry/pkg/registry"
+	ggcrv1 "github.com/google/go-containerregistry/pkg/v1"
+	"github.com/google/go-containerregistry/pkg/v1/mutate"
+	"github.com/google/go-containerregistry/pkg/v1/random"
+	"github.com/google/go-containerregistry/pkg/v1/remote"
+	"github.com/pivotal/kpack/pkg/apis/build/v1alpha2"
+	corev1alpha1 "github.com/pivotal/kpack/pkg/apis/core/v1alpha1"
+	"github.com/sclevine/spec"
+	"github.com/stretchr/testify/require"
+	corev1 "k8s.io/api/core/v1"
+	v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+
+	"github.com/pivotal/concourse-kpack-resource/resource"
+)
+
+func TestPolicy(t *testing.T) {
+	spec.Run(t, "TestPolicy", testPolicy)
+}
+
+func testPolicy(t *testing.T, when spec.G, it spec.S) {
+	const namespace = "test-namespace"
+
+	var (
+		dir      string
+		server   *httptest.Server
+		host     string
+		built    name.Digest
+		runImage name.Digest
+		build    *v1alpha2.Build
+	)
+
+	pushImage := func(repository string, created time.Time, labels map[string]string) name.Digest {
+		img, err := random.Image(256, 1)
+		require.NoError(t, err)
+
+		img, err = mutate.CreatedAt(img, ggcrv1.Time{Time: created})
+		require.NoError(t, err)
+
+		img, err = mutate.Config(img, ggcrv1.Config{Labels: labels})
+		require.NoError(t, err)
+
+		digest, err := img.Digest()
+		require.NoError(t, err)
+
+		ref, err := name.NewDigest(host + "/" + repository + "@" + digest.String())
+		require.NoError(t, err)
+		require.NoError(t, remote.Write(ref, img))
+		return ref
+	}
+
+	it.Before(func() {
+		var err error
+		dir, err = ioutil.TempDir("", "policy_test")
+		require.NoError(t, err)
+
+		server = httptest.NewServer(registry.New(registry.Logger(log.New(ioutil.Discard, "", 0))))
+		host = strings.TrimPrefix(server.URL, "http://")
+
+		runImage = pushImage("run", time.Now().Add(-45*24*time.Hour), nil)
+		built = pushImage("app", time.Now(), map[string]string{
+			"io.buildpacks.lifecycle.metadata": `{"sbom": null}`,
+		})
+
+		build = &v1alpha2.Build{
+			ObjectMeta: v1.ObjectMeta{
+				Name:      "app-build-1",
+				Namespace: namespace,
+				Labels: map[string]string{
+					v1alpha2.ImageLabel:       "app",
+					v1alpha2.BuildNumberLabel: "1",
+				},
+			},
+			Status: v1alpha2.BuildStatus{
+				Status: corev1alpha1.Status{
+					Conditions: corev1alpha1.Conditions{
+						{Type: corev1alpha1.ConditionSucceeded, Status: corev1.ConditionTrue},
+					},
+				},
+				BuildMetadata: corev1alpha1.BuildpackMetadataList{
+					{Id: "paketo-buildpacks/java", Version: "9.0.0"},
+					{Id: "paketo-buildpacks/node-engine", Version: "1.2.0"},
+				},
+				Stack: corev1alpha1.BuildStack{
+					RunImage: runImage.String(),
+					ID:       "io.buildpacks.stacks.bionic",
+				},
+				LatestImage: built.String(),
+			},
+		}
+	})
+
+	it.After(func() {
+		server.Close()
+		os.RemoveAll(dir)
+	})
+
+	source := resource.Source{
+		Image:     "app",
+		Namespace: namespace,
+	}
+
+	it("lists every violation", func() {
+		InTest{
+			Objects: []runtime.Object{build},
+			OutDir:  dir,
+			Source:  source,
+			Parameters: oc.Params{
+				"policy": map[string]interface{}{
+					"max_run_image_age_days": 30,
+					"allowed_stacks":         []string{"io.buildpacks.stacks.jammy"},
+					"denied_buildpacks":      []string{"paketo-buildpacks/java@9.0.0", "paketo-buildpacks/node-engine"},
+					"require_sbom":           true,
+				},
+			},
+			Version: oc.Version{"image": built.String()},
+			ExpectError: "image '" + built.String() + "' violates policy:\n" +
+				"  - stack 'io.buildpacks.stacks.bionic' is not in allowed_stacks\n" +
+				"  - buildpack 'paketo-buildpacks/java@9.0.0' is in denied_buildpacks\n" +
+				"  - buildpack 'paketo-buildpacks/node-engine@1.2.0' is in denied_buildpacks\n" +
+				"  - run image " + runImage.String() + " is 45 days old, more than max_run_image_age_days 30\n" +
+				"  - image has no SBOM",
+		}.test(t)
+	})
+
+	it("passes an image that satisfies the policy", func() {
+		sbom, err := random.Image(64, 1)
+		require.NoError(t, err)
+		require.NoError(t, remote.Write(built.Context().Tag(strings.Replace(built.DigestStr(), ":", "-", 1)+".sbom"), sbom))
+
+		InTest{
+			Objects: []runtime.Object{build},
+			OutDir:  dir,
+			Source:  source,
+			Parameters: oc.Params{
+				"policy": map[string]interface{}{
+					"max_run_image_age_days": 60,
+					"allowed_stacks":         []string{"io.buildpacks.stacks.bionic"},
+					"denied_buildpacks":      []string{"paketo-buildpacks/java@8.0.0"},
+					"require_sbom":           true,
+				},
+			},
+			Version:         oc.Version{"image": built.String()},
+			ExpectedVersion: oc.Version{"image": built.String()},
+			ExpectedOutput:  "Image " + built.String() + " satisfies policy.\n\n",
+			ExpectedMetadata: oc.Metadata{
+				{Name: "buildNumber", Value: "1"},
+				{Name: "buildName", Value: "app-build-1"},
+				{Name: "buildReason", Value: ""},
+			},
+		}.test(t)
+	})
+
+	it("finds an SBOM recorded by the buildpacks", func() {
+		built = pushImage("app", time.Now(), map[string]string{
+			"io.buildpacks.lifecycle.metadata": `{"sbom": {"sha": "sha256:abc"}}`,
+		})
+		build.Status.LatestImage = built.String()
+
+		InTest{
+			Objects: []runtime.Object{build},
+			OutDir:  dir,
+			Source:  source,
+			Parameters: oc.Params{
+				"policy": map[string]interface{}{"require_sbom": true},
+			},
+			Version:         oc.Version{"image": built.String()},
+			ExpectedVersion: oc.Version{"image": built.String()},
+			ExpectedMetadata: oc.Metadata{
+				{Name: "buildNumber", Value: "1"},
+				{Name: "buildName", Value: "app-build-1"},
+				{Name: "buildReason", Value: ""},
+			},
+		}.test(t)
+	})
+}
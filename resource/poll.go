@@ -0,0 +1,44 @@
+// Copyright 2020-Present VMware, Inc.
+// SPDX-License-Identifier: Apache-2.0
+
+package resource
+
+import (
+	"context"
+	"time"
+
+	corev1alpha1 "github.com/pivotal/kpack/pkg/apis/core/v1alpha1"
+	"github.com/pkg/errors"
+	"k8s.io/apimachinery/pkg/util/wait"
+)
+
+var pollInterval = 2 * time.Second
+
+// pollUntilReady polls a kpack resource without build logs, such as a
+// builder, until it has reconciled generation and is ready or ctx is done.
+func pollUntilReady(ctx context.Context, kind, name string, generation int64, get func(ctx context.Context) (corev1alpha1.Status, error)) error {
+	err := wait.PollImmediateUntilWithContext(ctx, pollInterval, func(ctx context.Context) (bool, error) {
+		status, err := get(ctx)
+		if err != nil {
+			return false, err
+		}
+
+		if status.ObservedGeneration < generation {
+			return false, nil
+		}
+
+		condition := status.GetCondition(corev1alpha1.ConditionReady)
+		if condition.IsFalse() {
+			message := ""
+			if condition.Message != "" {
+				message = ": " + condition.Message
+			}
+			return false, errors.Errorf("update to %s %s failed%s", kind, name, message)
+		}
+		return condition.IsTrue(), nil
+	})
+	if err == wait.ErrWaitTimeout && ctx.Err() == context.DeadlineExceeded {
+		return errors.Errorf("timed out waiting for %s %s to be ready", kind, name)
+	}
+	return err
+}
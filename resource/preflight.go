@@ -0,0 +1,177 @@
+// Copyright 2020-Present VMware, Inc.
+// SPDX-License-Identifier: Apache-2.0
+
+package resource
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/pkg/errors"
+	authorizationv1 "k8s.io/api/authorization/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+)
+
+const preflightRoleName = "concourse-kpack-resource"
+
+// rbacRule is the access the resource needs to a kind of kubernetes object.
+// verbs are needed by check and in, putVerbs only by put.
+type rbacRule struct {
+	group    string
+	resource string
+	verbs    []string
+	putVerbs []string
+}
+
+func (r rbacRule) String() string {
+	if r.group == "" {
+		return r.resource
+	}
+	return r.resource + "." + r.group
+}
+
+// requiredRules lists the rules check, in and out need for the kind and the
+// features configured in source.
+func requiredRules(source Source) []rbacRule {
+	switch source.resourceKind() {
+	case KindBuilder:
+		return []rbacRule{{"kpack.io", "builders", []string{"get"}, []string{"update"}}}
+	case KindClusterBuilder:
+		return []rbacRule{{"kpack.io", "clusterbuilders", []string{"get"}, []string{"update"}}}
+	case KindClusterStack:
+		return []rbacRule{{"kpack.io", "clusterstacks", []string{"get"}, []string{"update"}}}
+	case KindClusterStore:
+		return []rbacRule{{"kpack.io", "clusterstores", []string{"get"}, []string{"update"}}}
+	}
+
+	// A single image is only read through its builds. In gets every tracked
+	// image and a label selector lists them.
+	imageVerbs, putImageVerbs := []string(nil), []string{"get", "watch", "update"}
+	if source.multiImage() {
+		imageVerbs, putImageVerbs = []string{"get"}, []string{"watch", "update"}
+	}
+	if source.LabelSelector != "" {
+		imageVerbs = append(imageVerbs, "list")
+	}
+
+	rules := []rbacRule{
+		{"kpack.io", "images", imageVerbs, putImageVerbs},
+		{"kpack.io", "builds", []string{"list"}, []string{"get", "watch"}},
+		{"", "pods", nil, []string{"list", "watch"}},
+		{"", "pods/log", nil, []string{"get"}},
+	}
+	if source.Metrics != nil && source.Metrics.KubernetesEvent {
+		rules = append(rules, rbacRule{"", "events", nil, []string{"create"}})
+	}
+	return rules
+}
+
+// Preflight checks the credentials in source are allowed everything the
+// resource needs with SelfSubjectAccessReviews. It fails with the missing
+// rules and a Role granting them when check or in would fail, and only
+// warns when a put would. When access cannot be reviewed a warning is
+// logged and the resource carries on.
+func Preflight(ctx context.Context, kubeClient kubernetes.Interface, source Source, log Logger) error {
+	namespace := source.Namespace
+	switch source.resourceKind() {
+	case KindClusterBuilder, KindClusterStack, KindClusterStore:
+		namespace = ""
+	}
+
+	var (
+		missing    []rbacRule
+		checkFails bool
+	)
+	for _, rule := range requiredRules(source) {
+		denied := rbacRule{group: rule.group, resource: rule.resource}
+
+		for i, verb := range append(append([]string{}, rule.verbs...), rule.putVerbs...) {
+			resource, subresource := rule.resource, ""
+			if i := strings.Index(resource, "/"); i >= 0 {
+				resource, subresource = resource[:i], resource[i+1:]
+			}
+
+			review, err := kubeClient.AuthorizationV1().SelfSubjectAccessReviews().Create(ctx, &authorizationv1.SelfSubjectAccessReview{
+				Spec: authorizationv1.SelfSubjectAccessReviewSpec{
+					ResourceAttributes: &authorizationv1.ResourceAttributes{
+						Namespace:   namespace,
+						Verb:        verb,
+						Group:       rule.group,
+						Resource:    resource,
+						Subresource: subresource,
+					},
+				},
+			}, metav1.CreateOptions{})
+			if err != nil {
+				log.Warnf("Skipping preflight, could not review access: %s\n", err)
+				return nil
+			}
+
+			if !review.Status.Allowed {
+				denied.verbs = append(denied.verbs, verb)
+				checkFails = checkFails || i < len(rule.verbs)
+			}
+		}
+
+		if len(denied.verbs) > 0 {
+			missing = append(missing, denied)
+		}
+	}
+
+	if len(missing) == 0 {
+		log.Debugf("Preflight: every permission the resource needs is granted.\n")
+		return nil
+	}
+
+	var lines []string
+	for _, rule := range missing {
+		lines = append(lines, fmt.Sprintf("%s %s", strings.Join(rule.verbs, ", "), rule))
+	}
+
+	scope := fmt.Sprintf("in namespace '%s'", namespace)
+	if namespace == "" {
+		scope = "in the cluster"
+	}
+
+	message := fmt.Sprintf("missing permissions %s:\n  - %s\n\nGrant them by binding the credentials to:\n\n%s",
+		scope, strings.Join(lines, "\n  - "), roleManifest(namespace, missing))
+	if !checkFails {
+		log.Warnf("A put will fail, %s", message)
+		return nil
+	}
+	return errors.New(message)
+}
+
+// roleManifest is a Role, or a ClusterRole for cluster scoped kinds,
+// granting rules.
+func roleManifest(namespace string, rules []rbacRule) string {
+	var manifest strings.Builder
+	manifest.WriteString("apiVersion: rbac.authorization.k8s.io/v1\n")
+	if namespace == "" {
+		manifest.WriteString("kind: ClusterRole\n")
+	} else {
+		manifest.WriteString("kind: Role\n")
+	}
+	manifest.WriteString("metadata:\n")
+	fmt.Fprintf(&manifest, "  name: %s\n", preflightRoleName)
+	if namespace != "" {
+		fmt.Fprintf(&manifest, "  namespace: %s\n", namespace)
+	}
+	manifest.WriteString("rules:\n")
+	for _, rule := range rules {
+		fmt.Fprintf(&manifest, "- apiGroups: [%q]\n", rule.group)
+		fmt.Fprintf(&manifest, "  resources: [%q]\n", rule.resource)
+		fmt.Fprintf(&manifest, "  verbs: [%s]\n", quoteAll(rule.verbs))
+	}
+	return manifest.String()
+}
+
+func quoteAll(values []string) string {
+	quoted := make([]string, 0, len(values))
+	for _, value := range values {
+		quoted = append(quoted, fmt.Sprintf("%q", value))
+	}
+	return strings.Join(quoted, ", ")
+}
@@ -0,0 +1,192 @@
+// Copyright 2020-Present VMware, Inc.
+// SPDX-License-Identifier: Apache-2.0
+
+package resource_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/sclevine/spec"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	authorizationv1 "k8s.io/api/authorization/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	k8sfake "k8s.io/client-go/kubernetes/fake"
+	clientgotesting "k8s.io/client-go/testing"
+
+	"github.com/pivotal/concourse-kpack-resource/resource"
+	"github.com/pivotal/concourse-kpack-resource/resource/testhelpers"
+)
+
+func TestPreflight(t *testing.T) {
+	spec.Run(t, "TestPreflight", testPreflight)
+}
+
+func testPreflight(t *testing.T, when spec.G, it spec.S) {
+	var (
+		kubeClient *k8sfake.Clientset
+		reviewed   []authorizationv1.ResourceAttributes
+		denied     map[string]bool
+		granted    map[string]bool
+		testLog    *testhelpers.Logger
+	)
+
+	it.Before(func() {
+		reviewed = nil
+		denied = map[string]bool{}
+		granted = nil
+		testLog = &testhelpers.Logger{}
+
+		kubeClient = k8sfake.NewSimpleClientset()
+		kubeClient.PrependReactor("create", "selfsubjectaccessreviews", func(action clientgotesting.Action) (bool, runtime.Object, error) {
+			review := action.(clientgotesting.CreateAction).GetObject().(*authorizationv1.SelfSubjectAccessReview)
+			attributes := *review.Spec.ResourceAttributes
+			reviewed = append(reviewed, attributes)
+
+			resource := attributes.Resource
+			if attributes.Subresource != "" {
+				resource += "/" + attributes.Subresource
+			}
+			if granted != nil {
+				review.Status.Allowed = granted[attributes.Verb+" "+resource]
+			} else {
+				review.Status.Allowed = !denied[attributes.Verb+" "+resource]
+			}
+			return true, review, nil
+		})
+	})
+
+	it("passes when every permission is granted", func() {
+		err := resource.Preflight(context.TODO(), kubeClient, resource.Source{Image: "app", Namespace: "apps"}, testLog)
+		require.NoError(t, err)
+
+		assert.Len(t, reviewed, 9)
+		assert.Contains(t, reviewed, authorizationv1.ResourceAttributes{
+			Namespace:   "apps",
+			Verb:        "get",
+			Resource:    "pods",
+			Subresource: "log",
+		})
+		assert.Contains(t, reviewed, authorizationv1.ResourceAttributes{
+			Namespace: "apps",
+			Verb:      "update",
+			Group:     "kpack.io",
+			Resource:  "images",
+		})
+	})
+
+	it("passes a read only role for check and get of a single image with a warning for put", func() {
+		granted = map[string]bool{"list builds": true}
+
+		err := resource.Preflight(context.TODO(), kubeClient, resource.Source{Image: "app", Namespace: "apps"}, testLog)
+		require.NoError(t, err)
+
+		assert.Contains(t, testLog.Out.String(), `A put will fail, missing permissions in namespace 'apps':
+  - get, watch, update images.kpack.io
+  - get, watch builds.kpack.io
+  - list, watch pods
+  - get pods/log
+`)
+	})
+
+	it("requires getting and listing the images a label selector tracks", func() {
+		granted = map[string]bool{"list builds": true, "get images": true}
+
+		err := resource.Preflight(context.TODO(), kubeClient, resource.Source{LabelSelector: "app=shop", Namespace: "apps"}, testLog)
+
+		require.Error(t, err)
+		assert.Contains(t, err.Error(), `missing permissions in namespace 'apps':
+  - list, watch, update images.kpack.io
+`)
+	})
+
+	it("lists the missing rules with a role granting them", func() {
+		denied["update images"] = true
+		denied["list builds"] = true
+		denied["watch builds"] = true
+		denied["get pods/log"] = true
+		denied["create events"] = true
+
+		err := resource.Preflight(context.TODO(), kubeClient, resource.Source{
+			Image:     "app",
+			Namespace: "apps",
+			Metrics:   &resource.MetricsConfig{KubernetesEvent: true},
+		}, testLog)
+
+		require.EqualError(t, err, `missing permissions in namespace 'apps':
+  - update images.kpack.io
+  - list, watch builds.kpack.io
+  - get pods/log
+  - create events
+
+Grant them by binding the credentials to:
+
+apiVersion: rbac.authorization.k8s.io/v1
+kind: Role
+metadata:
+  name: concourse-kpack-resource
+  namespace: apps
+rules:
+- apiGroups: ["kpack.io"]
+  resources: ["images"]
+  verbs: ["update"]
+- apiGroups: ["kpack.io"]
+  resources: ["builds"]
+  verbs: ["list", "watch"]
+- apiGroups: [""]
+  resources: ["pods/log"]
+  verbs: ["get"]
+- apiGroups: [""]
+  resources: ["events"]
+  verbs: ["create"]
+`)
+	})
+
+	it("warns about permissions only a put needs", func() {
+		denied["update images"] = true
+		denied["get pods/log"] = true
+
+		err := resource.Preflight(context.TODO(), kubeClient, resource.Source{Image: "app", Namespace: "apps"}, testLog)
+		require.NoError(t, err)
+
+		assert.Contains(t, testLog.Out.String(), `A put will fail, missing permissions in namespace 'apps':
+  - update images.kpack.io
+  - get pods/log
+`)
+	})
+
+	it("reviews cluster scoped kinds in the cluster", func() {
+		denied["get clusterstacks"] = true
+		denied["update clusterstacks"] = true
+
+		err := resource.Preflight(context.TODO(), kubeClient, resource.Source{Kind: "ClusterStack", Name: "base"}, testLog)
+
+		require.EqualError(t, err, `missing permissions in the cluster:
+  - get, update clusterstacks.kpack.io
+
+Grant them by binding the credentials to:
+
+apiVersion: rbac.authorization.k8s.io/v1
+kind: ClusterRole
+metadata:
+  name: concourse-kpack-resource
+rules:
+- apiGroups: ["kpack.io"]
+  resources: ["clusterstacks"]
+  verbs: ["get", "update"]
+`)
+	})
+
+	it("warns and carries on when access cannot be reviewed", func() {
+		kubeClient.PrependReactor("create", "selfsubjectaccessreviews", func(action clientgotesting.Action) (bool, runtime.Object, error) {
+			return true, nil, errors.New("the server could not find the requested resource")
+		})
+
+		err := resource.Preflight(context.TODO(), kubeClient, resource.Source{Image: "app", Namespace: "apps"}, testLog)
+		require.NoError(t, err)
+
+		assert.Contains(t, testLog.Out.String(), "Skipping preflight, could not review access: the server could not find the requested resource")
+	})
+}
@@ -0,0 +1,109 @@
+// Copyright 2020-Present VMware, Inc.
+// SPDX-License-Identifier: Apache-2.0
+
+package resource
+
+import (
+	"context"
+	"io/ioutil"
+	"net/http"
+	"path/filepath"
+	"strings"
+
+	oc "github.com/cloudboss/ofcourse/ofcourse"
+	"github.com/google/go-containerregistry/pkg/name"
+	"github.com/google/go-containerregistry/pkg/v1/remote"
+	"github.com/google/go-containerregistry/pkg/v1/remote/transport"
+	"github.com/pkg/errors"
+)
+
+// cosignSuffixes are the tag suffixes cosign uses to attach signatures,
+// attestations and SBOMs to a digest.
+var cosignSuffixes = []string{"sig", "att", "sbom"}
+
+// promote copies the digest in the image file, along with its signatures,
+// attestations and SBOMs, to another repository.
+func (o *Out) promote(ctx context.Context, inDir string, src Source, params PromoteParams, log Logger) (oc.Version, oc.Metadata, error) {
+	if params.ImageFile == "" || params.Repository == "" {
+		return nil, nil, errors.Errorf("promote requires image_file and repository")
+	}
+
+	fileContents, err := ioutil.ReadFile(filepath.Join(inDir, params.ImageFile))
+	if err != nil {
+		return nil, nil, errors.Wrapf(err, "reading image_file: %s", params.ImageFile)
+	}
+
+	source, err := name.NewDigest(strings.TrimSpace(string(fileContents)))
+	if err != nil {
+		return nil, nil, errors.Wrapf(err, "parsing image_file: %s", params.ImageFile)
+	}
+
+	target, err := name.NewRepository(params.Repository)
+	if err != nil {
+		return nil, nil, errors.Wrapf(err, "parsing repository: %s", params.Repository)
+	}
+
+	keychain, err := registryKeychain(ctx, o.KubeClient, src.Namespace, target.RegistryStr(), params.RegistryCredentials)
+	if err != nil {
+		return nil, nil, err
+	}
+	opts := []remote.Option{remote.WithContext(ctx), remote.WithAuthFromKeychain(keychain)}
+
+	promoted := target.Digest(source.DigestStr())
+	log.Infof("Promoting %s to %s.\n", source, green(promoted))
+
+	desc, err := copyReference(source, promoted, opts)
+	if err != nil {
+		return nil, nil, errors.Wrapf(err, "copying %s", source)
+	}
+
+	if params.Tag != "" {
+		err = remote.Tag(target.Tag(params.Tag), desc, opts...)
+		if err != nil {
+			return nil, nil, errors.Wrapf(err, "tagging %s", target.Tag(params.Tag))
+		}
+		log.Infof("Tagged %s.\n", target.Tag(params.Tag))
+	}
+
+	for _, suffix := range cosignSuffixes {
+		tag := cosignTag(source.DigestStr(), suffix)
+
+		_, err := copyReference(source.Context().Tag(tag), target.Tag(tag), opts)
+		if isNotFound(err) {
+			continue
+		} else if err != nil {
+			return nil, nil, errors.Wrapf(err, "copying %s", source.Context().Tag(tag))
+		}
+		log.Infof("Copied %s.\n", target.Tag(tag))
+	}
+
+	return imageVersion(promoted.String()), oc.Metadata{
+		{Name: "promotedFrom", Value: source.String()},
+	}, nil
+}
+
+func copyReference(src, dst name.Reference, opts []remote.Option) (*remote.Descriptor, error) {
+	desc, err := remote.Get(src, opts...)
+	if err != nil {
+		return nil, err
+	}
+
+	if desc.MediaType.IsIndex() {
+		index, err := desc.ImageIndex()
+		if err != nil {
+			return nil, err
+		}
+		return desc, remote.WriteIndex(dst, index, opts...)
+	}
+
+	image, err := desc.Image()
+	if err != nil {
+		return nil, err
+	}
+	return desc, remote.Write(dst, image, opts...)
+}
+
+func isNotFound(err error) bool {
+	var transportErr *transport.Error
+	return errors.As(err, &transportErr) && transportErr.StatusCode == http.StatusNotFound
+}
@@ -0,0 +1,171 @@
+// Copyright 2020-Present VMware, Inc.
+// SPDX-License-Identifier: Apache-2.0
+
+package resource_test
+
+import (
+	"io/ioutil"
+	"log"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	oc "github.com/cloudboss/ofcourse/ofcourse"
+	"github.com/google/go-containerregistry/pkg/name"
+	"github.com/google/go-containerregistry/pkg/registry"
+	"github.com/google/go-containerregistry/pkg/v1/random"
+	"github.com/google/go-containerregistry/pkg/v1/remote"
+	"github.com/sclevine/spec"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	corev1 "k8s.io/api/core/v1"
+	v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+
+	"github.com/pivotal/concourse-kpack-resource/resource"
+)
+
+func TestPromote(t *testing.T) {
+	spec.Run(t, "TestPromote", testPromote)
+}
+
+func testPromote(t *testing.T, when spec.G, it spec.S) {
+	var (
+		inDir  string
+		server *httptest.Server
+		host   string
+		source name.Digest
+	)
+
+	it.Before(func() {
+		var err error
+		inDir, err = ioutil.TempDir("", "promote_test")
+		require.NoError(t, err)
+
+		server = httptest.NewServer(registry.New(registry.Logger(log.New(ioutil.Discard, "", 0))))
+		host = strings.TrimPrefix(server.URL, "http://")
+
+		image, err := random.Image(1024, 1)
+		require.NoError(t, err)
+		digest, err := image.Digest()
+		require.NoError(t, err)
+
+		source, err = name.NewDigest(host + "/dev/app@" + digest.String())
+		require.NoError(t, err)
+		require.NoError(t, remote.Write(source, image))
+
+		signature, err := random.Image(256, 1)
+		require.NoError(t, err)
+		require.NoError(t, remote.Write(source.Context().Tag(strings.Replace(digest.String(), ":", "-", 1)+".sig"), signature))
+
+		err = ioutil.WriteFile(filepath.Join(inDir, "image"), []byte(source.String()+"\n"), 0644)
+		require.NoError(t, err)
+	})
+
+	it.After(func() {
+		server.Close()
+		os.RemoveAll(inDir)
+	})
+
+	it("copies the digest, tag and signature to the target repository", func() {
+		promoted := host + "/prod/app@" + source.DigestStr()
+
+		OutTest{
+			InDir: inDir,
+			KubeObjects: []runtime.Object{
+				&corev1.Secret{
+					ObjectMeta: v1.ObjectMeta{Name: "registry-creds", Namespace: "test-namespace"},
+					Type:       corev1.SecretTypeDockerConfigJson,
+					Data: map[string][]byte{
+						corev1.DockerConfigJsonKey: []byte(`{"auths": {"` + host + `": {"username": "user", "password": "pass"}}}`),
+					},
+				},
+			},
+			Source: resource.Source{
+				Image:     "app",
+				Namespace: "test-namespace",
+			},
+			Parameters: resource.OutParams{
+				Promote: &resource.PromoteParams{
+					ImageFile:  "image",
+					Repository: host + "/prod/app",
+					Tag:        "v1.4.2",
+					RegistryCredentials: resource.RegistryCredentials{
+						Secrets: []string{"registry-creds"},
+					},
+				},
+			},
+			ExpectedOutput: []string{
+				"Promoting " + source.String() + " to",
+				"Tagged " + host + "/prod/app:v1.4.2",
+				"Copied " + host + "/prod/app:" + strings.Replace(source.DigestStr(), ":", "-", 1) + ".sig",
+			},
+			ExpectedVersion: oc.Version{
+				"image":  promoted,
+				"digest": source.DigestStr(),
+			},
+			ExpectedMetadata: oc.Metadata{
+				{Name: "promotedFrom", Value: source.String()},
+			},
+		}.test(t)
+
+		target, err := name.NewDigest(promoted)
+		require.NoError(t, err)
+		_, err = remote.Get(target)
+		require.NoError(t, err)
+
+		tagged, err := remote.Get(target.Context().Tag("v1.4.2"))
+		require.NoError(t, err)
+		assert.Equal(t, source.DigestStr(), tagged.Digest.String())
+
+		_, err = remote.Get(target.Context().Tag(strings.Replace(source.DigestStr(), ":", "-", 1) + ".sig"))
+		require.NoError(t, err)
+
+		_, err = remote.Get(target.Context().Tag(strings.Replace(source.DigestStr(), ":", "-", 1) + ".att"))
+		require.Error(t, err)
+	})
+
+	it("returns an error for a secret that is not a docker-registry secret", func() {
+		OutTest{
+			InDir: inDir,
+			KubeObjects: []runtime.Object{
+				&corev1.Secret{
+					ObjectMeta: v1.ObjectMeta{Name: "opaque", Namespace: "test-namespace"},
+					Type:       corev1.SecretTypeOpaque,
+				},
+			},
+			Source: resource.Source{
+				Image:     "app",
+				Namespace: "test-namespace",
+			},
+			Parameters: resource.OutParams{
+				Promote: &resource.PromoteParams{
+					ImageFile:  "image",
+					Repository: host + "/prod/app",
+					RegistryCredentials: resource.RegistryCredentials{
+						Secrets: []string{"opaque"},
+					},
+				},
+			},
+			ExpectError: "secret 'opaque' is not a docker-registry secret",
+		}.test(t)
+	})
+
+	it("returns an error without a repository", func() {
+		OutTest{
+			InDir: inDir,
+			Source: resource.Source{
+				Image:     "app",
+				Namespace: "test-namespace",
+			},
+			Parameters: resource.OutParams{
+				Promote: &resource.PromoteParams{
+					ImageFile: "image",
+				},
+			},
+			ExpectError: "promote requires image_file and repository",
+		}.test(t)
+	})
+}
@@ -0,0 +1,322 @@
+// Copyright 2020-Present VMware, Inc.
+// SPDX-License-Identifier: Apache-2.0
+
+package resource
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"time"
+
+	oc "github.com/cloudboss/ofcourse/ofcourse"
+	"github.com/google/go-containerregistry/pkg/name"
+	"github.com/pivotal/kpack/pkg/apis/build/v1alpha2"
+	corev1alpha1 "github.com/pivotal/kpack/pkg/apis/core/v1alpha1"
+	"github.com/pkg/errors"
+	"github.com/sigstore/cosign/v2/pkg/oci"
+	"github.com/sigstore/cosign/v2/pkg/oci/mutate"
+	ociremote "github.com/sigstore/cosign/v2/pkg/oci/remote"
+	"github.com/sigstore/cosign/v2/pkg/oci/static"
+)
+
+const (
+	provenanceFile = "provenance.json"
+
+	inTotoStatementType = "https://in-toto.io/Statement/v0.1"
+	slsaProvenanceType  = "https://slsa.dev/provenance/v0.2"
+	kpackBuildType      = "https://kpack.io/Build@v1alpha2"
+
+	dsseMediaType     = "application/vnd.dsse.envelope.v1+json"
+	inTotoPayloadType = "application/vnd.in-toto+json"
+)
+
+// provenanceStatement is an in-toto statement with a SLSA v0.2 provenance
+// predicate.
+type provenanceStatement struct {
+	Type          string              `json:"_type"`
+	PredicateType string              `json:"predicateType"`
+	Subject       []provenanceSubject `json:"subject"`
+	Predicate     provenancePredicate `json:"predicate"`
+}
+
+type provenanceSubject struct {
+	Name   string            `json:"name"`
+	Digest map[string]string `json:"digest"`
+}
+
+type provenancePredicate struct {
+	Builder struct {
+		ID string `json:"id"`
+	} `json:"builder"`
+	BuildType  string               `json:"buildType"`
+	Invocation provenanceInvocation `json:"invocation"`
+	Metadata   provenanceMetadata   `json:"metadata"`
+	Materials  []provenanceMaterial `json:"materials"`
+}
+
+type provenanceInvocation struct {
+	ConfigSource provenanceMaterial `json:"configSource"`
+	Parameters   map[string]string  `json:"parameters,omitempty"`
+	Environment  map[string]string  `json:"environment,omitempty"`
+}
+
+type provenanceMetadata struct {
+	BuildInvocationID string `json:"buildInvocationId,omitempty"`
+	BuildStartedOn    string `json:"buildStartedOn,omitempty"`
+	BuildFinishedOn   string `json:"buildFinishedOn,omitempty"`
+	Reproducible      bool   `json:"reproducible"`
+}
+
+type provenanceMaterial struct {
+	URI        string            `json:"uri"`
+	Digest     map[string]string `json:"digest,omitempty"`
+	EntryPoint string            `json:"entryPoint,omitempty"`
+}
+
+// newProvenance describes how build produced image, and the Concourse build
+// that fetched it.
+func newProvenance(image string, build v1alpha2.Build, env oc.Environment) provenanceStatement {
+	statement := provenanceStatement{
+		Type:          inTotoStatementType,
+		PredicateType: slsaProvenanceType,
+		Subject:       []provenanceSubject{imageMaterial(image).subject()},
+	}
+
+	predicate := &statement.Predicate
+	predicate.Builder.ID = build.Spec.Builder.Image
+	predicate.BuildType = kpackBuildType
+
+	source := sourceMaterial(build.Spec.Source)
+	source.EntryPoint = build.Spec.Source.SubPath
+	predicate.Invocation = provenanceInvocation{
+		ConfigSource: source,
+		Parameters: map[string]string{
+			"image":       build.Labels[v1alpha2.ImageLabel],
+			"buildName":   build.Name,
+			"buildReason": build.Annotations[v1alpha2.BuildReasonAnnotation],
+		},
+		Environment: newConcourseBuild(env).environment(),
+	}
+
+	predicate.Metadata = provenanceMetadata{
+		BuildInvocationID: string(build.UID),
+		BuildStartedOn:    formatTime(build.CreationTimestamp.Time),
+	}
+	if condition := build.Status.GetCondition(corev1alpha1.ConditionSucceeded); condition != nil {
+		predicate.Metadata.BuildFinishedOn = formatTime(condition.LastTransitionTime.Inner.Time)
+	}
+
+	predicate.Materials = append(predicate.Materials, sourceMaterial(build.Spec.Source))
+	if build.Spec.Builder.Image != "" {
+		predicate.Materials = append(predicate.Materials, imageMaterial(build.Spec.Builder.Image))
+	}
+	if build.Status.Stack.RunImage != "" {
+		predicate.Materials = append(predicate.Materials, imageMaterial(build.Status.Stack.RunImage))
+	}
+	for _, buildpack := range build.Status.BuildMetadata {
+		predicate.Materials = append(predicate.Materials, provenanceMaterial{
+			URI: fmt.Sprintf("pkg:cnb/%s@%s", buildpack.Id, buildpack.Version),
+		})
+	}
+
+	return statement
+}
+
+func sourceMaterial(source corev1alpha1.SourceConfig) provenanceMaterial {
+	switch {
+	case source.Git != nil:
+		return provenanceMaterial{
+			URI:    "git+" + source.Git.URL,
+			Digest: map[string]string{"sha1": source.Git.Revision},
+		}
+	case source.Blob != nil:
+		return provenanceMaterial{URI: source.Blob.URL}
+	case source.Registry != nil:
+		return imageMaterial(source.Registry.Image)
+	default:
+		return provenanceMaterial{}
+	}
+}
+
+func imageMaterial(image string) provenanceMaterial {
+	repository, digest := splitDigest(image)
+	material := provenanceMaterial{URI: repository}
+	if algorithm, hex := splitAlgorithm(digest); hex != "" {
+		material.Digest = map[string]string{algorithm: hex}
+	}
+	return material
+}
+
+func (m provenanceMaterial) subject() provenanceSubject {
+	return provenanceSubject{Name: m.URI, Digest: m.Digest}
+}
+
+func splitAlgorithm(digest string) (string, string) {
+	parts := strings.SplitN(digest, ":", 2)
+	if len(parts) != 2 {
+		return "", ""
+	}
+	return parts[0], parts[1]
+}
+
+func formatTime(t time.Time) string {
+	if t.IsZero() {
+		return ""
+	}
+	return t.UTC().Format(time.RFC3339)
+}
+
+// writeProvenance writes the provenance of the image built by build to
+// provenance.json and attaches it to the image if requested.
+func (in *In) writeProvenance(ctx context.Context, outDir, namespace, image string, build v1alpha2.Build, env oc.Environment, params ProvenanceParams, log Logger) (oc.Metadata, error) {
+	statement, err := json.MarshalIndent(newProvenance(image, build, env), "", "  ")
+	if err != nil {
+		return nil, err
+	}
+
+	err = ioutil.WriteFile(filepath.Join(outDir, provenanceFile), statement, 0644)
+	if err != nil {
+		return nil, err
+	}
+
+	if !params.Attach {
+		return nil, nil
+	}
+
+	digest, err := name.NewDigest(image)
+	if err != nil {
+		return nil, errors.Wrapf(err, "parsing image: %s", image)
+	}
+
+	envelope, err := dsseEnvelope(statement, params)
+	if err != nil {
+		return nil, err
+	}
+
+	opts, err := in.remoteOptions(ctx, namespace, digest, params.RegistryCredentials)
+	if err != nil {
+		return nil, err
+	}
+
+	attestation, err := static.NewAttestation(envelope,
+		static.WithLayerMediaType(dsseMediaType),
+		static.WithAnnotations(map[string]string{"predicateType": slsaProvenanceType}))
+	if err != nil {
+		return nil, err
+	}
+
+	attestationTag := digest.Context().Tag(cosignTag(digest.DigestStr(), "att"))
+	err = attach(digest, ociremote.WithRemoteOptions(opts...), func(entity oci.SignedEntity) (oci.SignedEntity, error) {
+		return mutate.AttachAttestationToEntity(entity, attestation)
+	}, ociremote.WriteAttestations)
+	if err != nil {
+		return nil, errors.Wrapf(err, "pushing attestation %s", attestationTag)
+	}
+
+	log.Infof("Attached provenance to %s.\n", digest)
+
+	return oc.Metadata{{Name: "attestation", Value: attestationTag.String()}}, nil
+}
+
+// dsseEnvelope wraps the statement in a DSSE envelope signed with the key
+// the way cosign attest does.
+func dsseEnvelope(statement []byte, params ProvenanceParams) ([]byte, error) {
+	type signature struct {
+		KeyID string `json:"keyid"`
+		Sig   string `json:"sig"`
+	}
+
+	signer, err := loadSigner([]byte(params.Key), []byte(params.KeyPassword))
+	if err != nil {
+		return nil, err
+	}
+
+	sig, err := signer.SignMessage(bytes.NewReader(preAuthEncoding(inTotoPayloadType, statement)))
+	if err != nil {
+		return nil, err
+	}
+	signatures := []signature{{Sig: base64.StdEncoding.EncodeToString(sig)}}
+
+	return json.Marshal(struct {
+		PayloadType string      `json:"payloadType"`
+		Payload     string      `json:"payload"`
+		Signatures  []signature `json:"signatures"`
+	}{
+		PayloadType: inTotoPayloadType,
+		Payload:     base64.StdEncoding.EncodeToString(statement),
+		Signatures:  signatures,
+	})
+}
+
+// preAuthEncoding is the DSSE v1 encoding that is signed in place of the
+// payload.
+func preAuthEncoding(payloadType string, payload []byte) []byte {
+	return []byte(fmt.Sprintf("DSSEv1 %d %s %d %s", len(payloadType), payloadType, len(payload), payload))
+}
+
+// concourseBuild identifies the Concourse build a step runs in.
+type concourseBuild struct {
+	Team     string
+	Pipeline string
+	Job      string
+	ID       string
+	Name     string
+	ATCURL   string
+}
+
+func newConcourseBuild(env oc.Environment) concourseBuild {
+	if env == nil {
+		return concourseBuild{}
+	}
+
+	return concourseBuild{
+		Team:     env.Get("BUILD_TEAM_NAME"),
+		Pipeline: env.Get("BUILD_PIPELINE_NAME"),
+		Job:      env.Get("BUILD_JOB_NAME"),
+		ID:       env.Get("BUILD_ID"),
+		Name:     env.Get("BUILD_NAME"),
+		ATCURL:   strings.TrimSuffix(env.Get("ATC_EXTERNAL_URL"), "/"),
+	}
+}
+
+// URL links to the build in the Concourse UI.
+func (b concourseBuild) URL() string {
+	switch {
+	case b.ATCURL == "":
+		return ""
+	case b.Team != "" && b.Pipeline != "" && b.Job != "" && b.Name != "":
+		return fmt.Sprintf("%s/teams/%s/pipelines/%s/jobs/%s/builds/%s", b.ATCURL, b.Team, b.Pipeline, b.Job, b.Name)
+	case b.ID != "":
+		return fmt.Sprintf("%s/builds/%s", b.ATCURL, b.ID)
+	default:
+		return ""
+	}
+}
+
+func (b concourseBuild) environment() map[string]string {
+	environment := map[string]string{}
+	for key, value := range map[string]string{
+		"team":     b.Team,
+		"pipeline": b.Pipeline,
+		"job":      b.Job,
+		"buildId":  b.ID,
+		"build":    b.Name,
+		"atcUrl":   b.ATCURL,
+		"buildUrl": b.URL(),
+	} {
+		if value != "" {
+			environment[key] = value
+		}
+	}
+
+	if len(environment) == 0 {
+		return nil
+	}
+	return environment
+}
@@ -0,0 +1,268 @@
+// Copyright 2020-Present VMware, Inc.
+// SPDX-License-Identifier: Apache-2.0
+
+package resource_test
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	oc "github.com/cloudboss/ofcourse/ofcourse"
+	"github.com/google/go-containerregistry/pkg/name"
+	"github.com/google/go-containerregistry/pkg/registry"
+	"github.com/google/go-containerregistry/pkg/v1/random"
+	"github.com/google/go-containerregistry/pkg/v1/remote"
+	"github.com/pivotal/kpack/pkg/apis/build/v1alpha2"
+	corev1alpha1 "github.com/pivotal/kpack/pkg/apis/core/v1alpha1"
+	"github.com/sclevine/spec"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	corev1 "k8s.io/api/core/v1"
+	v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+
+	"github.com/pivotal/concourse-kpack-resource/resource"
+)
+
+func TestProvenance(t *testing.T) {
+	spec.Run(t, "TestProvenance", testProvenance)
+}
+
+func testProvenance(t *testing.T, when spec.G, it spec.S) {
+	const namespace = "test-namespace"
+
+	var (
+		dir    string
+		server *httptest.Server
+		built  name.Digest
+		build  *v1alpha2.Build
+
+		started  = time.Date(2022, 11, 3, 10, 0, 0, 0, time.UTC)
+		finished = started.Add(3 * time.Minute)
+
+		env = map[string]string{
+			"BUILD_TEAM_NAME":     "main",
+			"BUILD_PIPELINE_NAME": "shop",
+			"BUILD_JOB_NAME":      "deploy",
+			"BUILD_ID":            "1234",
+			"BUILD_NAME":          "7",
+			"ATC_EXTERNAL_URL":    "https://ci.example.com/",
+		}
+	)
+
+	it.Before(func() {
+		var err error
+		dir, err = ioutil.TempDir("", "provenance_test")
+		require.NoError(t, err)
+
+		server = httptest.NewServer(registry.New(registry.Logger(log.New(ioutil.Discard, "", 0))))
+		host := strings.TrimPrefix(server.URL, "http://")
+
+		builtImage, err := random.Image(1024, 1)
+		require.NoError(t, err)
+		digest, err := builtImage.Digest()
+		require.NoError(t, err)
+
+		built, err = name.NewDigest(host + "/dev/app@" + digest.String())
+		require.NoError(t, err)
+		require.NoError(t, remote.Write(built, builtImage))
+
+		build = &v1alpha2.Build{
+			ObjectMeta: v1.ObjectMeta{
+				Name:      "app-build-3",
+				Namespace: namespace,
+				UID:       "some-uid",
+				Labels: map[string]string{
+					v1alpha2.ImageLabel:       "app",
+					v1alpha2.BuildNumberLabel: "3",
+				},
+				Annotations: map[string]string{
+					v1alpha2.BuildReasonAnnotation: "COMMIT",
+				},
+				CreationTimestamp: v1.Time{Time: started},
+			},
+			Spec: v1alpha2.BuildSpec{
+				Builder: corev1alpha1.BuildBuilderSpec{
+					Image: "some.reg.io/builder@sha256:b1",
+				},
+				Source: corev1alpha1.SourceConfig{
+					Git: &corev1alpha1.Git{
+						URL:      "https://github.com/example/app",
+						Revision: "abc123",
+					},
+					SubPath: "api",
+				},
+			},
+			Status: v1alpha2.BuildStatus{
+				Status: corev1alpha1.Status{
+					Conditions: corev1alpha1.Conditions{
+						{
+							Type:               corev1alpha1.ConditionSucceeded,
+							Status:             corev1.ConditionTrue,
+							LastTransitionTime: corev1alpha1.VolatileTime{Inner: v1.Time{Time: finished}},
+						},
+					},
+				},
+				BuildMetadata: corev1alpha1.BuildpackMetadataList{
+					{Id: "paketo-buildpacks/java", Version: "9.1.0"},
+				},
+				Stack: corev1alpha1.BuildStack{
+					RunImage: "some.reg.io/run@sha256:r1",
+					ID:       "io.buildpacks.stacks.jammy",
+				},
+				LatestImage: built.String(),
+			},
+		}
+	})
+
+	it.After(func() {
+		server.Close()
+		os.RemoveAll(dir)
+	})
+
+	expectedStatement := func() string {
+		return fmt.Sprintf(`{
+  "_type": "https://in-toto.io/Statement/v0.1",
+  "predicateType": "https://slsa.dev/provenance/v0.2",
+  "subject": [{"name": %q, "digest": {"sha256": %q}}],
+  "predicate": {
+    "builder": {"id": "some.reg.io/builder@sha256:b1"},
+    "buildType": "https://kpack.io/Build@v1alpha2",
+    "invocation": {
+      "configSource": {"uri": "git+https://github.com/example/app", "digest": {"sha1": "abc123"}, "entryPoint": "api"},
+      "parameters": {"image": "app", "buildName": "app-build-3", "buildReason": "COMMIT"},
+      "environment": {
+        "team": "main",
+        "pipeline": "shop",
+        "job": "deploy",
+        "buildId": "1234",
+        "build": "7",
+        "atcUrl": "https://ci.example.com",
+        "buildUrl": "https://ci.example.com/teams/main/pipelines/shop/jobs/deploy/builds/7"
+      }
+    },
+    "metadata": {
+      "buildInvocationId": "some-uid",
+      "buildStartedOn": "2022-11-03T10:00:00Z",
+      "buildFinishedOn": "2022-11-03T10:03:00Z",
+      "reproducible": false
+    },
+    "materials": [
+      {"uri": "git+https://github.com/example/app", "digest": {"sha1": "abc123"}},
+      {"uri": "some.reg.io/builder", "digest": {"sha256": "b1"}},
+      {"uri": "some.reg.io/run", "digest": {"sha256": "r1"}},
+      {"uri": "pkg:cnb/paketo-buildpacks/java@9.1.0"}
+    ]
+  }
+}`, built.Context().Name(), strings.TrimPrefix(built.DigestStr(), "sha256:"))
+	}
+
+	source := resource.Source{
+		Image:     "app",
+		Namespace: namespace,
+	}
+
+	baseMetadata := oc.Metadata{
+		{Name: "buildNumber", Value: "3"},
+		{Name: "buildName", Value: "app-build-3"},
+		{Name: "buildReason", Value: "COMMIT"},
+		{Name: "gitCommit", Value: "abc123"},
+		{Name: "gitUrl", Value: "https://github.com/example/app"},
+	}
+
+	it("writes the provenance of the build", func() {
+		InTest{
+			Objects:          []runtime.Object{build},
+			OutDir:           dir,
+			Source:           source,
+			Parameters:       oc.Params{"provenance": map[string]interface{}{}},
+			Version:          oc.Version{"image": built.String()},
+			Environment:      env,
+			ExpectedVersion:  oc.Version{"image": built.String()},
+			ExpectedMetadata: baseMetadata,
+		}.test(t)
+
+		contents, err := ioutil.ReadFile(filepath.Join(dir, "provenance.json"))
+		require.NoError(t, err)
+		assert.JSONEq(t, expectedStatement(), string(contents))
+	})
+
+	it("attaches the provenance as a signed attestation", func() {
+		privateKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+		require.NoError(t, err)
+		der, err := x509.MarshalPKCS8PrivateKey(privateKey)
+		require.NoError(t, err)
+
+		attestationTag := built.Context().Tag(strings.Replace(built.DigestStr(), ":", "-", 1) + ".att")
+
+		InTest{
+			Objects: []runtime.Object{build},
+			OutDir:  dir,
+			Source:  source,
+			Parameters: oc.Params{
+				"provenance": map[string]interface{}{
+					"attach": true,
+					"key":    string(pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der})),
+				},
+			},
+			Version:          oc.Version{"image": built.String()},
+			Environment:      env,
+			ExpectedVersion:  oc.Version{"image": built.String()},
+			ExpectedMetadata: append(baseMetadata, oc.NameVal{Name: "attestation", Value: attestationTag.String()}),
+		}.test(t)
+
+		attestations, err := remote.Image(attestationTag)
+		require.NoError(t, err)
+		manifest, err := attestations.Manifest()
+		require.NoError(t, err)
+		require.Len(t, manifest.Layers, 1)
+		assert.Equal(t, "application/vnd.dsse.envelope.v1+json", string(manifest.Layers[0].MediaType))
+		assert.Equal(t, "https://slsa.dev/provenance/v0.2", manifest.Layers[0].Annotations["predicateType"])
+
+		layer, err := attestations.LayerByDigest(manifest.Layers[0].Digest)
+		require.NoError(t, err)
+		contents, err := layer.Compressed()
+		require.NoError(t, err)
+		defer contents.Close()
+
+		var envelope struct {
+			PayloadType string `json:"payloadType"`
+			Payload     []byte `json:"payload"`
+			Signatures  []struct {
+				Sig []byte `json:"sig"`
+			} `json:"signatures"`
+		}
+		require.NoError(t, json.NewDecoder(contents).Decode(&envelope))
+		assert.Equal(t, "application/vnd.in-toto+json", envelope.PayloadType)
+		assert.JSONEq(t, expectedStatement(), string(envelope.Payload))
+
+		require.Len(t, envelope.Signatures, 1)
+		pae := fmt.Sprintf("DSSEv1 %d %s %d %s", len(envelope.PayloadType), envelope.PayloadType, len(envelope.Payload), envelope.Payload)
+		hash := sha256.Sum256([]byte(pae))
+		assert.True(t, ecdsa.VerifyASN1(&privateKey.PublicKey, hash[:], envelope.Signatures[0].Sig))
+	})
+
+	it("returns an error when the build that produced the image is gone", func() {
+		InTest{
+			OutDir:      dir,
+			Source:      source,
+			Parameters:  oc.Params{"provenance": map[string]interface{}{}},
+			Version:     oc.Version{"image": built.String()},
+			ExpectError: "provenance and policy require the build that produced '" + built.String() + "'",
+		}.test(t)
+	})
+}
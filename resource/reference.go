@@ -0,0 +1,62 @@
+// Copyright 2020-Present VMware, Inc.
+// SPDX-License-Identifier: Apache-2.0
+
+package resource
+
+import (
+	"strings"
+
+	oc "github.com/cloudboss/ofcourse/ofcourse"
+)
+
+const defaultTag = "latest"
+
+// imageVersion is the version of an image built by kpack. The digest is
+// included so tools expecting registry-image versions can use it.
+func imageVersion(latestImage string) oc.Version {
+	version := oc.Version{"image": latestImage}
+	if _, digest := splitDigest(latestImage); digest != "" {
+		version["digest"] = digest
+	}
+	return version
+}
+
+// splitDigest splits a reference such as repo@sha256:... into its
+// repository and digest.
+func splitDigest(ref string) (string, string) {
+	if i := strings.Index(ref, "@"); i >= 0 {
+		return ref[:i], ref[i+1:]
+	}
+	return ref, ""
+}
+
+// splitTag splits a reference such as repo:tag into its repository and tag,
+// defaulting the tag to latest.
+func splitTag(ref string) (string, string) {
+	ref, _ = splitDigest(ref)
+	if i := strings.LastIndex(ref, ":"); i > strings.LastIndex(ref, "/") {
+		return ref[:i], ref[i+1:]
+	}
+	return ref, defaultTag
+}
+
+// imageRepository strips the tag and digest from an image reference.
+func imageRepository(ref string) string {
+	repository, _ := splitTag(ref)
+	return repository
+}
+
+// bareTags returns the tag of each reference, such as v1.4.2 for
+// repo:v1.4.2, without duplicates.
+func bareTags(refs []string) []string {
+	seen := map[string]bool{}
+	tags := make([]string, 0, len(refs))
+	for _, ref := range refs {
+		_, tag := splitTag(ref)
+		if !seen[tag] {
+			seen[tag] = true
+			tags = append(tags, tag)
+		}
+	}
+	return tags
+}
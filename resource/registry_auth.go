@@ -0,0 +1,98 @@
+// Copyright 2020-Present VMware, Inc.
+// SPDX-License-Identifier: Apache-2.0
+
+package resource
+
+import (
+	"context"
+	"encoding/json"
+
+	"github.com/google/go-containerregistry/pkg/authn"
+	"github.com/google/go-containerregistry/pkg/name"
+	"github.com/google/go-containerregistry/pkg/v1/remote"
+	"github.com/pivotal/kpack/pkg/dockercreds"
+	"github.com/pkg/errors"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+)
+
+// RegistryCredentials authenticate to image registries with docker-registry
+// secrets in the source namespace or a username and password.
+type RegistryCredentials struct {
+	Secrets  []string `json:"secrets,omitempty"`
+	Username string   `json:"username,omitempty"`
+	Password string   `json:"password,omitempty"`
+}
+
+// registryKeychain authenticates to registry with the username and password
+// and to any registry in the docker-registry secrets, falling back to the
+// default keychain.
+func registryKeychain(ctx context.Context, kubeClient kubernetes.Interface, namespace, registry string, creds RegistryCredentials) (authn.Keychain, error) {
+	var keychains []authn.Keychain
+
+	if creds.Username != "" {
+		keychains = append(keychains, staticKeychain{
+			registry: registry,
+			auth:     authn.AuthConfig{Username: creds.Username, Password: creds.Password},
+		})
+	}
+
+	for _, secretName := range creds.Secrets {
+		if kubeClient == nil {
+			return nil, errors.Errorf("reading secret '%s' requires a kubernetes client", secretName)
+		}
+
+		secret, err := kubeClient.CoreV1().Secrets(namespace).Get(ctx, secretName, metav1.GetOptions{})
+		if err != nil {
+			return nil, err
+		}
+
+		dockerCreds, err := dockerCredsFromSecret(secret)
+		if err != nil {
+			return nil, err
+		}
+		keychains = append(keychains, dockerCreds)
+	}
+
+	return authn.NewMultiKeychain(append(keychains, authn.DefaultKeychain)...), nil
+}
+
+// remoteOptions authenticate in's registry requests for ref.
+func (in *In) remoteOptions(ctx context.Context, namespace string, ref name.Reference, creds RegistryCredentials) ([]remote.Option, error) {
+	keychain, err := registryKeychain(ctx, in.KubeClient, namespace, ref.Context().RegistryStr(), creds)
+	if err != nil {
+		return nil, err
+	}
+	return []remote.Option{remote.WithContext(ctx), remote.WithAuthFromKeychain(keychain)}, nil
+}
+
+func dockerCredsFromSecret(secret *corev1.Secret) (dockercreds.DockerCreds, error) {
+	switch secret.Type {
+	case corev1.SecretTypeDockerConfigJson:
+		var config struct {
+			Auths dockercreds.DockerCreds `json:"auths"`
+		}
+		err := json.Unmarshal(secret.Data[corev1.DockerConfigJsonKey], &config)
+		return config.Auths, errors.Wrapf(err, "parsing secret '%s'", secret.Name)
+	case corev1.SecretTypeDockercfg:
+		var dockerCreds dockercreds.DockerCreds
+		err := json.Unmarshal(secret.Data[corev1.DockerConfigKey], &dockerCreds)
+		return dockerCreds, errors.Wrapf(err, "parsing secret '%s'", secret.Name)
+	default:
+		return nil, errors.Errorf("secret '%s' is not a docker-registry secret", secret.Name)
+	}
+}
+
+// staticKeychain provides a single set of credentials for one registry.
+type staticKeychain struct {
+	registry string
+	auth     authn.AuthConfig
+}
+
+func (k staticKeychain) Resolve(resource authn.Resource) (authn.Authenticator, error) {
+	if resource.RegistryStr() != k.registry {
+		return authn.Anonymous, nil
+	}
+	return authn.FromConfig(k.auth), nil
+}
@@ -0,0 +1,77 @@
+// Copyright 2020-Present VMware, Inc.
+// SPDX-License-Identifier: Apache-2.0
+
+package resource
+
+import (
+	"context"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+
+	oc "github.com/cloudboss/ofcourse/ofcourse"
+	"github.com/pivotal/kpack/pkg/apis/build/v1alpha2"
+	corev1alpha1 "github.com/pivotal/kpack/pkg/apis/core/v1alpha1"
+	"github.com/pkg/errors"
+)
+
+// rollbackImage restores the source of image to that of the build which
+// produced the image in the rollback_to file, and returns that image.
+func rollbackImage(ctx context.Context, kpackClient KpackClient, inDir string, image *v1alpha2.Image, params OutParams, log Logger) (string, error) {
+	if params.Commitish != "" || params.GitUrl != "" || params.BlobUrlFile != "" || params.BlobStripComponents != nil || params.SubPath != nil {
+		return "", errors.Errorf("rollback_to cannot be combined with commitish, git_url, blob_url_file, blob_strip_components or sub_path")
+	}
+
+	fileContents, err := ioutil.ReadFile(filepath.Join(inDir, params.RollbackTo))
+	if err != nil {
+		return "", errors.Wrapf(err, "reading rollback_to: %s", params.RollbackTo)
+	}
+	target := strings.TrimSpace(string(fileContents))
+
+	builds, err := listImageBuilds(ctx, kpackClient, Source{Namespace: image.Namespace, Image: image.Name})
+	if err != nil {
+		return "", err
+	}
+
+	index, ok := indexOfBuild(builds, oc.Version{"image": target})
+	if !ok {
+		return "", errors.Errorf("no build of image '%s' produced '%s'", image.Name, target)
+	}
+	build := builds[index]
+
+	log.Infof("Rolling back to %s from build '%s'.\nPrevious source: %s\nNew source: %s\n\n",
+		target, build.Name, red(sourceString(image.Spec.Source)), green(sourceString(build.Spec.Source)))
+
+	image.Spec.Source = *build.Spec.Source.DeepCopy()
+	return target, nil
+}
+
+// reportRollback reports whether kpack reproduced the image rolled back to.
+func reportRollback(log Logger, target, resultingImage string) {
+	_, targetDigest := splitDigest(target)
+	_, resultingDigest := splitDigest(resultingImage)
+
+	if targetDigest != "" && targetDigest == resultingDigest {
+		log.Infof(green("Rollback confirmed: kpack produced the identical image %s.\n"), resultingImage)
+		return
+	}
+
+	log.Warnf("Rollback built %s, which differs from %s. The source was restored but kpack did not reproduce the identical image.\n", resultingImage, target)
+}
+
+func sourceString(source corev1alpha1.SourceConfig) string {
+	var s string
+	switch {
+	case source.Git != nil:
+		s = source.Git.URL + "@" + source.Git.Revision
+	case source.Blob != nil:
+		s = source.Blob.URL
+	case source.Registry != nil:
+		s = source.Registry.Image
+	}
+
+	if source.SubPath != "" {
+		s += " (subPath: " + source.SubPath + ")"
+	}
+	return s
+}
@@ -0,0 +1,196 @@
+// Copyright 2020-Present VMware, Inc.
+// SPDX-License-Identifier: Apache-2.0
+
+package resource_test
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	oc "github.com/cloudboss/ofcourse/ofcourse"
+	"github.com/pivotal/kpack/pkg/apis/build/v1alpha2"
+	corev1alpha1 "github.com/pivotal/kpack/pkg/apis/core/v1alpha1"
+	"github.com/sclevine/spec"
+	"github.com/stretchr/testify/require"
+	corev1 "k8s.io/api/core/v1"
+	v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	clientgotesting "k8s.io/client-go/testing"
+
+	"github.com/pivotal/concourse-kpack-resource/resource"
+)
+
+func TestRollback(t *testing.T) {
+	spec.Run(t, "TestRollback", testRollback)
+}
+
+func testRollback(t *testing.T, when spec.G, it spec.S) {
+	const (
+		namespace     = "test-namespace"
+		previousImage = "some.reg.io/image@sha256:aaa"
+		currentImage  = "some.reg.io/image@sha256:bbb"
+	)
+
+	var (
+		inDir          string
+		image          *v1alpha2.Image
+		objects        []runtime.Object
+		firstBuildTime = time.Now()
+	)
+
+	build := func(name, revision, latestImage string, created time.Duration) *v1alpha2.Build {
+		return &v1alpha2.Build{
+			ObjectMeta: v1.ObjectMeta{
+				Name:      name,
+				Namespace: namespace,
+				Labels: map[string]string{
+					v1alpha2.ImageLabel: "test",
+				},
+				CreationTimestamp: v1.Time{Time: firstBuildTime.Add(created)},
+			},
+			Spec: v1alpha2.BuildSpec{
+				Source: corev1alpha1.SourceConfig{
+					Git: &corev1alpha1.Git{
+						URL:      "https://some.git.com",
+						Revision: revision,
+					},
+					SubPath: "app",
+				},
+			},
+			Status: v1alpha2.BuildStatus{
+				Status: corev1alpha1.Status{
+					Conditions: corev1alpha1.Conditions{
+						{Type: corev1alpha1.ConditionSucceeded, Status: corev1.ConditionTrue},
+					},
+				},
+				LatestImage: latestImage,
+			},
+		}
+	}
+
+	it.Before(func() {
+		var err error
+		inDir, err = ioutil.TempDir("", "rollback_test")
+		require.NoError(t, err)
+
+		err = ioutil.WriteFile(filepath.Join(inDir, "image"), []byte(previousImage+"\n"), 0644)
+		require.NoError(t, err)
+
+		image = &v1alpha2.Image{
+			ObjectMeta: v1.ObjectMeta{
+				Name:      "test",
+				Namespace: namespace,
+			},
+			Spec: v1alpha2.ImageSpec{
+				Source: corev1alpha1.SourceConfig{
+					Git: &corev1alpha1.Git{
+						URL:      "https://some.git.com",
+						Revision: "bad-revision",
+					},
+					SubPath: "app",
+				},
+			},
+		}
+
+		objects = []runtime.Object{
+			image,
+			build("test-build-1", "good-revision", previousImage, 0),
+			build("test-build-2", "bad-revision", currentImage, time.Minute),
+		}
+	})
+
+	it.After(func() {
+		os.RemoveAll(inDir)
+	})
+
+	source := resource.Source{
+		Image:     "test",
+		Namespace: namespace,
+	}
+
+	it("restores the source of the build and confirms an identical image", func() {
+		rolledBack := image.DeepCopy()
+		rolledBack.Spec.Source.Git.Revision = "good-revision"
+
+		OutTest{
+			InDir:   inDir,
+			Objects: objects,
+			Source:  source,
+			Parameters: resource.OutParams{
+				RollbackTo: "image",
+			},
+			TerminalImage: previousImage,
+			ExpectedOutput: []string{
+				"Rolling back to some.reg.io/image@sha256:aaa from build 'test-build-1'",
+				"Previous source", "https://some.git.com@bad-revision (subPath: app)",
+				"New source", "https://some.git.com@good-revision (subPath: app)",
+				"Rollback confirmed: kpack produced the identical image some.reg.io/image@sha256:aaa",
+			},
+			ExpectUpdates: []clientgotesting.UpdateActionImpl{
+				{Object: rolledBack},
+			},
+			ExpectedImageToWaitOn: rolledBack,
+			ExpectedVersion: oc.Version{
+				"image":  previousImage,
+				"digest": "sha256:aaa",
+			},
+		}.test(t)
+	})
+
+	it("reports when kpack builds a different image", func() {
+		rolledBack := image.DeepCopy()
+		rolledBack.Spec.Source.Git.Revision = "good-revision"
+
+		OutTest{
+			InDir:   inDir,
+			Objects: objects,
+			Source:  source,
+			Parameters: resource.OutParams{
+				RollbackTo: "image",
+			},
+			TerminalImage: "some.reg.io/image@sha256:ccc",
+			ExpectedOutput: []string{
+				"Rollback built some.reg.io/image@sha256:ccc, which differs from some.reg.io/image@sha256:aaa",
+			},
+			ExpectUpdates: []clientgotesting.UpdateActionImpl{
+				{Object: rolledBack},
+			},
+			ExpectedImageToWaitOn: rolledBack,
+			ExpectedVersion: oc.Version{
+				"image":  "some.reg.io/image@sha256:ccc",
+				"digest": "sha256:ccc",
+			},
+		}.test(t)
+	})
+
+	it("returns an error when no build produced the image", func() {
+		err := ioutil.WriteFile(filepath.Join(inDir, "image"), []byte("some.reg.io/image@sha256:unknown"), 0644)
+		require.NoError(t, err)
+
+		OutTest{
+			InDir:   inDir,
+			Objects: objects,
+			Source:  source,
+			Parameters: resource.OutParams{
+				RollbackTo: "image",
+			},
+			ExpectError: "no build of image 'test' produced 'some.reg.io/image@sha256:unknown'",
+		}.test(t)
+	})
+
+	it("returns an error when combined with a source param", func() {
+		OutTest{
+			InDir:   inDir,
+			Objects: objects,
+			Source:  source,
+			Parameters: resource.OutParams{
+				RollbackTo: "image",
+				GitUrl:     "https://other.git.com",
+			},
+			ExpectError: "rollback_to cannot be combined with commitish, git_url, blob_url_file, blob_strip_components or sub_path",
+		}.test(t)
+	})
+}
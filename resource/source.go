@@ -4,23 +4,107 @@
 package resource
 
 import (
-	"encoding/json"
+	"fmt"
+	"strings"
 
 	oc "github.com/cloudboss/ofcourse/ofcourse"
-)
 
-func NewSource(ocSource oc.Source) (Source, error) {
-	marshal, err := json.Marshal(ocSource)
-	if err != nil {
-		return Source{}, err
-	}
+	"github.com/pivotal/concourse-kpack-resource/config"
+)
 
+// NewSource strictly decodes the source. Other configuration read from the
+// same source, such as the cluster to connect to, is decoded into others so
+// that their keys are not reported as unknown.
+func NewSource(ocSource oc.Source, others ...interface{}) (Source, error) {
 	source := Source{}
-	err = json.Unmarshal(marshal, &source)
+	err := config.Decode("source", ocSource, append([]interface{}{&source}, others...)...)
 	return source, err
 }
 
+const (
+	KindImage          = "image"
+	KindBuilder        = "builder"
+	KindClusterBuilder = "clusterbuilder"
+	KindClusterStack   = "clusterstack"
+	KindClusterStore   = "clusterstore"
+)
+
 type Source struct {
-	Image     string `json:"image"`
+	Kind  string `json:"kind,omitempty"`
+	Image string `json:"image"`
+	Name  string `json:"name,omitempty"`
+
+	Images        []string `json:"images,omitempty"`
+	LabelSelector string   `json:"label_selector,omitempty"`
+
 	Namespace string `json:"namespace"`
+
+	LogLevel  string `json:"log_level,omitempty"`
+	LogFormat string `json:"log_format,omitempty"`
+	NoColor   bool   `json:"no_color,omitempty"`
+
+	Metrics *MetricsConfig `json:"metrics,omitempty"`
+
+	SkipPreflight bool `json:"skip_preflight,omitempty"`
+}
+
+// resourceKind returns the kind of kpack resource tracked, defaulting to image.
+func (s Source) resourceKind() string {
+	if s.Kind == "" {
+		return KindImage
+	}
+	return strings.ToLower(s.Kind)
+}
+
+// multiImage reports whether the source tracks several images.
+func (s Source) multiImage() bool {
+	return len(s.Images) > 0 || s.LabelSelector != ""
+}
+
+// Validate reports the keys the kind of resource requires that are missing.
+func (s Source) Validate() []string {
+	var problems []string
+
+	switch kind := s.resourceKind(); kind {
+	case KindImage:
+		if s.Image == "" && !s.multiImage() {
+			problems = append(problems, "one of 'image', 'images' or 'label_selector' is required")
+		}
+		if s.Image != "" && s.multiImage() {
+			keys := []string{"'image'"}
+			if len(s.Images) > 0 {
+				keys = append(keys, "'images'")
+			}
+			if s.LabelSelector != "" {
+				keys = append(keys, "'label_selector'")
+			}
+			problems = append(problems, fmt.Sprintf("only one of 'image' or 'images' with 'label_selector' may be set, got %s", strings.Join(keys, " and ")))
+		}
+		if s.Namespace == "" {
+			problems = append(problems, "missing required key 'namespace'")
+		}
+	case KindBuilder:
+		if s.Name == "" {
+			problems = append(problems, "missing required key 'name'")
+		}
+		if s.Namespace == "" {
+			problems = append(problems, "missing required key 'namespace'")
+		}
+	case KindClusterBuilder, KindClusterStack, KindClusterStore:
+		if s.Name == "" {
+			problems = append(problems, "missing required key 'name'")
+		}
+	default:
+		problems = append(problems, fmt.Sprintf("unsupported kind '%s'", s.Kind))
+	}
+
+	if _, ok := logLevels[strings.ToLower(s.LogLevel)]; s.LogLevel != "" && !ok {
+		problems = append(problems, fmt.Sprintf("unsupported log_level '%s'", s.LogLevel))
+	}
+
+	if format := strings.ToLower(s.LogFormat); format != "" && format != LogFormatText && format != LogFormatJSON {
+		problems = append(problems, fmt.Sprintf("unsupported log_format '%s'", s.LogFormat))
+	}
+
+	return problems
 }
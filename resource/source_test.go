@@ -0,0 +1,276 @@
+// Copyright 2020-Present VMware, Inc.
+// SPDX-License-Identifier: Apache-2.0
+
+package resource_test
+
+import (
+	"testing"
+
+	oc "github.com/cloudboss/ofcourse/ofcourse"
+	"github.com/sclevine/spec"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/pivotal/concourse-kpack-resource/k8s"
+	"github.com/pivotal/concourse-kpack-resource/resource"
+)
+
+func TestSource(t *testing.T) {
+	spec.Run(t, "TestSource", testSource)
+}
+
+func testSource(t *testing.T, when spec.G, it spec.S) {
+	when("NewSource", func() {
+		it("decodes the resource and cluster configuration", func() {
+			var k8sSource k8s.Source
+			source, err := resource.NewSource(oc.Source{
+				"image":     "app",
+				"namespace": "apps",
+				"log_level": "debug",
+				"metrics": map[string]interface{}{
+					"pushgateway_url": "http://pushgateway:9091",
+				},
+				"gke": map[string]interface{}{
+					"json_key": "{}",
+					"project":  "project",
+					"location": "us-central1",
+					"cluster":  "cluster",
+				},
+			}, &k8sSource)
+			require.NoError(t, err)
+
+			assert.Equal(t, resource.Source{
+				Image:     "app",
+				Namespace: "apps",
+				LogLevel:  "debug",
+				Metrics:   &resource.MetricsConfig{PushgatewayURL: "http://pushgateway:9091"},
+			}, source)
+			assert.Equal(t, k8s.Source{
+				GKE: &k8s.GKESource{JSONKey: "{}", Project: "project", Location: "us-central1", Cluster: "cluster"},
+			}, k8sSource)
+		})
+
+		it("lists every problem with the source", func() {
+			var k8sSource k8s.Source
+			_, err := resource.NewSource(oc.Source{
+				"image":     "app",
+				"namspace":  "apps",
+				"log_level": "loud",
+				"metrics": map[string]interface{}{
+					"pushgateway": "http://pushgateway:9091",
+				},
+				"kubeconfig": "apiVersion: v1",
+				"eks": map[string]interface{}{
+					"cluster": "cluster",
+				},
+			}, &k8sSource)
+
+			require.EqualError(t, err, "invalid source:\n"+
+				"  - unknown key 'metrics.pushgateway'\n"+
+				"  - unknown key 'namspace'\n"+
+				"  - missing required key 'namespace'\n"+
+				"  - unsupported log_level 'loud'\n"+
+				"  - only one of 'pks', 'tkgi', 'gke', 'eks', 'oidc', 'kubeconfig' or 'in_cluster' may be set, got 'eks' and 'kubeconfig'\n"+
+				"  - missing required key 'eks.region'")
+		})
+
+		it("requires a way of connecting to the cluster", func() {
+			var k8sSource k8s.Source
+			_, err := resource.NewSource(oc.Source{
+				"kind": "clusterstack",
+				"name": "base",
+			}, &k8sSource)
+
+			require.EqualError(t, err, "invalid source:\n"+
+				"  - one of 'pks', 'tkgi', 'gke', 'eks', 'oidc', 'kubeconfig' or 'in_cluster' is required")
+		})
+
+		it("rejects an image with images or a label_selector", func() {
+			var k8sSource k8s.Source
+			_, err := resource.NewSource(oc.Source{
+				"image":          "app",
+				"images":         []string{"api", "web"},
+				"label_selector": "app.kubernetes.io/part-of=shop",
+				"namespace":      "apps",
+				"in_cluster":     true,
+			}, &k8sSource)
+
+			require.EqualError(t, err, "invalid source:\n"+
+				"  - only one of 'image' or 'images' with 'label_selector' may be set, got 'image' and 'images' and 'label_selector'")
+		})
+
+		for _, tc := range []struct {
+			name     string
+			pks      map[string]interface{}
+			expected string
+		}{
+			{
+				name:     "a password grant without credentials",
+				pks:      map[string]interface{}{"api": "https://pks.example.com", "cluster": "cluster"},
+				expected: "  - missing required key 'pks.username'\n  - missing required key 'pks.password'",
+			},
+			{
+				name:     "a password grant without a password",
+				pks:      map[string]interface{}{"api": "https://pks.example.com", "cluster": "cluster", "grant_type": "password", "username": "admin"},
+				expected: "  - missing required key 'pks.password'",
+			},
+			{
+				name:     "an unsupported grant type",
+				pks:      map[string]interface{}{"api": "https://pks.example.com", "cluster": "cluster", "grant_type": "implicit"},
+				expected: "  - unsupported pks.grant_type 'implicit', must be 'password' or 'client_credentials'",
+			},
+		} {
+			tc := tc
+			it("reports "+tc.name, func() {
+				var k8sSource k8s.Source
+				_, err := resource.NewSource(oc.Source{
+					"image":     "app",
+					"namespace": "apps",
+					"pks":       tc.pks,
+				}, &k8sSource)
+
+				require.EqualError(t, err, "invalid source:\n"+tc.expected)
+			})
+		}
+
+		it("accepts a client credentials grant without a username and password", func() {
+			var k8sSource k8s.Source
+			_, err := resource.NewSource(oc.Source{
+				"image":     "app",
+				"namespace": "apps",
+				"pks": map[string]interface{}{
+					"api":           "https://pks.example.com",
+					"cluster":       "cluster",
+					"grant_type":    "client_credentials",
+					"client_secret": "secret",
+				},
+			}, &k8sSource)
+			require.NoError(t, err)
+		})
+
+		it("reports values of the wrong type", func() {
+			_, err := resource.NewSource(oc.Source{
+				"image":     "app",
+				"namespace": "apps",
+				"no_color":  "yes",
+			})
+
+			require.EqualError(t, err, "invalid source:\n"+
+				"  - key 'no_color' must be a boolean, got string")
+		})
+	})
+
+	when("NewOutParams", func() {
+		it("lists every problem with the params", func() {
+			_, err := resource.NewOutParams(oc.Params{
+				"commitish":     "source/.git/ref",
+				"blob_url_file": "blob/url",
+				"parallelism":   -1,
+				"timeout":       "10",
+				"promote": map[string]interface{}{
+					"image_file": "image/image",
+				},
+				"sign": map[string]interface{}{
+					"keyless": map[string]interface{}{
+						"fulcio_url": "https://fulcio.sigstore.dev",
+					},
+					"key_pasword": "secret",
+				},
+			})
+
+			require.EqualError(t, err, "invalid params:\n"+
+				"  - unknown key 'sign.key_pasword'\n"+
+				"  - 'commitish' or 'git_url' with 'blob_url_file' or 'blob_strip_components' with 'promote' cannot be combined\n"+
+				"  - 'timeout' must be a positive duration such as '30m', got '10'\n"+
+				"  - 'parallelism' must not be negative\n"+
+				"  - missing required key 'promote.repository'\n"+
+				"  - missing required key 'sign.keyless.rekor_url'\n"+
+				"  - missing required key 'sign.keyless.identity_token'")
+		})
+
+		for _, tc := range []struct {
+			name   string
+			params oc.Params
+		}{
+			{name: "no params", params: oc.Params{}},
+			{name: "only options", params: oc.Params{"dry_run": true, "timeout": "30m", "images": []string{"api"}}},
+		} {
+			tc := tc
+			it("requires something to update with "+tc.name, func() {
+				_, err := resource.NewOutParams(tc.params)
+
+				require.EqualError(t, err, "invalid params:\n"+
+					"  - one of 'commitish', 'git_url', 'blob_url_file', 'blob_strip_components', 'sub_path', 'tag', 'additional_tags', "+
+					"'additional_tags_file', 'rollback_to', 'promote', 'order_file', 'stack', 'build_image_file', 'run_image_file' or 'buildpackages_file' is required")
+			})
+		}
+
+		it("rejects signing with both a key and keyless", func() {
+			_, err := resource.NewOutParams(oc.Params{
+				"commitish": "source/.git/ref",
+				"sign": map[string]interface{}{
+					"key":       "private-key",
+					"rekor_url": "https://rekor.sigstore.dev",
+					"keyless": map[string]interface{}{
+						"fulcio_url":     "https://fulcio.sigstore.dev",
+						"rekor_url":      "https://rekor.sigstore.dev",
+						"identity_token": "token",
+					},
+				},
+			})
+
+			require.EqualError(t, err, "invalid params:\n"+
+				"  - exactly one of 'sign.key' or 'sign.keyless' is required\n"+
+				"  - 'sign.rekor_url' cannot be combined with 'sign.keyless', use 'sign.keyless.rekor_url'")
+		})
+
+		it("decodes valid params", func() {
+			params, err := resource.NewOutParams(oc.Params{
+				"commitish":       "source/.git/ref",
+				"additional_tags": []string{"latest"},
+			})
+			require.NoError(t, err)
+
+			assert.Equal(t, resource.OutParams{Commitish: "source/.git/ref", AdditionalTags: []string{"latest"}}, params)
+		})
+	})
+
+	when("NewInParams", func() {
+		it("rejects unknown keys", func() {
+			_, err := resource.NewInParams(oc.Params{
+				"verify": map[string]interface{}{
+					"public_key": "key",
+					"publickey":  "key",
+				},
+			})
+
+			require.EqualError(t, err, "invalid params:\n"+
+				"  - unknown key 'verify.publickey'")
+		})
+
+		it("requires a public key or a certificate identity with its issuer and rekor", func() {
+			_, err := resource.NewInParams(oc.Params{
+				"verify": map[string]interface{}{
+					"public_key":           "key",
+					"certificate_identity": "ci@example.com",
+				},
+			})
+
+			require.EqualError(t, err, "invalid params:\n"+
+				"  - exactly one of 'verify.public_key' or 'verify.certificate_identity' is required\n"+
+				"  - missing required key 'verify.certificate_oidc_issuer'\n"+
+				"  - missing required key 'verify.rekor_url'")
+		})
+
+		it("requires a key to attach provenance", func() {
+			_, err := resource.NewInParams(oc.Params{
+				"provenance": map[string]interface{}{
+					"attach": true,
+				},
+			})
+
+			require.EqualError(t, err, "invalid params:\n"+
+				"  - missing required key 'provenance.key'")
+		})
+	})
+}
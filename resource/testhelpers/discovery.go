@@ -0,0 +1,29 @@
+// Copyright 2020-Present VMware, Inc.
+// SPDX-License-Identifier: Apache-2.0
+
+package testhelpers
+
+import (
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// KpackDiscovery returns the discovery resources of a cluster serving kpack.io
+// up to the given version. An empty version serves v1alpha1 and v1alpha2.
+func KpackDiscovery(version string) []*metav1.APIResourceList {
+	versions := []string{"v1alpha1", "v1alpha2"}
+	if version == "v1alpha1" {
+		versions = []string{"v1alpha1"}
+	}
+
+	var resources []*metav1.APIResourceList
+	for _, version := range versions {
+		resources = append(resources, &metav1.APIResourceList{
+			GroupVersion: "kpack.io/" + version,
+			APIResources: []metav1.APIResource{
+				{Name: "images", Namespaced: true, Kind: "Image"},
+				{Name: "builds", Namespaced: true, Kind: "Build"},
+			},
+		})
+	}
+	return resources
+}
@@ -12,6 +12,14 @@ type Logger struct {
 	Out strings.Builder
 }
 
+func (l *Logger) Errorf(message string, args ...interface{}) {
+	l.Out.WriteString(fmt.Sprintf(message, args...) + "\n")
+}
+
+func (l *Logger) Warnf(message string, args ...interface{}) {
+	l.Out.WriteString(fmt.Sprintf(message, args...) + "\n")
+}
+
 func (l *Logger) Infof(message string, args ...interface{}) {
 	l.Out.WriteString(fmt.Sprintf(message, args...) + "\n")
 }